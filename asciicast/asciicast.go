@@ -0,0 +1,297 @@
+// Package asciicast reads, validates and writes terminal recordings in the
+// asciicast v2 format used by asciinema.
+//
+// A recording is a header line, a JSON object describing the terminal,
+// followed by one event per line, each a JSON array of the event's time in
+// seconds since the start of the recording, its type and its data:
+//
+//	{"version": 2, "width": 80, "height": 24}
+//	[0.25, "o", "hello\r\n"]
+//	[1.5, "m", "chapter 1"]
+package asciicast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"time"
+)
+
+// Version is the asciicast format version this package reads and writes.
+const Version = 2
+
+// Event types.
+const (
+	Output = "o" // data written to the terminal
+	Input  = "i" // data typed by the user
+	Marker = "m" // a named point in the recording, such as a chapter
+	Resize = "r" // the terminal was resized to the size "COLSxROWS"
+)
+
+// A Header describes a recording and the terminal it was made in.
+type Header struct {
+	Version       int               `json:"version"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Timestamp     int64             `json:"timestamp,omitempty"`       // Unix time of the start
+	Duration      float64           `json:"duration,omitempty"`        // in seconds
+	IdleTimeLimit float64           `json:"idle_time_limit,omitempty"` // in seconds
+	Command       string            `json:"command,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	Theme         *Theme            `json:"theme,omitempty"`
+}
+
+// A Theme is the color theme of the recorded terminal.
+type Theme struct {
+	Fg      string `json:"fg"`      // foreground color, as #rrggbb
+	Bg      string `json:"bg"`      // background color, as #rrggbb
+	Palette string `json:"palette"` // colon-separated list of 8 or 16 colors
+}
+
+// An Event is a single entry of a recording.
+type Event struct {
+	Time float64 // seconds since the start of the recording
+	Type string  // Output, Input, Marker or Resize
+	Data string
+}
+
+// A Cast is a complete recording.
+type Cast struct {
+	Header Header
+	Events []Event
+}
+
+// MarshalJSON encodes the event as an array of its time, type and data.
+// Times are written with microsecond precision.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return marshal([]any{math.Round(e.Time*1e6) / 1e6, e.Type, e.Data})
+}
+
+// UnmarshalJSON decodes an event from an array of its time, type and data.
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("event is not an array")
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("event has %d fields, want 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return fmt.Errorf("event time is not a number")
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return fmt.Errorf("event type is not a string")
+	}
+	if err := json.Unmarshal(fields[2], &e.Data); err != nil {
+		return fmt.Errorf("event data is not a string")
+	}
+	return nil
+}
+
+// resizeData matches the data of a resize event.
+var resizeData = regexp.MustCompile(`^[1-9][0-9]*x[1-9][0-9]*$`)
+
+// Validate reports whether the header describes a supported recording.
+func (h *Header) Validate() error {
+	if h.Version != Version {
+		return fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+	if h.Width <= 0 || h.Height <= 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", h.Width, h.Height)
+	}
+	return nil
+}
+
+// Validate reports whether the event is well formed.
+func (e *Event) Validate() error {
+	if e.Time < 0 || math.IsNaN(e.Time) || math.IsInf(e.Time, 0) {
+		return fmt.Errorf("invalid time %v", e.Time)
+	}
+	switch e.Type {
+	case Output, Input, Marker:
+	case Resize:
+		if !resizeData.MatchString(e.Data) {
+			return fmt.Errorf("invalid resize %q, want COLSxROWS", e.Data)
+		}
+	default:
+		return fmt.Errorf("unknown event type %q", e.Type)
+	}
+	return nil
+}
+
+// Validate reports whether the recording is well formed: the header and
+// every event are valid, and events are in time order.
+func (c *Cast) Validate() error {
+	if err := c.Header.Validate(); err != nil {
+		return err
+	}
+	for i := range c.Events {
+		e := &c.Events[i]
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("event %d: %v", i+1, err)
+		}
+		if i > 0 && e.Time < c.Events[i-1].Time {
+			return fmt.Errorf("event %d: time %v is before the previous event", i+1, e.Time)
+		}
+	}
+	return nil
+}
+
+// Read reads and validates a recording.
+func Read(r io.Reader) (*Cast, error) {
+	c := new(Cast)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20) // output events can be large
+	line, prev := 0, 0.0
+	header := false // the header has been read
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if !header {
+			// The header is the first line that is not blank
+			if err := json.Unmarshal(data, &c.Header); err != nil {
+				return nil, fmt.Errorf("line %d: invalid header: %v", line, err)
+			}
+			if err := c.Header.Validate(); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			header = true
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := e.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if e.Time < prev {
+			return nil, fmt.Errorf("line %d: time %v is before the previous event", line, e.Time)
+		}
+		prev = e.Time
+		c.Events = append(c.Events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, fmt.Errorf("missing header")
+	}
+	return c, nil
+}
+
+// ReadFile reads and validates the recording in the named file.
+func ReadFile(name string) (*Cast, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid asciicast %s: %v", name, err)
+	}
+	return c, nil
+}
+
+// A Writer writes a recording one event at a time, so that recordings
+// can be written while they are made.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the header h to w and returns a Writer for the events
+// that follow it.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	if err := h.Validate(); err != nil {
+		return nil, err
+	}
+	if err := writeLine(w, h); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// WriteEvent writes a single event.
+func (w *Writer) WriteEvent(e Event) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	return writeLine(w.w, e)
+}
+
+// Write writes the recording c to w.
+func Write(w io.Writer, c *Cast) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	cw, err := NewWriter(bw, c.Header)
+	if err != nil {
+		return err
+	}
+	for _, e := range c.Events {
+		if err := cw.WriteEvent(e); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteFile writes the recording c to the named file.
+func WriteFile(name string, c *Cast) error {
+	var buf bytes.Buffer
+	if err := Write(&buf, c); err != nil {
+		return err
+	}
+	return os.WriteFile(name, buf.Bytes(), 0644)
+}
+
+// Timeline returns the time at which each event is played back at the
+// given speed, with pauses between events limited to idleLimit seconds if
+// it is positive.
+func (c *Cast) Timeline(speed, idleLimit float64) []time.Duration {
+	times := make([]time.Duration, len(c.Events))
+	var at, prev float64
+	for i, e := range c.Events {
+		gap := e.Time - prev
+		if idleLimit > 0 && gap > idleLimit {
+			gap = idleLimit
+		}
+		at += gap
+		prev = e.Time
+		times[i] = time.Duration(at / speed * float64(time.Second))
+	}
+	return times
+}
+
+// writeLine writes v as a line of JSON.
+func writeLine(w io.Writer, v any) error {
+	data, err := marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// marshal encodes v without escaping <, > and &, which are common in
+// terminal output.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,96 @@
+package asciicast
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	c := &Cast{
+		Header: Header{
+			Version:       2,
+			Width:         100,
+			Height:        30,
+			Timestamp:     1700000000,
+			IdleTimeLimit: 2,
+			Title:         "demo",
+			Env:           map[string]string{"TERM": "xterm-256color"},
+		},
+		Events: []Event{
+			{0.1, Output, "$ echo <hi> & bye\r\n"},
+			{0.5, Input, "q"},
+			{1.25, Marker, "chapter 1"},
+			{1.25, Resize, "120x40"},
+			{2.000001, Output, "\x1b[31mred\x1b[0m"},
+		},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `[0.1,"o","$ echo <hi> & bye\r\n"]`) {
+		t.Errorf("output event not written compactly and unescaped:\n%s", buf.String())
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("round trip:\ngot  %+v\nwant %+v", got, c)
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	tests := []struct {
+		name, data, err string
+	}{
+		{"empty", "", "missing header"},
+		{"blank", "\n  \n\n", "missing header"},
+		{"blank header", "\n[1, \"o\", \"a\"]", "line 2: invalid header: json: cannot unmarshal array into Go value of type asciicast.Header"},
+		{"version", `{"version": 1, "width": 80, "height": 24}`, "line 1: unsupported asciicast version 1"},
+		{"size", `{"version": 2, "width": 0, "height": 24}`, "line 1: invalid terminal size 0x24"},
+		{"not array", "{\"version\": 2, \"width\": 80, \"height\": 24}\n{}", "line 2: event is not an array"},
+		{"fields", "{\"version\": 2, \"width\": 80, \"height\": 24}\n[1, \"o\"]", "line 2: event has 2 fields, want 3"},
+		{"type", "{\"version\": 2, \"width\": 80, \"height\": 24}\n[1, \"x\", \"\"]", `line 2: unknown event type "x"`},
+		{"resize", "{\"version\": 2, \"width\": 80, \"height\": 24}\n[1, \"r\", \"80\"]", `line 2: invalid resize "80", want COLSxROWS`},
+		{"order", "{\"version\": 2, \"width\": 80, \"height\": 24}\n[2, \"o\", \"a\"]\n[1, \"o\", \"b\"]", "line 3: time 1 is before the previous event"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(strings.NewReader(tt.data))
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Read error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadBlankLines(t *testing.T) {
+	c, err := Read(strings.NewReader("\n{\"version\": 2, \"width\": 80, \"height\": 24}\n\n[1, \"o\", \"a\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Event{{1, Output, "a"}}; c.Header.Width != 80 || !reflect.DeepEqual(c.Events, want) {
+		t.Errorf("Read() = %+v, want a header and the event %v", c, want)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	c := &Cast{Events: []Event{{1, Output, "a"}, {11, Output, "b"}, {12, Marker, ""}}}
+	tests := []struct {
+		speed, idle float64
+		want        []time.Duration
+	}{
+		{1, 0, []time.Duration{1 * time.Second, 11 * time.Second, 12 * time.Second}},
+		{2, 0, []time.Duration{500 * time.Millisecond, 5500 * time.Millisecond, 6 * time.Second}},
+		{1, 2, []time.Duration{1 * time.Second, 3 * time.Second, 4 * time.Second}},
+	}
+	for _, tt := range tests {
+		if got := c.Timeline(tt.speed, tt.idle); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Timeline(%v, %v) = %v, want %v", tt.speed, tt.idle, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package asciicast
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxColumn bounds the column cursor movement can reach in PlainText, so
+// that a sequence such as ESC [99999999C in an untrusted recording cannot
+// make it pad a line without limit. It is wider than any real terminal.
+const maxColumn = 1000
+
+// PlainText returns the text a terminal would display for the output
+// data, without colors or other escape sequences. Carriage returns,
+// backspaces and erasing within a line are applied, other cursor movement
+// is ignored, and trailing spaces are removed from each line.
+func PlainText(data string) string {
+	var b strings.Builder
+	var line []rune
+	col := 0
+	flush := func() {
+		b.WriteString(strings.TrimRight(string(line), " "))
+		line, col = line[:0], 0
+	}
+
+	rs := []rune(data)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			flush()
+			b.WriteByte('\n')
+		case r == '\r':
+			col = 0
+		case r == '\b':
+			if col > 0 {
+				col--
+			}
+		case r == '\t':
+			for next := (col/8 + 1) * 8; col < next; col++ {
+				if col >= len(line) {
+					line = append(line, ' ')
+				}
+			}
+		case r == '\033':
+			var final rune
+			var params string
+			i, final, params = skipEscape(rs, i)
+			n, err := strconv.Atoi(params)
+			if err != nil || n < 1 {
+				n = 1
+			}
+			switch final {
+			case 'K': // erase in line
+				switch params {
+				case "", "0":
+					if col < len(line) {
+						line = line[:col]
+					}
+				case "1":
+					for j := 0; j <= col && j < len(line); j++ {
+						line[j] = ' '
+					}
+				case "2":
+					line = line[:0]
+				}
+			case 'C': // cursor forward
+				col = min(col+n, maxColumn)
+			case 'D': // cursor back
+				col = max(col-n, 0)
+			case 'G': // cursor to column
+				col = min(n, maxColumn) - 1
+			}
+		case r < ' ' || r == 0x7f:
+			// Other control characters, such as the bell, display nothing
+		default:
+			for len(line) < col {
+				line = append(line, ' ')
+			}
+			if col < len(line) {
+				line[col] = r
+			} else {
+				line = append(line, r)
+			}
+			col++
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// skipEscape skips the escape sequence starting at rs[i], which is ESC.
+// It returns the index of the last rune of the sequence and, for control
+// sequences (CSI), their final byte and parameters.
+func skipEscape(rs []rune, i int) (end int, final rune, params string) {
+	if i+1 >= len(rs) {
+		return i, 0, ""
+	}
+	switch rs[i+1] {
+	case '[':
+		// CSI: parameter bytes, intermediate bytes, then a final byte
+		j := i + 2
+		for j < len(rs) && rs[j] >= 0x30 && rs[j] <= 0x3f {
+			j++
+		}
+		p := string(rs[i+2 : j])
+		for j < len(rs) && rs[j] >= 0x20 && rs[j] <= 0x2f {
+			j++
+		}
+		if j >= len(rs) {
+			return len(rs) - 1, 0, ""
+		}
+		return j, rs[j], p
+	case ']', 'P', 'X', '^', '_':
+		// OSC and other strings, ended by BEL or ST (ESC \)
+		for j := i + 2; j < len(rs); j++ {
+			if rs[j] == '\a' {
+				return j, 0, ""
+			}
+			if rs[j] == '\033' && j+1 < len(rs) && rs[j+1] == '\\' {
+				return j + 1, 0, ""
+			}
+		}
+		return len(rs) - 1, 0, ""
+	}
+	// Other sequences: intermediate bytes, then a final byte
+	j := i + 1
+	for j < len(rs) && rs[j] >= 0x20 && rs[j] <= 0x2f {
+		j++
+	}
+	return min(j, len(rs)-1), 0, ""
+}
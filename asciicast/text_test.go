@@ -0,0 +1,37 @@
+package asciicast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"plain", "hello\r\nworld\r\n", "hello\nworld\n"},
+		{"colors", "\x1b[1;31merror\x1b[0m: bad\r\n", "error: bad\n"},
+		{"title", "\x1b]0;user@host: ~\x07$ ls\r\n", "$ ls\n"},
+		{"title st", "\x1b]2;title\x1b\\$ ls", "$ ls"},
+		{"charset", "\x1b(Bx", "x"},
+		{"carriage return", "50%\r100%\r\n", "100%\n"},
+		{"backspace", "lx\b \bs\r\n", "ls\n"},
+		{"erase line", "progress 50%\r\x1b[Kdone\r\n", "done\n"},
+		{"erase whole line", "abc\x1b[2K\rx", "x"},
+		{"cursor", "ab\x1b[2Dx\x1b[Cy", "xby"},
+		{"column", "abc\x1b[1Gz", "zbc"},
+		{"tab", "a\tb", "a       b"},
+		{"bell", "ding\a\n", "ding\n"},
+		{"trailing spaces", "$ \r\n", "$\n"},
+		{"unterminated", "a\x1b[", "a"},
+		{"far forward", "a\x1b[99999999Cb", "a" + strings.Repeat(" ", maxColumn-1) + "b"},
+		{"far column", "a\x1b[999999999Gb", "a" + strings.Repeat(" ", maxColumn-2) + "b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PlainText(tt.data); got != tt.want {
+				t.Errorf("PlainText(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
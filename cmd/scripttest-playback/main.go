@@ -1,19 +1,25 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+
+	"github.com/tmc/scripttestutil/playback"
 )
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s <snapshot-file>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nPlays back a scripttest snapshot file\n")
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] <file>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nPlays back a scripttest snapshot, asciicast recording, or typescript\n")
+		fmt.Fprintf(os.Stderr, "written by script(1), like scripttest playback\n\n")
 		flag.PrintDefaults()
 	}
+	timed := flag.Bool("timed", false, "replay output with its recorded timing")
+	speed := flag.Float64("speed", 1, "playback speed multiplier, with -timed")
+	idleLimit := flag.Float64("idle-time-limit", 0, "limit pauses to `seconds`, with -timed")
+	color := flag.Bool("color", false, "color stderr output red")
+	timingFile := flag.String("t", "", "timing `file` of a typescript (default: <typescript>.timing)")
 	flag.Parse()
 
 	if flag.NArg() != 1 {
@@ -21,27 +27,19 @@ func main() {
 		os.Exit(1)
 	}
 
-	snapshotPath := flag.Arg(0)
-
-	// Read snapshot file
-	data, err := ioutil.ReadFile(snapshotPath)
+	rec, err := playback.Load(flag.Arg(0), *timingFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading snapshot: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading recording: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Parse snapshot content
-	var content map[string]string
-	if err := json.Unmarshal(data, &content); err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid snapshot format: %v\n", err)
+	err = playback.Play(rec, os.Stdout, os.Stderr, playback.Options{
+		Timed:         *timed,
+		Speed:         *speed,
+		IdleTimeLimit: *idleLimit,
+		ColorStderr:   *color,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Output the snapshot content
-	if stdout, ok := content["stdout"]; ok {
-		fmt.Print(stdout)
-	}
-	if stderr, ok := content["stderr"]; ok {
-		fmt.Fprintf(os.Stderr, "%s", stderr)
-	}
 }
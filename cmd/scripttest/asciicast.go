@@ -1,187 +1,244 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strings"
 	"time"
-)
-
-// AsciicastHeader contains metadata for an asciicast recording
-type AsciicastHeader struct {
-	Version   int               `json:"version"`
-	Width     int               `json:"width"`
-	Height    int               `json:"height"`
-	Timestamp int64             `json:"timestamp"`
-	Title     string            `json:"title,omitempty"`
-	Env       map[string]string `json:"env,omitempty"`
-}
 
-// AsciicastFrame represents a single frame in an asciicast recording
-type AsciicastFrame struct {
-	Time    float64 `json:"time"`
-	Type    string  `json:"type"`
-	Content string  `json:"data"`
-}
+	"github.com/tmc/scripttestutil/asciicast"
+	"github.com/tmc/scripttestutil/snapshot"
+)
 
-// RecordAsciicast records a test execution as an asciicast file
-func recordAsciicast(testFile, outputFile string) error {
-	// Check if asciinema is installed
-	_, err := exec.LookPath("asciinema")
+// runPlayCast implements the play-cast command.
+func runPlayCast(args []string) error {
+	fs := flag.NewFlagSet("play-cast", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "playback speed multiplier")
+	idleLimit := fs.Float64("idle-time-limit", 0, "limit pauses to this many seconds (default from the recording)")
+	pauseOnMarkers := fs.Bool("pause-on-markers", false, "pause at each marker event")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("play-cast requires asciicast file argument")
+	}
+	if *speed <= 0 {
+		return fmt.Errorf("invalid speed %v", *speed)
+	}
+	cast, err := asciicast.ReadFile(fs.Arg(0))
 	if err != nil {
-		return fmt.Errorf("asciinema not found. Please install with 'brew install asciinema' or 'pip install asciinema'")
+		return err
 	}
-
-	// Create the output directory if it doesn't exist
-	outputDir := filepath.Dir(outputFile)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+	if *idleLimit == 0 {
+		*idleLimit = cast.Header.IdleTimeLimit
 	}
+	p := &player{out: os.Stdout, cast: cast, speed: *speed, idleLimit: *idleLimit, pauseOnMarkers: *pauseOnMarkers}
 
-	// First run the test to make sure it passes
-	if verbose {
-		fmt.Printf("Running test file %s before recording\n", testFile)
+	// Keys control playback when stdin is a terminal
+	if restore, err := makeRaw(os.Stdin); err == nil {
+		defer restore()
+		p.keys = readKeys(os.Stdin)
+		if verbose {
+			fmt.Fprint(os.Stderr, "space: pause/resume  .: step  ]: next marker  q: quit\r\n")
+		}
 	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	p.interrupt = interrupt
+	return p.play()
+}
 
-	// Run the test with scripttest
-	testCmd := exec.Command("go", "run", ".", "test", testFile)
-	testCmd.Stdout = os.Stdout
-	testCmd.Stderr = os.Stderr
-	if err := testCmd.Run(); err != nil {
-		return fmt.Errorf("test failed before recording: %v", err)
-	}
+// A player plays a recording to a terminal.
+type player struct {
+	out            io.Writer
+	cast           *asciicast.Cast
+	speed          float64
+	idleLimit      float64 // seconds, or 0 for no limit
+	pauseOnMarkers bool
 
-	// Record the test execution
-	if verbose {
-		fmt.Printf("Recording test execution to %s\n", outputFile)
-	}
-	
-	// Start asciinema recording
-	recordCmd := exec.Command("asciinema", "rec", "--overwrite", "--command", 
-		fmt.Sprintf("go run . -v test %s", testFile), outputFile)
-	recordCmd.Stdin = os.Stdin
-	recordCmd.Stdout = os.Stdout
-	recordCmd.Stderr = os.Stderr
-	
-	if err := recordCmd.Run(); err != nil {
-		return fmt.Errorf("recording failed: %v", err)
-	}
+	keys      <-chan byte      // key presses, or nil if not interactive
+	interrupt <-chan os.Signal // stops playback
+}
 
-	if verbose {
-		fmt.Printf("Recording saved to %s\n", outputFile)
+// play plays the recording. While playing, space pauses and resumes,
+// "." shows the next event while paused, "]" skips to the next marker and
+// "q" quits.
+func (p *player) play() error {
+	events := p.cast.Events
+	times := p.cast.Timeline(p.speed, p.idleLimit)
+
+	// While playing, base is the wall time of the start of the recording;
+	// while paused, pos is the position in the recording.
+	base := time.Now()
+	var pos time.Duration
+	paused := false
+	for i := 0; i < len(events); {
+		var key byte
+		if paused {
+			select {
+			case k, ok := <-p.keys:
+				if !ok {
+					// No more input: resume so playback ends
+					p.keys, paused, base = nil, false, time.Now().Add(-pos)
+					continue
+				}
+				key = k
+			case <-p.interrupt:
+				return nil
+			}
+		} else {
+			timer := time.NewTimer(time.Until(base.Add(times[i])))
+			select {
+			case <-timer.C:
+				if err := p.show(events[i]); err != nil {
+					return err
+				}
+				if events[i].Type == asciicast.Marker && p.pauseOnMarkers && p.keys != nil {
+					paused, pos = true, times[i]
+				}
+				i++
+				continue
+			case k, ok := <-p.keys:
+				timer.Stop()
+				if !ok {
+					p.keys = nil
+					continue
+				}
+				key = k
+			case <-p.interrupt:
+				timer.Stop()
+				return nil
+			}
+		}
+
+		switch key {
+		case ' ':
+			if paused {
+				paused, base = false, time.Now().Add(-pos)
+			} else {
+				paused, pos = true, time.Since(base)
+			}
+		case '.':
+			if paused {
+				if err := p.show(events[i]); err != nil {
+					return err
+				}
+				pos = times[i]
+				i++
+			}
+		case ']':
+			// Show everything up to and including the next marker
+			for i < len(events) {
+				e := events[i]
+				if err := p.show(e); err != nil {
+					return err
+				}
+				i++
+				if e.Type == asciicast.Marker {
+					break
+				}
+			}
+			pos = times[i-1]
+			base = time.Now().Add(-pos)
+			if events[i-1].Type == asciicast.Marker && p.pauseOnMarkers {
+				paused = true
+			}
+		case 'q', 3: // 3 is ^C, in case the terminal does not send a signal
+			return nil
+		}
 	}
 	return nil
 }
 
-// PlayAsciicast plays an asciicast recording
-func playAsciicast(asciicastFile string) error {
-	// Check if asciinema is installed
-	_, err := exec.LookPath("asciinema")
-	if err != nil {
-		return fmt.Errorf("asciinema not found. Please install with 'brew install asciinema' or 'pip install asciinema'")
+// show displays an event. Only output is displayed.
+func (p *player) show(e asciicast.Event) error {
+	if e.Type != asciicast.Output {
+		return nil
 	}
+	_, err := io.WriteString(p.out, e.Data)
+	return err
+}
 
-	// Play the recording
-	playCmd := exec.Command("asciinema", "play", asciicastFile)
-	playCmd.Stdout = os.Stdout
-	playCmd.Stderr = os.Stderr
-	
-	return playCmd.Run()
+// readKeys returns a channel of the bytes read from f, closed at the end
+// of the input.
+func readKeys(f *os.File) <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		defer close(keys)
+		buf := make([]byte, 1)
+		for {
+			if n, err := f.Read(buf); n == 0 || err != nil {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+	return keys
 }
 
 // ConvertSnapshotToAsciicast converts a scripttest snapshot to an asciicast format
 func convertSnapshotToAsciicast(snapshotFile, outputFile string) error {
-	// Read the snapshot file
-	data, err := os.ReadFile(snapshotFile)
+	// Read the snapshot file, in either format
+	snap, err := snapshot.ReadFile(snapshotFile)
 	if err != nil {
 		return fmt.Errorf("failed to read snapshot file: %v", err)
 	}
 
-	// Parse the JSON content
-	var snapshot map[string]string
-	if err := json.Unmarshal(data, &snapshot); err != nil {
-		return fmt.Errorf("invalid snapshot format: %v", err)
-	}
-
-	// Create the asciicast header
-	header := AsciicastHeader{
-		Version:   2,
-		Width:     80,
-		Height:    25,
-		Timestamp: time.Now().Unix(),
-		Title:     filepath.Base(snapshotFile),
-		Env:       map[string]string{"SHELL": "/bin/bash"},
-	}
-
-	// Open the output file
-	out, err := os.Create(outputFile)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %v", err)
+	cast := snapshotCast(snap, filepath.Base(snapshotFile))
+	if err := asciicast.WriteFile(outputFile, cast); err != nil {
+		return fmt.Errorf("failed to write asciicast: %v", err)
 	}
-	defer out.Close()
 
-	// Write the header
-	headerBytes, err := json.Marshal(header)
-	if err != nil {
-		return fmt.Errorf("failed to marshal header: %v", err)
-	}
-	if _, err := out.Write(headerBytes); err != nil {
-		return fmt.Errorf("failed to write header: %v", err)
-	}
-	if _, err := out.Write([]byte("\n")); err != nil {
-		return fmt.Errorf("failed to write newline: %v", err)
-	}
-
-	// Create frames for stdout and stderr
-	writer := bufio.NewWriter(out)
-	
-	// Process stdout
-	if stdout, ok := snapshot["stdout"]; ok && stdout != "" {
-		frame := AsciicastFrame{
-			Time:    0.1,
-			Type:    "o",
-			Content: stdout,
-		}
-		frameBytes, err := json.Marshal(frame)
-		if err != nil {
-			return fmt.Errorf("failed to marshal stdout frame: %v", err)
-		}
-		if _, err := writer.Write(frameBytes); err != nil {
-			return fmt.Errorf("failed to write stdout frame: %v", err)
-		}
-		if _, err := writer.Write([]byte("\n")); err != nil {
-			return fmt.Errorf("failed to write newline: %v", err)
-		}
+	if verbose {
+		fmt.Printf("Converted snapshot to asciicast: %s\n", outputFile)
 	}
+	return nil
+}
 
-	// Process stderr
-	if stderr, ok := snapshot["stderr"]; ok && stderr != "" {
-		frame := AsciicastFrame{
-			Time:    0.2,
-			Type:    "o",
-			Content: "\033[31m" + stderr + "\033[0m", // Red color for stderr
-		}
-		frameBytes, err := json.Marshal(frame)
-		if err != nil {
-			return fmt.Errorf("failed to marshal stderr frame: %v", err)
+// snapshotCast returns a recording of the output of a snapshot. The output
+// is replayed as it was written if the snapshot has timing, and shown all
+// at once otherwise.
+func snapshotCast(snap *snapshot.Snapshot, title string) *asciicast.Cast {
+	cast := &asciicast.Cast{
+		Header: asciicast.Header{
+			Version:   asciicast.Version,
+			Width:     80,
+			Height:    25,
+			Timestamp: time.Now().Unix(),
+			Title:     title,
+			Env:       map[string]string{"SHELL": "/bin/bash"},
+		},
+	}
+	if snap.Terminal != nil {
+		cast.Header.Width, cast.Header.Height = snap.Terminal.Width, snap.Terminal.Height
+	}
+	if len(snap.Chunks) > 0 {
+		// Reproduce the output as it was recorded
+		for _, chunk := range snap.Chunks {
+			cast.Events = append(cast.Events, outputEvent(chunk.Time, chunk.Data, chunk.Stream == "stderr"))
 		}
-		if _, err := writer.Write(frameBytes); err != nil {
-			return fmt.Errorf("failed to write stderr frame: %v", err)
+	} else {
+		if stdout := snap.Stdout; stdout != "" {
+			cast.Events = append(cast.Events, outputEvent(0.1, stdout, false))
 		}
-		if _, err := writer.Write([]byte("\n")); err != nil {
-			return fmt.Errorf("failed to write newline: %v", err)
+		if stderr := snap.Stderr; stderr != "" {
+			cast.Events = append(cast.Events, outputEvent(0.2, stderr, true))
 		}
 	}
+	return cast
+}
 
-	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush writer: %v", err)
-	}
-
-	if verbose {
-		fmt.Printf("Converted snapshot to asciicast: %s\n", outputFile)
+// outputEvent returns an output event showing data as a terminal would,
+// with newlines as CRLF. Stderr is colored red.
+func outputEvent(t float64, data string, stderr bool) asciicast.Event {
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\n", "\r\n")
+	if stderr {
+		data = "\033[31m" + data + "\033[0m"
 	}
-	return nil
-}
\ No newline at end of file
+	return asciicast.Event{Time: t, Type: asciicast.Output, Data: data}
+}
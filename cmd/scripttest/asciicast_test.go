@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/scripttestutil/asciicast"
+)
+
+// cast returns a recording of the given events.
+func cast(events ...asciicast.Event) *asciicast.Cast {
+	return &asciicast.Cast{Header: asciicast.Header{Version: 2, Width: 80, Height: 24}, Events: events}
+}
+
+func TestPlayer(t *testing.T) {
+	var out strings.Builder
+	p := &player{
+		out: &out,
+		cast: cast(
+			asciicast.Event{Time: 0, Type: asciicast.Output, Data: "a"},
+			asciicast.Event{Time: 1, Type: asciicast.Input, Data: "typed"},
+			asciicast.Event{Time: 1, Type: asciicast.Marker, Data: "step"},
+			asciicast.Event{Time: 1, Type: asciicast.Resize, Data: "100x30"},
+			asciicast.Event{Time: 10, Type: asciicast.Output, Data: "b"},
+		),
+		speed:     10,
+		idleLimit: 2,
+	}
+	start := time.Now()
+	if err := p.play(); err != nil {
+		t.Fatal(err)
+	}
+	// The 9s pause is limited to 2s, and everything is 10 times as fast
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Errorf("played for %v, want about 300ms", elapsed)
+	}
+	if got := out.String(); got != "ab" {
+		t.Errorf("played %q, want only the output events", got)
+	}
+}
+
+func TestPlayerKeys(t *testing.T) {
+	c := cast(
+		asciicast.Event{Time: 0, Type: asciicast.Output, Data: "a"},
+		asciicast.Event{Time: 50, Type: asciicast.Marker, Data: "one"},
+		asciicast.Event{Time: 100, Type: asciicast.Output, Data: "b"},
+		asciicast.Event{Time: 150, Type: asciicast.Marker, Data: "two"},
+		asciicast.Event{Time: 200, Type: asciicast.Output, Data: "c"},
+	)
+	tests := []struct {
+		name string
+		keys string
+		want string
+	}{
+		// "]" skips to the next marker, pausing there, and "." steps
+		{"quit", "].]q", "ab"},
+		// Playback resumes at the end of the input
+		{"end of input", "].]", "abc"},
+		{"resume", "]. ", "abc"},
+		{"ctrl-c", "]\x03", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := make(chan byte)
+			go func() {
+				defer close(keys)
+				for i := 0; i < len(tt.keys); i++ {
+					select {
+					case keys <- tt.keys[i]:
+					case <-time.After(5 * time.Second):
+						return
+					}
+				}
+			}()
+			var out strings.Builder
+			// At 1000 times the speed, the markers are 50ms apart
+			p := &player{out: &out, cast: c, speed: 1000, pauseOnMarkers: true, keys: keys}
+			if err := p.play(); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("played %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlayerInterrupt(t *testing.T) {
+	interrupt := make(chan os.Signal, 1)
+	interrupt <- os.Interrupt
+	var out strings.Builder
+	p := &player{
+		out:       &out,
+		cast:      cast(asciicast.Event{Time: 100, Type: asciicast.Output, Data: "late"}),
+		speed:     1,
+		interrupt: interrupt,
+	}
+	if err := p.play(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "" {
+		t.Errorf("played %q after an interrupt", out.String())
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tmc/scripttestutil/asciicast"
+)
+
+// defaultPrompt matches common shell prompts, such as "$ ", "# ",
+// "user@host:~/src% " and "❯ ".
+const defaultPrompt = `^[^$#%>❯]*[$#%>❯]( |$)`
+
+// runCastToScript implements the cast-to-script command.
+func runCastToScript(args []string) error {
+	fs := flag.NewFlagSet("cast-to-script", flag.ContinueOnError)
+	prompt := fs.String("prompt", defaultPrompt, "regular expression matching the shell prompt")
+	output := fs.String("o", "", "write the script to `file` instead of stdout")
+	maxLines := fs.Int("max-lines", 10, "maximum number of output lines to check per command")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("cast-to-script requires asciicast file argument")
+	}
+	re, err := regexp.Compile(*prompt)
+	if err != nil {
+		return fmt.Errorf("invalid prompt: %v", err)
+	}
+	cast, err := asciicast.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	steps := sessionSteps(cast, re)
+	if len(steps) == 0 {
+		return fmt.Errorf("no commands found in %s; use -prompt to match its shell prompt", fs.Arg(0))
+	}
+	script := formatScript(fs.Arg(0), steps, *maxLines)
+	if *output == "" {
+		_, err := os.Stdout.WriteString(script)
+		return err
+	}
+	if err := os.WriteFile(*output, []byte(script), 0644); err != nil {
+		return fmt.Errorf("failed to write script: %v", err)
+	}
+	if verbose {
+		fmt.Printf("Wrote %d commands to %s\n", len(steps), *output)
+	}
+	return nil
+}
+
+// A step is a command typed in a recorded session and the lines it printed.
+type step struct {
+	command string
+	output  []string
+}
+
+// sessionSteps finds the commands in a recorded shell session.
+//
+// If the recording has input events, each Enter ends a command, which is
+// read from the prompt line it was typed on, or taken from the input if
+// the line does not match the prompt. Otherwise every line that matches
+// the prompt starts a command.
+func sessionSteps(cast *asciicast.Cast, prompt *regexp.Regexp) []step {
+	var out strings.Builder
+	var typed []string
+	var line []rune
+	var enters []int // output lines Enter was typed on
+	hasInput, inEscape := false, false
+	entered := 0  // Enters not yet echoed
+	newlines := 0 // newlines in the output so far
+	for _, e := range cast.Events {
+		switch e.Type {
+		case asciicast.Output:
+			// The shell echoes typed lines after they are entered, so an
+			// Enter belongs to the line ended by the next newline. Shells
+			// such as zsh redraw the line before ending it, so the lines
+			// are counted rather than marked in the output.
+			for _, r := range e.Data {
+				if r == '\n' {
+					if entered > 0 {
+						enters = append(enters, newlines)
+						entered--
+					}
+					newlines++
+				}
+			}
+			out.WriteString(e.Data)
+		case asciicast.Input:
+			hasInput = true
+			for _, r := range e.Data {
+				switch {
+				case inEscape:
+					// Keys such as arrows send ESC [ A; skip to the final letter
+					inEscape = r == '[' || r == 'O' || r >= '0' && r <= '9' || r == ';'
+				case r == '\033':
+					inEscape = true
+				case r == '\r' || r == '\n':
+					entered++
+					typed = append(typed, string(line))
+					line = line[:0]
+				case r == '\b' || r == 0x7f:
+					if len(line) > 0 {
+						line = line[:len(line)-1]
+					}
+				case r == 3: // ^C
+					line = line[:0]
+				case r >= ' ':
+					line = append(line, r)
+				}
+			}
+		}
+	}
+	for ; entered > 0; entered-- {
+		enters = append(enters, newlines)
+	}
+	// PlainText keeps the newlines, so the lines are those counted
+	lines := strings.Split(asciicast.PlainText(out.String()), "\n")
+
+	var steps []step
+	if hasInput {
+		// Command k was typed on lines[enters[k]] and its output runs up
+		// to the line of the next command, which starts with the prompt
+		for k, n := range enters {
+			command := typed[k]
+			if m := prompt.FindStringIndex(lines[n]); m != nil {
+				command = lines[n][m[1]:]
+			}
+			var output []string
+			if k+1 < len(enters) {
+				output = lines[min(n+1, enters[k+1]):enters[k+1]]
+			} else {
+				output = lines[n+1:]
+				if len(output) > 0 && prompt.MatchString(output[len(output)-1]) {
+					output = output[:len(output)-1]
+				}
+			}
+			steps = append(steps, step{command: command, output: output})
+		}
+	} else {
+		for _, line := range lines {
+			if m := prompt.FindStringIndex(line); m != nil {
+				steps = append(steps, step{command: line[m[1]:]})
+			} else if len(steps) > 0 {
+				steps[len(steps)-1].output = append(steps[len(steps)-1].output, line)
+			}
+		}
+	}
+
+	// Drop empty commands and the end of the session
+	var kept []step
+	for _, st := range steps {
+		st.command = strings.TrimSpace(st.command)
+		switch st.command {
+		case "", "exit", "logout":
+			continue
+		}
+		var output []string
+		for _, line := range st.output {
+			if strings.TrimSpace(line) != "" {
+				output = append(output, line)
+			}
+		}
+		st.output = output
+		kept = append(kept, st)
+	}
+	return kept
+}
+
+// formatScript formats steps as a draft script. Each command is followed
+// by stdout assertions for up to maxLines lines of its output.
+func formatScript(name string, steps []step, maxLines int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by scripttest cast-to-script from %s.\n", filepath.Base(name))
+	b.WriteString("# Review before use: recordings do not separate stdout from stderr\n")
+	b.WriteString("# or show exit codes, and output may contain volatile values.\n")
+	for _, st := range steps {
+		b.WriteString("\n" + scriptCommand(st.command) + "\n")
+		if len(st.output) == 0 {
+			b.WriteString("! stdout .\n")
+			continue
+		}
+		for i, line := range st.output {
+			if i == maxLines {
+				fmt.Fprintf(&b, "# %d more lines not checked\n", len(st.output)-i)
+				break
+			}
+			fmt.Fprintf(&b, "stdout %s\n", quoteArg("^"+regexp.QuoteMeta(line)+"$"))
+		}
+	}
+	return b.String()
+}
+
+// shellSyntax lists the characters that make a command line need a shell.
+const shellSyntax = "|&;<>()$`\\\"'*?[]{}~#"
+
+// scriptCommand converts a shell command line to a script command. Lines
+// using shell syntax are run with sh -c.
+func scriptCommand(command string) string {
+	fields := strings.Fields(command)
+	switch {
+	case strings.ContainsAny(command, shellSyntax) || strings.Contains(fields[0], "="):
+		return "exec sh -c " + quoteArg(command)
+	case fields[0] == "cd" && len(fields) == 2:
+		return command
+	case fields[0] == "export" && len(fields) > 1:
+		return "env " + strings.Join(fields[1:], " ")
+	}
+	return "exec " + command
+}
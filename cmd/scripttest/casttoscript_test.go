@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/tmc/scripttestutil/asciicast"
+)
+
+// events returns the events of a cast made of alternating output and
+// input, starting with output.
+func events(data ...string) *asciicast.Cast {
+	cast := &asciicast.Cast{Header: asciicast.Header{Version: 2, Width: 80, Height: 24}}
+	for i, d := range data {
+		typ := asciicast.Output
+		if i%2 == 1 {
+			typ = asciicast.Input
+		}
+		cast.Events = append(cast.Events, asciicast.Event{Time: float64(i) / 10, Type: typ, Data: d})
+	}
+	return cast
+}
+
+func TestSessionSteps(t *testing.T) {
+	prompt := regexp.MustCompile(defaultPrompt)
+	tests := []struct {
+		name string
+		cast *asciicast.Cast
+		want []step
+	}{
+		{
+			name: "input",
+			cast: events(
+				"\x1b]0;user@host\x07$ ", "ls\r",
+				"ls\r\nfile1  file2\r\n$ ", "echo hi | tr a-z A-Z\r",
+				"echo hi | tr a-z A-Z\r\nHI\r\n$ ", "exit\r",
+				"exit\r\n",
+			),
+			want: []step{
+				{command: "ls", output: []string{"file1  file2"}},
+				{command: "echo hi | tr a-z A-Z", output: []string{"HI"}},
+			},
+		},
+		{
+			// Commands are taken from the input when the prompt does not
+			// match, with editing keys applied
+			name: "unmatched prompt",
+			cast: events(
+				"READY ", "\x1b[Alx\x7fs\r",
+				"ls\r\nfile1\r\nREADY ", "\r",
+				"\r\nREADY ", "exit\r",
+				"exit\r\n",
+			),
+			want: []step{
+				{command: "ls", output: []string{"file1"}},
+			},
+		},
+		{
+			// zsh redraws the line after Enter, returning to its start
+			// and erasing it before writing the newline
+			name: "redrawn line",
+			cast: events(
+				"% ", "ls\r",
+				"\r\x1b[K% ls\x1b[K\r\r\nfile1  file2\r\n\x1b[7m%\x1b[27m \r \r\x1b[K% ", "echo hi\r",
+				"\r\x1b[K% echo hi\x1b[K\r\r\nhi\r\n% ", "exit\r",
+				"\r\x1b[K% exit\x1b[K\r\r\n",
+			),
+			want: []step{
+				{command: "ls", output: []string{"file1  file2"}},
+				{command: "echo hi", output: []string{"hi"}},
+			},
+		},
+		{
+			name: "output only",
+			cast: events("$ echo hi\r\nhi\r\n\r\n$ ls -l\r\ntotal 0\r\n$ true\r\n$ exit\r\n"),
+			want: []step{
+				{command: "echo hi", output: []string{"hi"}},
+				{command: "ls -l", output: []string{"total 0"}},
+				{command: "true"},
+			},
+		},
+		{
+			name: "no prompt",
+			cast: events("just output\r\n"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sessionSteps(tt.cast, prompt)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sessionSteps() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	for command, want := range map[string]string{
+		"ls -l":                "exec ls -l",
+		"echo hi | tr a-z A-Z": "exec sh -c 'echo hi | tr a-z A-Z'",
+		"echo it's":            "exec sh -c 'echo it''s'",
+		"echo $HOME":           "exec sh -c 'echo $HOME'",
+		"FOO=bar make":         "exec sh -c 'FOO=bar make'",
+		"cd src":               "cd src",
+		"export FOO=bar":       "env FOO=bar",
+	} {
+		if got := scriptCommand(command); got != want {
+			t.Errorf("scriptCommand(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
@@ -12,31 +12,92 @@ Commands:
 	test, run    run scripttest files (default pattern: testdata/*.txt)
 	             scripttest test                # uses -p or default pattern
 	             scripttest test 'custom/*.txt' # overrides pattern
+	             scripttest test -go=1.22,1.23,tip # runs with each Go version
 
 	             Docker Support:
-	             - Use -docker flag to run tests in container
+	             - Use -docker flag to run tests in container; the harness is
+	               set up as for local runs and only the matched scripts run
 	             - Specify custom image with -docker-image
+	             - Add "-- Dockerfile.<os>.<arch> --" variants and select the
+	               target platform with -docker-platform (e.g. linux/arm64)
+	             - Select podman or nerdctl with -runtime (default: the first
+	               of docker, podman and nerdctl installed); images are built
+	               with docker buildx bake when available, and plain build
+	               otherwise
 	             - Include Dockerfile in test file with "-- Dockerfile --" marker
+	             - Each script runs in an image built from its own Dockerfile,
+	               tagged scripttest-runner:<content hash>; scripts with the
+	               same Dockerfile share an image and a container
 
 	             Snapshot Support:
-	             - Use 'snapshot <n>' command in test file to verify output
+	             - Use 'snapshot [name]' command in test file to verify output
+	               (stored as testdata/__snapshots__/<name>.json)
 	             - Run with UPDATE_SNAPSHOTS=1 to update snapshots
+	             - Use 'snapshot-filter <regexp> <replacement>' to redact
+	               volatile output such as timestamps or PIDs
+	             - Use 'snapshot-tree <dir> [name]' to verify generated files
 
 	scaffold     create scripttest scaffold in [dir]
 	             scripttest scaffold .
 
-	playback     play back a recorded snapshot
-	             scripttest playback testdata/__snapshots__/test.linux
+	snapshots    check, prune or review snapshot files (default pattern: testdata/*.txt)
+	             scripttest snapshots check     # report orphaned and missing snapshots
+	             scripttest snapshots prune     # delete orphaned snapshots
+	             scripttest snapshots review    # accept or reject pending snapshot changes
+
+	toolchain    manage the Go toolchains installed by -auto-go and test -go
+	             scripttest toolchain list           # installed toolchains and disk usage
+	             scripttest toolchain install 1.23   # latest 1.23.x; default: auto
+	             scripttest toolchain remove go1.22.3
+	             scripttest toolchain which          # go command for this module
+	             - Versions are given as in GOTOOLCHAIN: 1.22, go1.22.3,
+	               go1.22.3+auto, tip, local or auto (the version go.mod
+	               needs); remove and which match a language version such
+	               as 1.22 against all of its installed releases
+	             - install tip updates and rebuilds an installed tip
+
+	doctor       check that go, docker, expect and asciinema are available,
+	             and say how to fix what is missing
+
+	playback     play back a snapshot, asciicast or script(1) typescript
+	             scripttest playback testdata/__snapshots__/test.json
+	             scripttest playback -timed -t session.timing session.log
+	             - Use -timed to replay output with its recorded pauses,
+	               and -speed and -idle-time-limit to adjust them
+	             - Use -color to show stderr in red
+	             - A typescript's timing is read from <typescript>.timing
+	               unless -t names the file; classic and advanced formats work
 
 	record       record a test execution as an asciicast
 	             scripttest record testdata/example.txt recordings/example.cast
+	             - Runs the script in-process; asciinema is not required
+	             - Use -pty to run exec commands with a terminal as stdout
+	             - Use -cols and -rows to set the terminal size (default 80x24)
 
 	play-cast    play an asciicast recording
 	             scripttest play-cast recordings/example.cast
+	             - Use -speed to speed up or slow down playback
+	             - Use -idle-time-limit to cap pauses, in seconds
+	             - Use -pause-on-markers to stop at each marker event
+	             - Keys: space pauses and resumes, . steps while paused,
+	               ] skips to the next marker, q quits
 
 	convert-cast convert snapshot to asciicast format
 	             scripttest convert-cast snapshot.json recording.cast
 
+	cast-to-script
+	             draft a test script from a recorded shell session
+	             scripttest cast-to-script -o testdata/new.txt session.cast
+	             - Each command is followed by stdout assertions for its output
+	             - Use -prompt to match an unusual shell prompt
+	             - Use -max-lines to limit the assertions per command
+
+	render       render an asciicast or snapshot as an SVG or PNG image
+	             scripttest render -o docs/demo.svg recordings/example.cast
+	             - SVG images are animated; use -still for the final screen,
+	               or -still -at <seconds> for the screen at that time
+	             - PNG images are always still
+	             - Use -speed, -idle-time-limit and -font-size to adjust
 
 	help         show available commands and conditions
 	             scripttest help
@@ -50,6 +111,7 @@ Test files are plain text files with a specific format. Each file contains:
 3. Assertions (expected output checks)
 4. Optional file definitions (marked with -- filename --)
 5. Optional Dockerfile definitions (marked with -- Dockerfile --)
+6. Optional service dependencies (marked with -- services.yaml --)
 
 Example test file:
 
@@ -71,12 +133,17 @@ Example test file:
 	-- testfile.txt --         # Create a file that will be available during tests
 	This is test content.
 
-	-- Dockerfile --           # Define a Dockerfile for Docker-based tests
-	FROM golang:latest
-	WORKDIR /app
+	-- Dockerfile --           # Define a Dockerfile for Docker-based tests; the
+	FROM golang:latest         # container runs go test -json in /app instead
+	WORKDIR /app               # of the image's CMD
 	COPY . .
 	RUN go mod download
-	CMD ["go", "test", "-v"]
+
+	-- services.yaml --        # Start containers the script depends on
+	services:
+	  db:
+	    image: postgres:16
+	    ports: ["5432"]
 
 ASSERTIONS:
 
@@ -102,25 +169,96 @@ SPECIAL FEATURES:
 2. Docker Support:
    - Use -docker flag when running tests
    - Specify custom image with -docker-image flag
-   - Include a Dockerfile section with "-- Dockerfile --" marker
-   - For Windows, use "-- Dockerfile.windows --" 
+   - Use podman or nerdctl instead with -runtime=podman or -runtime=nerdctl
+   - Include a Dockerfile section with "-- Dockerfile --" marker; scripts
+     without one use the default image, and failures from every container
+     are reported together
+   - Add variants for other platforms as "-- Dockerfile.<os> --",
+     "-- Dockerfile.<arch> --" or "-- Dockerfile.<os>.<arch> --"; the most
+     specific one for the target platform is used, so Dockerfile.linux.arm64
+     falls back to Dockerfile.linux and then Dockerfile
+   - Set the target platform with -docker-platform=os/arch (e.g.
+     -docker-platform=windows/amd64); it is passed to the build and run
+     commands, and defaults to linux on the host's architecture
 
 3. Snapshots:
    - Record output with: snapshot 'name'
+   - Without a name, snapshots are named after the script (script, script-2, ...)
+   - Snapshots are stored in __snapshots__ next to the script as name.json
+   - A name that is a path (snapshot golden/out.json) is relative to the directory
+     of the script, not to $WORK
+   - Store new snapshots as readable txtar files (name.txtar, with stdout and stderr
+     sections) with: scripttest -snapshot-format=txtar test, or SNAPSHOT_FORMAT=txtar;
+     snapshots in either format are read, and updating converts them
+   - Keep expected output in the script itself, in "-- __snapshot__/name --" sections,
+     with: snapshot -inline 'name', or -snapshot-format=inline for all new snapshots;
+     updating rewrites the script in place
+   - Record how exec output was written, with its timing and the terminal size
+     ($COLUMNS x $LINES, default 80x24), with: scripttest -snapshot-timing test;
+     convert-cast then reproduces the original pacing of stdout and stderr
+   - A name.<GOOS>.json variant (e.g. name.linux.json) is preferred when present;
+     record one with: snapshot -goos 'name'
+   - $WORK, $HOME and $TMPDIR are replaced with placeholders
+   - Redact other volatile output with: snapshot-filter '[0-9]+ms' '<DURATION>'
+   - Compare JSON output structurally, ignoring key order and formatting, with:
+     snapshot -format=json -ignore=.metadata.createdAt 'name'
+     (mismatches are reported per JSON path); -format=yaml does the same for YAML
+   - Snapshot the files a command generates with: snapshot-tree out 'name'
+     (stored in txtar format as name.tree.txtar, with each file's mode and content)
+   - Mismatches report a unified diff; the full diff is saved as <snapshot>.diff
+     and the new output as <snapshot>.new (for inline snapshots, in __snapshots__
+     as <script>#<name>.inline.diff and .new)
+   - Accept, reject or skip each pending change with: scripttest snapshots review
    - Update snapshots with: UPDATE_SNAPSHOTS=1 scripttest test
+   - Fail early on missing or unused snapshots: scripttest snapshots check
+   - Delete snapshots no script references: scripttest snapshots prune
+     (both follow -snapshot-format, so under inline, snapshots without a file are not missing)
    - Playback snapshots with: scripttest playback path/to/snapshot
-
-4. Asciicast Recordings:
+     (add -timed to reproduce recorded timing, -color to show stderr in red)
+
+4. Service Dependencies:
+   - Declare containers a script needs in a "-- services.yaml --" section, in
+     the Compose file format: image, command, ports, environment and healthcheck
+   - They are started with docker before the script and removed after it;
+     the script waits until services with a healthcheck are healthy
+   - The first published port of a service named db is at $SERVICE_DB_ADDR
+     ($SERVICE_DB_HOST:$SERVICE_DB_PORT); each port is at $SERVICE_DB_PORT_<port>
+   - Works the same for scripts run by the testscript package
+
+5. Asciicast Recordings:
    - Record test execution: scripttest record test.txt output.cast
+     (each command and its output is timed as it happens)
    - Play recordings: scripttest play-cast output.cast
    - Convert snapshots: scripttest convert-cast snapshot.json output.cast
+   - Draft a script from a session: scripttest cast-to-script session.cast
+   - Render README demos: scripttest render -o demo.svg output.cast
+     (output is played on a built-in VT100 terminal emulator, so the image
+     shows the screen as a terminal would; use .png for a still screenshot)
 
-5. Auto Go Toolchain:
+6. Auto Go Toolchain:
    - Automatically downloads and installs Go if not found
    - Enable with -auto-go flag (default: true)
    - Disable with -auto-go=false
-
-6. Environment Variables:
+   - Installs the version required by the go.mod of the current module: its
+     toolchain directive, or its go directive if that is newer; "go 1.22"
+     means the latest 1.22.x release, and outside a module the latest release
+     is used, as listed by https://go.dev/dl/?mode=json
+   - Download from a mirror with -go-dl-url=URL or SCRIPTTEST_GO_DL_URL; the
+     mirror serves the JSON index at the URL (e.g. as index.html) and the
+     archives under it
+   - Toolchains are installed in the user cache directory, one directory per
+     version (e.g. ~/.cache/scripttest/go/go1.23.1 on Linux), and reused by
+     later runs; a system Go installation is never modified
+   - Downloads are verified against the SHA-256 checksums in the index
+   - Run the tests with several toolchains with test -go=1.22,1.23,tip: each
+     is installed, the suite runs once with each, with PATH and GOROOT set to
+     it, and the results are printed as a grid of scripts by version;
+     versions are 1.22 (the latest 1.22.x), go1.22.3, tip (built from source
+     with the latest release, in the gotip directory) or local (the go on
+     PATH)
+   - See and remove installed toolchains with scripttest toolchain
+
+7. Environment Variables:
    - Set with: env NAME=value
    - Test with: env NAME
 
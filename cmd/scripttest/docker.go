@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// A containerImage is an image built for scripts that share a Dockerfile.
+type containerImage struct {
+	dockerfile string   // Dockerfile content
+	platform   string   // platform to build for, or "" for the default
+	name       string   // bake target name
+	tag        string   // image tag, derived from the Dockerfile content
+	scripts    []string // scripts to run in the image
+}
+
+// runTestInDocker runs the scripts matching pattern in containers. Each
+// script runs in an image built from its own Dockerfile section for the
+// target platform (see scriptDockerfile), or from a default Dockerfile
+// based on -docker-image. Scripts with the same Dockerfile share an image
+// and a container.
+func runTestInDocker(pattern string) error {
+	rt, err := newRuntime(runtimeName, execRunner{stdout: os.Stdout, stderr: os.Stderr})
+	if err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("running tests with %s with pattern: %s", rt.name(), pattern)
+	}
+
+	// Set up the harness as for local runs. The container sees the work
+	// directory as /app and the directory of each script as /scripts/<n>,
+	// which the links in /app/testdata point to, so that snapshots are
+	// read and updated where they are on the host.
+	var hostDirs []string // mounted as /scripts/<index>
+	dir, matches, err := prepareWorkDir(pattern, func(abs string) string {
+		i := slices.Index(hostDirs, filepath.Dir(abs))
+		if i < 0 {
+			i = len(hostDirs)
+			hostDirs = append(hostDirs, filepath.Dir(abs))
+		}
+		return path.Join("/scripts", strconv.Itoa(i), filepath.Base(abs))
+	})
+	if err != nil {
+		return err
+	}
+	images, err := groupByDockerfile(matches, dockerPlatform)
+	if err != nil {
+		return err
+	}
+	return runImages(rt, dir, hostDirs, images)
+}
+
+// runImages builds images in the work directory dir and runs each image's
+// scripts in a container, with dir mounted as /app and each of hostDirs as
+// /scripts/<index>. It continues after failures so that every script
+// runs, and reports the scripts that failed.
+func runImages(rt containerRuntime, dir string, hostDirs []string, images []*containerImage) error {
+	if err := rt.build(dir, images); err != nil {
+		return err
+	}
+	var failed []string
+	for _, img := range images {
+		if verbose {
+			log.Printf("running %d scripts in %s", len(img.scripts), img.tag)
+		}
+		c := containerRun{image: img.tag, platform: img.platform, workdir: "/app"}
+
+		// Mount the workspace and the script directories
+		c.mounts = append(c.mounts, dir+":/app")
+		for i, hostDir := range hostDirs {
+			c.mounts = append(c.mounts, fmt.Sprintf("%s:/scripts/%d", hostDir, i))
+		}
+
+		// Run exactly the image's scripts, by their paths in /app
+		var scripts []string
+		for _, file := range img.scripts {
+			scripts = append(scripts, filepath.ToSlash(workPath(file)))
+		}
+		c.env = append(c.env, "SCRIPTTEST_PATTERN="+strings.Join(scripts, ":"))
+
+		// Pass through environment variables
+		c.env = append(c.env, harnessEnv()...)
+
+		results, failures, err := runHarness(rt, c)
+		var imgFailed []string
+		for _, file := range img.scripts {
+			// Scripts without a result did not run, such as when the tests
+			// failed to build
+			name := subtestName(strings.TrimSuffix(filepath.Base(file), ".txt"))
+			if result := results[name]; result != "ok" && result != "skip" {
+				imgFailed = append(imgFailed, file)
+			}
+		}
+		if err != nil && len(imgFailed) == 0 {
+			// Every script passed, but something else failed
+			imgFailed = append(imgFailed, img.tag)
+		}
+		if len(imgFailed) > 0 {
+			// Show why the tests failed
+			fmt.Printf("--- %s\n%s", img.tag, failures)
+			if err != nil {
+				log.Printf("tests failed in %s: %v", img.tag, err)
+			}
+			failed = append(failed, imgFailed...)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed in %s: %s", rt.name(), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runHarness runs the container c, which runs the test harness of its
+// scripts with go test -json, and returns the result of each script that
+// ran and the output explaining failures (see matrixResults). The scripts
+// of an image share a container, so its exit status cannot tell them apart.
+func runHarness(rt containerRuntime, c containerRun) (results map[string]string, failures string, err error) {
+	var output io.Writer = io.Discard
+	if verbose {
+		output = os.Stdout
+	}
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		results, failures = matrixResults(r, output)
+		close(done)
+	}()
+	c.command = []string{"go", "test", "-json"}
+	c.stdout = w
+	err = rt.run(c)
+	w.Close()
+	<-done
+	return results, failures, err
+}
+
+// harnessEnv returns the settings passed through to the test harness in
+// a container, as NAME=value pairs.
+func harnessEnv() []string {
+	var env []string
+	if verbose {
+		env = append(env, "VERBOSE=1")
+	}
+	if os.Getenv("UPDATE_SNAPSHOTS") == "1" {
+		env = append(env, "UPDATE_SNAPSHOTS=1")
+	}
+	if snapshotFormat != "" {
+		env = append(env, "SNAPSHOT_FORMAT="+snapshotFormat)
+	}
+	if snapshotTiming {
+		env = append(env, "SNAPSHOT_TIMING=1")
+	}
+	return env
+}
+
+// groupByDockerfile groups scripts by the Dockerfile they run in on
+// platform, in the order the Dockerfiles are first used. An empty platform
+// selects Dockerfiles for the default platform (see targetPlatform) and
+// leaves the images' platform to the container runtime.
+func groupByDockerfile(scripts []string, platform string) ([]*containerImage, error) {
+	goos, goarch := targetPlatform(platform)
+	var images []*containerImage
+	byDockerfile := make(map[string]*containerImage)
+	for _, file := range scripts {
+		dockerfile, err := scriptDockerfile(file, goos, goarch)
+		if err != nil {
+			return nil, err
+		}
+		if dockerfile == "" {
+			dockerfile = defaultDockerfile()
+		}
+		img := byDockerfile[dockerfile]
+		if img == nil {
+			// The same Dockerfile built for another platform is another image
+			sum := fmt.Sprintf("%x", sha256.Sum256([]byte(platform+"\n"+dockerfile)))[:12]
+			img = &containerImage{
+				dockerfile: dockerfile,
+				platform:   platform,
+				name:       "scripttest-" + sum,
+				tag:        "scripttest-runner:" + sum,
+			}
+			byDockerfile[dockerfile] = img
+			images = append(images, img)
+		}
+		img.scripts = append(img.scripts, file)
+	}
+	return images, nil
+}
+
+// targetPlatform returns the operating system and architecture of a
+// platform given as os[/arch[/variant]], as for docker --platform. The
+// default is Linux on the host's architecture, since Linux containers are
+// the ones every runtime supports.
+func targetPlatform(platform string) (goos, goarch string) {
+	goos, goarch = "linux", runtime.GOARCH
+	if platform == "" {
+		return goos, goarch
+	}
+	parts := strings.Split(platform, "/")
+	goos = parts[0]
+	if len(parts) > 1 {
+		goarch = parts[1]
+	}
+	return goos, goarch
+}
+
+// scriptDockerfile returns the content of the script's Dockerfile section
+// for a platform, or "" if it has none. The most specific of the sections
+// Dockerfile.<os>.<arch>, Dockerfile.<os>, Dockerfile.<arch> and
+// Dockerfile is used, so that Dockerfile.linux.arm64 falls back to
+// Dockerfile.linux and then Dockerfile.
+func scriptDockerfile(file, goos, goarch string) (string, error) {
+	a, err := txtar.ParseFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read test file %s: %v", file, err)
+	}
+	sections := make(map[string]string)
+	for _, f := range a.Files {
+		sections[f.Name] = strings.TrimSpace(string(f.Data)) + "\n"
+	}
+	for _, name := range []string{
+		"Dockerfile." + goos + "." + goarch,
+		"Dockerfile." + goos,
+		"Dockerfile." + goarch,
+		"Dockerfile",
+	} {
+		if dockerfile, ok := sections[name]; ok {
+			return dockerfile, nil
+		}
+	}
+	return "", nil
+}
+
+// defaultDockerfile returns the Dockerfile for scripts without one.
+func defaultDockerfile() string {
+	image := dockerImage
+	if image == "" {
+		image = "golang:latest"
+	}
+	return fmt.Sprintf(`FROM %s
+WORKDIR /app
+COPY . .
+RUN go mod download
+`, image)
+}
+
+// dockerfileName returns the name of the image's Dockerfile in the build
+// context.
+func (img *containerImage) dockerfileName() string {
+	return "Dockerfile." + strings.TrimPrefix(img.name, "scripttest-")
+}
+
+// writeDockerfiles writes each image's Dockerfile to dir.
+func writeDockerfiles(dir string, images []*containerImage) error {
+	for _, img := range images {
+		if err := os.WriteFile(filepath.Join(dir, img.dockerfileName()), []byte(img.dockerfile), 0644); err != nil {
+			return fmt.Errorf("failed to write Dockerfile: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeBakeFile writes a docker-bake.hcl file to dir that builds all the
+// images from their Dockerfiles.
+func writeBakeFile(dir string, images []*containerImage) error {
+	var targets, bake strings.Builder
+	for i, img := range images {
+		if i > 0 {
+			targets.WriteString(", ")
+		}
+		fmt.Fprintf(&targets, "%q", img.name)
+		fmt.Fprintf(&bake, "\ntarget %q {\n\tcontext = \".\"\n\tdockerfile = %q\n\ttags = [%q]\n", img.name, img.dockerfileName(), img.tag)
+		if img.platform != "" {
+			fmt.Fprintf(&bake, "\tplatforms = [%q]\n", img.platform)
+		}
+		bake.WriteString("}\n")
+	}
+	content := fmt.Sprintf("group \"default\" {\n\ttargets = [%s]\n}\n%s", targets.String(), bake.String())
+	if err := os.WriteFile(filepath.Join(dir, "docker-bake.hcl"), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write docker-bake.hcl: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeScript(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestScriptDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	all := writeScript(t, dir, "all.txt", `exec true
+-- Dockerfile --
+FROM generic
+-- Dockerfile.linux --
+FROM linux
+-- Dockerfile.linux.arm64 --
+FROM linux-arm64
+-- Dockerfile.windows --
+FROM windows
+-- Dockerfile.riscv64 --
+FROM riscv64
+`)
+	plain := writeScript(t, dir, "plain.txt", "exec true\n-- Dockerfile --\nFROM generic\n\n")
+	none := writeScript(t, dir, "none.txt", "exec true\n-- data.txt --\nhello\n")
+
+	tests := []struct {
+		file, goos, goarch, want string
+	}{
+		{all, "linux", "arm64", "FROM linux-arm64\n"},
+		{all, "linux", "amd64", "FROM linux\n"},
+		{all, "windows", "amd64", "FROM windows\n"},
+		{all, "freebsd", "riscv64", "FROM riscv64\n"},
+		{all, "darwin", "amd64", "FROM generic\n"},
+		{plain, "linux", "arm64", "FROM generic\n"},
+		{none, "linux", "amd64", ""},
+	}
+	for _, tt := range tests {
+		got, err := scriptDockerfile(tt.file, tt.goos, tt.goarch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("scriptDockerfile(%s, %s, %s) = %q, want %q", filepath.Base(tt.file), tt.goos, tt.goarch, got, tt.want)
+		}
+	}
+}
+
+func TestGroupByDockerfile(t *testing.T) {
+	dir := t.TempDir()
+	a := writeScript(t, dir, "a.txt", "exec true\n-- Dockerfile --\nFROM alpine\n")
+	b := writeScript(t, dir, "b.txt", "exec true\n")
+	c := writeScript(t, dir, "c.txt", "exec true\n-- Dockerfile --\nFROM alpine\n-- Dockerfile.windows --\nFROM windows\n")
+
+	images, err := groupByDockerfile([]string{a, b, c}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want 2", len(images))
+	}
+	if got := images[0].scripts; len(got) != 2 || got[0] != a || got[1] != c {
+		t.Errorf("alpine image scripts = %v, want a.txt and c.txt", got)
+	}
+	if !strings.HasPrefix(images[1].dockerfile, "FROM golang:latest\n") {
+		t.Errorf("script without a Dockerfile uses %q, want the default", images[1].dockerfile)
+	}
+
+	// A platform selects variants and changes the image tags
+	windows, err := groupByDockerfile([]string{a, c}, "windows/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 2 || windows[1].dockerfile != "FROM windows\n" || windows[1].platform != "windows/amd64" {
+		t.Fatalf("windows images = %+v, want alpine and windows images", windows)
+	}
+	if windows[0].tag == images[0].tag {
+		t.Errorf("alpine image for windows/amd64 has the default platform's tag %s", windows[0].tag)
+	}
+}
+
+func TestTargetPlatform(t *testing.T) {
+	tests := []struct {
+		platform, goos, goarch string
+	}{
+		{"linux/arm64", "linux", "arm64"},
+		{"linux/arm/v7", "linux", "arm"},
+		{"windows/amd64", "windows", "amd64"},
+	}
+	for _, tt := range tests {
+		if goos, goarch := targetPlatform(tt.platform); goos != tt.goos || goarch != tt.goarch {
+			t.Errorf("targetPlatform(%q) = %s, %s, want %s, %s", tt.platform, goos, goarch, tt.goos, tt.goarch)
+		}
+	}
+	if goos, _ := targetPlatform(""); goos != "linux" {
+		t.Errorf("default platform OS = %s, want linux", goos)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+)
+
+// A doctorCheck is the result of checking a tool scripttest uses.
+type doctorCheck struct {
+	tool   string
+	status string // ok, warn (an optional feature is unavailable) or FAIL
+	detail string // what was found, or what to do about it
+}
+
+// runDoctor runs the doctor command, which checks that the tools scripttest
+// uses are available and says how to fix what is not.
+func runDoctor(args []string) error {
+	checks := []doctorCheck{checkGo(), checkContainerRuntime(), checkExpect(), checkAsciinema()}
+	failed := printChecks(os.Stdout, checks)
+	if failed > 0 {
+		return fmt.Errorf("%d required tools are not usable", failed)
+	}
+	return nil
+}
+
+// printChecks prints the results of checks and returns how many failed.
+func printChecks(w io.Writer, checks []doctorCheck) int {
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, c := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", c.status, c.tool, c.detail)
+		if c.status == "FAIL" {
+			failed++
+		}
+	}
+	tw.Flush()
+	return failed
+}
+
+// commandOutput runs a command and returns the first line of its output.
+func commandOutput(program string, args ...string) (string, error) {
+	out, err := exec.Command(program, args...).CombinedOutput()
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if err != nil {
+		if line != "" {
+			return "", fmt.Errorf("%v: %s", err, line)
+		}
+		return "", err
+	}
+	return line, nil
+}
+
+// checkGo checks that there is a go command new enough for the current
+// module, which the test harness is built with.
+func checkGo() doctorCheck {
+	c := doctorCheck{tool: "go"}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		if autoGoToolchain {
+			c.status, c.detail = "warn", "not found; scripttest test installs it when needed, or run 'scripttest toolchain install' to install it now"
+		} else {
+			c.status, c.detail = "FAIL", "not found; install Go from https://go.dev/dl/ or drop -auto-go=false to let scripttest install it"
+		}
+		return c
+	}
+	version, err := commandOutput(goCmd, "env", "GOVERSION")
+	if err != nil {
+		c.status, c.detail = "FAIL", fmt.Sprintf("%s does not work: %v; reinstall Go from https://go.dev/dl/", goCmd, err)
+		return c
+	}
+	c.status, c.detail = "ok", fmt.Sprintf("%s (%s)", version, goCmd)
+
+	// A devel toolchain is assumed to be new enough
+	if required, err := moduleGoVersion("."); err == nil && required != "" && strings.HasPrefix(version, "go1") {
+		have, _, _ := strings.Cut(strings.TrimPrefix(version, "go"), "-")
+		if compareGoVersions(have, required) < 0 {
+			c.status = "warn"
+			c.detail += fmt.Sprintf("; go.mod requires Go %s, run 'scripttest toolchain install auto' to install it", required)
+		}
+	}
+	return c
+}
+
+// checkContainerRuntime checks for a container runtime and that it can
+// run containers, which -docker, services.yaml and the docker commands
+// need.
+func checkContainerRuntime() doctorCheck {
+	c := doctorCheck{tool: "docker"}
+	for _, rt := range runtimes {
+		if _, err := exec.LookPath(rt); err != nil {
+			continue
+		}
+		c.tool = rt
+		version, err := commandOutput(rt, "--version")
+		if err != nil {
+			c.status, c.detail = "warn", fmt.Sprintf("%s does not work: %v; reinstall it", rt, err)
+			return c
+		}
+		if _, err := commandOutput(rt, "info"); err != nil {
+			c.status, c.detail = "warn", fmt.Sprintf("%s; cannot reach the daemon (%v); start it, e.g. with 'systemctl start %s' or Docker Desktop, and check you may use it", version, err, rt)
+			return c
+		}
+		c.status, c.detail = "ok", version
+		if rt != "docker" {
+			c.status = "warn"
+			c.detail += "; -docker works, but services.yaml and the docker commands need docker"
+		}
+		return c
+	}
+	c.status, c.detail = "warn", "no container runtime found; install Docker (https://docs.docker.com/get-docker/), podman or nerdctl to use -docker, services.yaml and the docker commands"
+	return c
+}
+
+// checkExpect checks for expect, which the expect commands run.
+func checkExpect() doctorCheck {
+	c := doctorCheck{tool: "expect"}
+	if _, err := exec.LookPath("expect"); err != nil {
+		c.status, c.detail = "warn", "not found; the expect commands need it; install it with your package manager (e.g. apt install expect or brew install expect)"
+		return c
+	}
+	version, err := commandOutput("expect", "-v")
+	if err != nil {
+		c.status, c.detail = "warn", fmt.Sprintf("expect does not work: %v; reinstall it", err)
+		return c
+	}
+	c.status, c.detail = "ok", version
+	return c
+}
+
+// checkAsciinema checks for asciinema, which records the shell sessions
+// cast-to-script turns into scripts. Recording scripts does not need it.
+func checkAsciinema() doctorCheck {
+	c := doctorCheck{tool: "asciinema"}
+	if _, err := exec.LookPath("asciinema"); err != nil {
+		c.status, c.detail = "warn", "not found; only needed to record shell sessions for cast-to-script (scripttest record does not need it); see https://docs.asciinema.org/getting-started/"
+		return c
+	}
+	version, err := commandOutput("asciinema", "--version")
+	if err != nil {
+		c.status, c.detail = "warn", fmt.Sprintf("asciinema does not work: %v; reinstall it", err)
+		return c
+	}
+	c.status, c.detail = "ok", version
+	return c
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tools are shell scripts")
+	}
+	bin := t.TempDir()
+	t.Setenv("PATH", bin)
+	fake := func(name, script string) {
+		writeScript(t, bin, name, "#!/bin/sh\n"+script)
+		if err := os.Chmod(bin+"/"+name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(c doctorCheck, tool, status, detail string) {
+		t.Helper()
+		if c.tool != tool || c.status != status || !strings.Contains(c.detail, detail) {
+			t.Errorf("check = %+v, want %s %s with %q", c, status, tool, detail)
+		}
+	}
+
+	// Nothing is installed
+	old := autoGoToolchain
+	t.Cleanup(func() { autoGoToolchain = old })
+	autoGoToolchain = true
+	check(checkGo(), "go", "warn", "scripttest test installs it")
+	autoGoToolchain = false
+	check(checkGo(), "go", "FAIL", "install Go from https://go.dev/dl/")
+	check(checkContainerRuntime(), "docker", "warn", "no container runtime found")
+	check(checkExpect(), "expect", "warn", "apt install expect")
+	check(checkAsciinema(), "asciinema", "warn", "scripttest record does not need it")
+
+	// Everything is installed, but go is too old for the module, the
+	// docker daemon is down, and only podman works
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	writeScript(t, ".", "go.mod", "module m\n\ngo 1.22.0\n")
+	fake("go", `echo go1.21.13`)
+	fake("docker", `[ "$1" = info ] && { echo "Cannot connect to the Docker daemon" >&2; exit 1; }; echo Docker version 27.0.3`)
+	fake("expect", `echo expect version 5.45.4`)
+	fake("asciinema", `echo asciinema 2.4.0`)
+	check(checkGo(), "go", "warn", "go.mod requires Go 1.22.0, run 'scripttest toolchain install auto'")
+	check(checkContainerRuntime(), "docker", "warn", "Cannot connect to the Docker daemon")
+	check(checkExpect(), "expect", "ok", "expect version 5.45.4")
+	check(checkAsciinema(), "asciinema", "ok", "asciinema 2.4.0")
+
+	fake("go", `echo go1.22.3`)
+	check(checkGo(), "go", "ok", "go1.22.3 ("+bin+"/go)")
+	os.Remove(bin + "/docker")
+	fake("podman", `echo podman version 5.0.0`)
+	check(checkContainerRuntime(), "podman", "warn", "services.yaml and the docker commands need docker")
+
+	var out strings.Builder
+	failed := printChecks(&out, []doctorCheck{
+		{"go", "FAIL", "not found"},
+		{"asciinema", "ok", "asciinema 2.4.0"},
+	})
+	if want := "FAIL  go         not found\nok    asciinema  asciinema 2.4.0\n"; failed != 1 || out.String() != want {
+		t.Errorf("printChecks = %d, printed %q, want 1, %q", failed, out.String(), want)
+	}
+}
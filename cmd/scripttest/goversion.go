@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// defaultGoDownloadURL is where Go releases are downloaded from unless
+// -go-dl-url or $SCRIPTTEST_GO_DL_URL says otherwise.
+const defaultGoDownloadURL = "https://go.dev/dl/"
+
+// goDownloadURL is the base URL of the Go download index and archives. The
+// index is read from the base URL with ?mode=json, so a mirror can serve it
+// as the index.html of a static directory holding the archives.
+var goDownloadURL = defaultGoDownloadURL
+
+// defaultGoDownloadBase returns $SCRIPTTEST_GO_DL_URL, or the go.dev
+// download page if it is not set.
+func defaultGoDownloadBase() string {
+	if url := os.Getenv("SCRIPTTEST_GO_DL_URL"); url != "" {
+		return url
+	}
+	return defaultGoDownloadURL
+}
+
+// goDownloadLink returns the URL of a file in the Go download directory.
+func goDownloadLink(file string) string {
+	return strings.TrimSuffix(goDownloadURL, "/") + "/" + file
+}
+
+// A goRelease is a Go release listed in the download index.
+type goRelease struct {
+	Version string   `json:"version"` // such as go1.22.3
+	Stable  bool     `json:"stable"`
+	Files   []goFile `json:"files"`
+}
+
+// A goFile is a file of a Go release.
+type goFile struct {
+	Filename string `json:"filename"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	Kind     string `json:"kind"` // archive, installer or source
+}
+
+// resolveGoVersion returns the Go version, such as 1.22.3, to install for
+// the module containing dir. It is the version the module's toolchain or go
+// directive requires, whichever is newer; a language version such as 1.22
+// resolves to its latest stable release. Outside a module, or for a module
+// without a go directive, it is the latest stable release.
+func resolveGoVersion(dir string) (string, error) {
+	required, err := moduleGoVersion(dir)
+	if err != nil {
+		return "", err
+	}
+	if isGoRelease(required) {
+		return required, nil
+	}
+	releases, err := fetchGoReleases(goDownloadURL)
+	if err != nil {
+		return "", err
+	}
+	return latestGoRelease(releases, required)
+}
+
+// moduleGoVersion returns the Go version required by the go.mod file of
+// the module containing dir, or "" if there is none.
+func moduleGoVersion(dir string) (string, error) {
+	file, err := findGoMod(dir)
+	if file == "" || err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	f, err := modfile.Parse(file, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	var version string
+	if f.Go != nil {
+		version = f.Go.Version
+	}
+	// The toolchain directive only matters when it is newer than the go one
+	if f.Toolchain != nil && strings.HasPrefix(f.Toolchain.Name, "go") {
+		toolchain, _, _ := strings.Cut(strings.TrimPrefix(f.Toolchain.Name, "go"), "-")
+		if compareGoVersions(toolchain, version) > 0 {
+			version = toolchain
+		}
+	}
+	return version, nil
+}
+
+// findGoMod returns the go.mod file of the module containing dir, or "" if
+// dir is not in a module.
+func findGoMod(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		file := filepath.Join(dir, "go.mod")
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// fetchGoReleases reads the Go download index at baseURL, including
+// unstable and archived releases.
+func fetchGoReleases(baseURL string) ([]goRelease, error) {
+	url := strings.TrimSuffix(baseURL, "/") + "/?mode=json&include=all"
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch Go releases: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch Go releases from %s: %s", url, resp.Status)
+	}
+	var releases []goRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, fmt.Errorf("invalid Go release index at %s: %v", url, err)
+	}
+	return releases, nil
+}
+
+// latestGoRelease returns the latest stable release in releases for the
+// language version lang, such as 1.22, or overall if lang is "".
+func latestGoRelease(releases []goRelease, lang string) (string, error) {
+	var latest string
+	for _, r := range releases {
+		v := strings.TrimPrefix(r.Version, "go")
+		if !r.Stable || (lang != "" && goLanguage(v) != lang) {
+			continue
+		}
+		if latest == "" || compareGoVersions(v, latest) > 0 {
+			latest = v
+		}
+	}
+	if latest == "" {
+		if lang != "" {
+			return "", fmt.Errorf("no stable Go %s release found at %s", lang, goDownloadURL)
+		}
+		return "", fmt.Errorf("no stable Go release found at %s", goDownloadURL)
+	}
+	return latest, nil
+}
+
+// isGoRelease reports whether v names a release, such as 1.22.3 or
+// 1.23rc1, rather than a language version, such as 1.22.
+func isGoRelease(v string) bool {
+	_, _, rest := parseGoVersion(v)
+	return rest != ""
+}
+
+// goLanguage returns the language version of a Go version: 1.22 for
+// 1.22.3 or 1.22rc1. Before Go 1.21, releases such as 1.20 named their
+// language version and had no .0 patch release.
+func goLanguage(v string) string {
+	major, minor, _ := parseGoVersion(v)
+	if minor == "" {
+		return major
+	}
+	return major + "." + minor
+}
+
+// parseGoVersion splits a Go version into its major and minor versions
+// and the rest, such as ".3" or "rc1".
+func parseGoVersion(v string) (major, minor, rest string) {
+	major, rest, ok := strings.Cut(v, ".")
+	if !ok {
+		return major, "", ""
+	}
+	i := 0
+	for i < len(rest) && '0' <= rest[i] && rest[i] <= '9' {
+		i++
+	}
+	return major, rest[:i], rest[i:]
+}
+
+// compareGoVersions compares Go versions such as 1.21, 1.21rc1 and 1.21.0
+// the way the go command does, returning -1, 0 or +1. A language version
+// sorts before its prereleases, which sort before its releases. The empty
+// version sorts first.
+func compareGoVersions(a, b string) int {
+	amaj, amin, arest := parseGoVersion(a)
+	bmaj, bmin, brest := parseGoVersion(b)
+	if c := compareNumbers(amaj, bmaj); c != 0 {
+		return c
+	}
+	if c := compareNumbers(amin, bmin); c != 0 {
+		return c
+	}
+	// The rest is "", a prerelease such as "rc1", or a patch such as ".3"
+	rank := func(rest string) (int, string, string) {
+		switch {
+		case rest == "":
+			return 0, "", ""
+		case rest[0] == '.':
+			return 2, "", rest[1:]
+		}
+		i := strings.IndexAny(rest, "0123456789")
+		if i < 0 {
+			return 1, rest, ""
+		}
+		return 1, rest[:i], rest[i:]
+	}
+	ar, akind, anum := rank(arest)
+	br, bkind, bnum := rank(brest)
+	if ar != br {
+		if ar < br {
+			return -1
+		}
+		return 1
+	}
+	if akind != bkind {
+		// beta sorts before rc
+		return strings.Compare(akind, bkind)
+	}
+	return compareNumbers(anum, bnum)
+}
+
+// compareNumbers compares decimal numbers given as strings, with "" as
+// the smallest.
+func compareNumbers(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testIndex is a Go download index listing a few releases.
+const testIndex = `[
+	{"version": "go1.23rc1", "stable": false, "files": []},
+	{"version": "go1.22.10", "stable": true, "files": []},
+	{"version": "go1.22.2", "stable": true, "files": []},
+	{"version": "go1.21.13", "stable": true, "files": []},
+	{"version": "go1.20", "stable": true, "files": []},
+	{"version": "go1.20.14", "stable": true, "files": []}
+]`
+
+// serveGoDownloads serves files from a directory, with index.html as the
+// download index, the way a static mirror would, and points goDownloadURL
+// at it for the duration of the test.
+func serveGoDownloads(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(srv.Close)
+	old := goDownloadURL
+	goDownloadURL = srv.URL + "/"
+	t.Cleanup(func() { goDownloadURL = old })
+	return dir
+}
+
+func TestResolveGoVersion(t *testing.T) {
+	serveGoDownloads(t, map[string]string{"index.html": testIndex})
+	tests := []struct {
+		gomod string // "" for no go.mod
+		want  string
+	}{
+		{"", "1.22.10"},
+		{"module m\n", "1.22.10"},
+		{"module m\n\ngo 1.21\n", "1.21.13"},
+		{"module m\n\ngo 1.20\n", "1.20.14"},
+		{"module m\n\ngo 1.21.4\n", "1.21.4"},
+		{"module m\n\ngo 1.22.0\n\ntoolchain go1.22.5\n", "1.22.5"},
+		{"module m\n\ngo 1.22.0\n\ntoolchain go1.21.1\n", "1.22.0"},
+		{"module m\n\ngo 1.21\n\ntoolchain default\n", "1.21.13"},
+		{"module m\n\ngo 1.23rc1\n", "1.23rc1"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if tt.gomod != "" {
+			writeScript(t, dir, "go.mod", tt.gomod)
+		}
+		// The module is found from its subdirectories
+		sub := filepath.Join(dir, "cmd", "tool")
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		got, err := resolveGoVersion(sub)
+		if err != nil {
+			t.Errorf("go.mod %q: %v", tt.gomod, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("go.mod %q: resolveGoVersion = %s, want %s", tt.gomod, got, tt.want)
+		}
+	}
+}
+
+func TestResolveGoVersionErrors(t *testing.T) {
+	serveGoDownloads(t, map[string]string{"index.html": testIndex})
+	dir := t.TempDir()
+	writeScript(t, dir, "go.mod", "module m\n\ngo 1.19\n")
+	if _, err := resolveGoVersion(dir); err == nil || !strings.Contains(err.Error(), "no stable Go 1.19 release found") {
+		t.Errorf("resolveGoVersion for go 1.19 error = %v", err)
+	}
+
+	serveGoDownloads(t, map[string]string{"index.html": "<html>"})
+	if _, err := resolveGoVersion(t.TempDir()); err == nil || !strings.Contains(err.Error(), "invalid Go release index") {
+		t.Errorf("resolveGoVersion with an invalid index error = %v", err)
+	}
+
+	serveGoDownloads(t, nil)
+	goDownloadURL += "missing/"
+	if _, err := resolveGoVersion(t.TempDir()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("resolveGoVersion without an index error = %v", err)
+	}
+}
+
+func TestCompareGoVersions(t *testing.T) {
+	// In increasing order
+	versions := []string{"", "1.9", "1.20", "1.20.14", "1.21", "1.21beta1", "1.21rc1", "1.21rc2", "1.21.0", "1.21.9", "1.21.10", "2.0"}
+	for i, a := range versions {
+		for j, b := range versions {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := compareGoVersions(a, b); got != want {
+				t.Errorf("compareGoVersions(%q, %q) = %d, want %d", a, b, got, want)
+			}
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/tmc/scripttestutil/playback"
 	_ "rsc.io/script/scripttest" // not strictly necessary but nice for go odc tool
 )
 
@@ -33,7 +34,11 @@ var (
 	pattern         string
 	useDocker       bool
 	dockerImage     string
+	runtimeName     string
+	dockerPlatform  string
 	autoGoToolchain bool
+	snapshotFormat  string
+	snapshotTiming  bool
 )
 
 func main() {
@@ -44,10 +49,23 @@ func main() {
 	flag.StringVar(&pattern, "p", "testdata/*.txt", "test file pattern")
 	flag.BoolVar(&useDocker, "docker", false, "run tests in Docker container")
 	flag.StringVar(&dockerImage, "docker-image", "", "Docker image to use (defaults to golang:latest)")
+	flag.StringVar(&dockerPlatform, "docker-platform", "", "platform to build and run containers for, as os/arch (e.g. linux/arm64)")
+	flag.StringVar(&runtimeName, "runtime", "", "container runtime for -docker: docker, podman or nerdctl (default: the first one installed)")
 	flag.BoolVar(&autoGoToolchain, "auto-go", true, "automatically download Go toolchain if needed")
+	flag.StringVar(&goDownloadURL, "go-dl-url", defaultGoDownloadBase(), "base `URL` of the Go download index and archives, for mirrors")
+	flag.StringVar(&snapshotFormat, "snapshot-format", os.Getenv("SNAPSHOT_FORMAT"), "format of new snapshots: json, txtar or inline")
+	flag.BoolVar(&snapshotTiming, "snapshot-timing", os.Getenv("SNAPSHOT_TIMING") == "1", "record output timing and terminal size in snapshots")
 	flag.Usage = usage
 	flag.Parse()
 
+	// The generated test harness reads the snapshot settings from the environment
+	if snapshotFormat != "" {
+		os.Setenv("SNAPSHOT_FORMAT", snapshotFormat)
+	}
+	if snapshotTiming {
+		os.Setenv("SNAPSHOT_TIMING", "1")
+	}
+
 	if flag.NArg() < 1 {
 		usage()
 	}
@@ -88,6 +106,26 @@ func main() {
 		if err := runConvertCast(args); err != nil {
 			log.Fatal(err)
 		}
+	case "cast-to-script":
+		if err := runCastToScript(args); err != nil {
+			log.Fatal(err)
+		}
+	case "render":
+		if err := runRender(args); err != nil {
+			log.Fatal(err)
+		}
+	case "snapshots":
+		if err := runSnapshots(args); err != nil {
+			log.Fatal(err)
+		}
+	case "toolchain":
+		if err := runToolchain(args); err != nil {
+			log.Fatal(err)
+		}
+	case "doctor":
+		if err := runDoctor(args); err != nil {
+			log.Fatal(err)
+		}
 	default:
 		usage()
 	}
@@ -266,64 +304,10 @@ func runTest(pattern string) error {
 		log.Printf("running tests matching pattern: %s", pattern)
 	}
 
-	// Get clean work directory
-	dir, err := getWorkDir()
-	if err != nil {
-		return fmt.Errorf("failed to get work directory: %v", err)
-	}
-
-	if verbose {
-		log.Printf("using work directory: %s", dir)
-	}
-
-	// Create testdata directory
-	testdata := filepath.Join(dir, "testdata")
-	if err := os.MkdirAll(testdata, 0755); err != nil {
-		return fmt.Errorf("failed to create testdata directory: %v", err)
-	}
-
-	// Set up test files in work directory
-	if err := setupTestDir(dir); err != nil {
-		return fmt.Errorf("failed to setup test directory: %v", err)
-	}
-
-	// Find matching test files
-	matches, err := filepath.Glob(pattern)
+	// Set up the harness, linking to the scripts where they are
+	dir, _, err := prepareWorkDir(pattern, func(abs string) string { return abs })
 	if err != nil {
-		return fmt.Errorf("invalid pattern: %v", err)
-	}
-	if len(matches) == 0 {
-		return fmt.Errorf("no files match pattern: %s", pattern)
-	}
-
-	// Create symlinks in testdata directory
-	for _, file := range matches {
-		abs, err := filepath.Abs(file)
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path for %s: %v", file, err)
-		}
-		dst := filepath.Join(testdata, filepath.Base(file))
-		if err := os.Symlink(abs, dst); err != nil {
-			return fmt.Errorf("failed to link test file %s: %v", file, err)
-		}
-	}
-
-	// link in .scripttest_info if it exists:
-	scriptTestInfo := ".scripttest_info"
-	if _, err := os.Stat(scriptTestInfo); err == nil {
-		abs, err := filepath.Abs(scriptTestInfo)
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path for .scripttest_info: %v", err)
-		}
-		dst := filepath.Join(dir, ".scripttest_info")
-		if err := os.Symlink(abs, dst); err != nil {
-			return fmt.Errorf("failed to link .scripttest_info: %v", err)
-		}
-	}
-
-	// Initialize go modules
-	if err := initModules(dir); err != nil {
-		return fmt.Errorf("failed to initialize modules: %v", err)
+		return err
 	}
 
 	buildID := getBuildID()
@@ -348,140 +332,79 @@ func runTest(pattern string) error {
 	return nil
 }
 
-func runTestInDocker(pattern string) error {
-	if verbose {
-		log.Printf("running tests in Docker with pattern: %s", pattern)
-	}
-
+// prepareWorkDir creates a work directory holding the test harness, with
+// the scripts matching pattern linked into its testdata directory, as
+// testdata/<base name>, and .scripttest_info linked next to it. Links
+// point to linkTarget of each file's absolute path, which is where the
+// file can be found when the tests run. It returns the directory and the
+// matching scripts.
+func prepareWorkDir(pattern string, linkTarget func(abs string) string) (string, []string, error) {
 	// Get clean work directory
 	dir, err := getWorkDir()
 	if err != nil {
-		return fmt.Errorf("failed to get work directory: %v", err)
+		return "", nil, fmt.Errorf("failed to get work directory: %v", err)
 	}
 
 	if verbose {
 		log.Printf("using work directory: %s", dir)
 	}
 
-	// Check for Dockerfile in test files
+	// Create testdata directory
+	testdata := filepath.Join(dir, "testdata")
+	if err := os.MkdirAll(testdata, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create testdata directory: %v", err)
+	}
+
+	// Set up test files in work directory
+	if err := setupTestDir(dir); err != nil {
+		return "", nil, fmt.Errorf("failed to setup test directory: %v", err)
+	}
+
+	// Find matching test files
 	matches, err := filepath.Glob(pattern)
 	if err != nil {
-		return fmt.Errorf("invalid pattern: %v", err)
+		return "", nil, fmt.Errorf("invalid pattern: %v", err)
 	}
 	if len(matches) == 0 {
-		return fmt.Errorf("no files match pattern: %s", pattern)
+		return "", nil, fmt.Errorf("no files match pattern: %s", pattern)
 	}
 
-	// Look for Dockerfile content in test files
-	var dockerfileContent string
+	// Create symlinks in testdata directory
 	for _, file := range matches {
-		data, err := os.ReadFile(file)
+		abs, err := filepath.Abs(file)
 		if err != nil {
-			return fmt.Errorf("failed to read test file %s: %v", file, err)
+			return "", nil, fmt.Errorf("failed to get absolute path for %s: %v", file, err)
 		}
-		content := string(data)
-
-		// Look for Dockerfile marker in test file
-		if idx := strings.Index(content, "-- Dockerfile --"); idx != -1 {
-			// Extract Dockerfile content
-			content = content[idx+len("-- Dockerfile --"):]
-			if end := strings.Index(content, "\n--"); end != -1 {
-				dockerfileContent = strings.TrimSpace(content[:end])
-			} else {
-				dockerfileContent = strings.TrimSpace(content)
-			}
-			break
+		dst := filepath.Join(dir, workPath(file))
+		if err := os.Symlink(linkTarget(abs), dst); err != nil {
+			return "", nil, fmt.Errorf("failed to link test file %s: %v", file, err)
 		}
 	}
 
-	// Create docker-bake.hcl
-	dockerBakeFile := filepath.Join(dir, "docker-bake.hcl")
-	if dockerfileContent != "" {
-		if verbose {
-			log.Printf("using Dockerfile from test file")
-		}
-		bakeContent := fmt.Sprintf(`
-			group "default" {
-				targets = ["scripttest-runner"]
-			}
-
-			target "scripttest-runner" {
-				context = "."
-				dockerfile = "Dockerfile"
-			}
-		`)
-		if err := os.WriteFile(dockerBakeFile, []byte(bakeContent), 0644); err != nil {
-			return fmt.Errorf("failed to write docker-bake.hcl: %v", err)
-		}
-		if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfileContent), 0644); err != nil {
-			return fmt.Errorf("failed to write Dockerfile: %v", err)
-		}
-	} else {
-		// Use default Dockerfile if none found in test files
-		if verbose {
-			log.Printf("using default Dockerfile")
-		}
-		image := dockerImage
-		if image == "" {
-			image = "golang:latest"
-		}
-		content := fmt.Sprintf(`FROM %s
-WORKDIR /app
-COPY . .
-RUN go mod download
-CMD ["go", "test", "-v"]`, image)
-		bakeContent := fmt.Sprintf(`
-			group "default" {
-				targets = ["scripttest-runner"]
-			}
-
-			target "scripttest-runner" {
-				context = "."
-				dockerfile = "Dockerfile"
-			}
-		`)
-		if err := os.WriteFile(dockerBakeFile, []byte(bakeContent), 0644); err != nil {
-			return fmt.Errorf("failed to write docker-bake.hcl: %v", err)
+	// link in .scripttest_info if it exists:
+	scriptTestInfo := ".scripttest_info"
+	if _, err := os.Stat(scriptTestInfo); err == nil {
+		abs, err := filepath.Abs(scriptTestInfo)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get absolute path for .scripttest_info: %v", err)
 		}
-		if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(content), 0644); err != nil {
-			return fmt.Errorf("failed to create Dockerfile: %v", err)
+		dst := filepath.Join(dir, ".scripttest_info")
+		if err := os.Symlink(linkTarget(abs), dst); err != nil {
+			return "", nil, fmt.Errorf("failed to link .scripttest_info: %v", err)
 		}
 	}
 
-	// Build Docker image using buildx bake
-	buildCmd := exec.Command("docker", "buildx", "bake")
-	buildCmd.Dir = dir
-	buildCmd.Stdout = os.Stdout
-	buildCmd.Stderr = os.Stderr
-	if err := buildCmd.Run(); err != nil {
-		return fmt.Errorf("failed to build Docker image: %v", err)
-	}
-
-	// Run tests in container
-	args := []string{"run", "--rm"}
-
-	// Mount the workspace
-	args = append(args, "-v", fmt.Sprintf("%s:/app", dir))
-
-	// Pass through environment variables
-	args = append(args, "-e", "SCRIPTTEST_PATTERN="+pattern)
-	if verbose {
-		args = append(args, "-e", "VERBOSE=1")
-	}
-	if os.Getenv("UPDATE_SNAPSHOTS") == "1" {
-		args = append(args, "-e", "UPDATE_SNAPSHOTS=1")
-	}
-
-	args = append(args, "scripttest-runner")
-
-	runCmd := exec.Command("docker", args...)
-	runCmd.Stdout = os.Stdout
-	runCmd.Stderr = os.Stderr
-	if err := runCmd.Run(); err != nil {
-		return fmt.Errorf("tests failed in Docker: %v", err)
+	// Initialize go modules
+	if err := initModules(dir); err != nil {
+		return "", nil, fmt.Errorf("failed to initialize modules: %v", err)
 	}
+	return dir, matches, nil
+}
 
-	return nil
+// workPath returns the path of a script in the work directory, relative
+// to it.
+func workPath(file string) string {
+	return filepath.Join("testdata", filepath.Base(file))
 }
 
 func applyScaffold(dir string, resp string) error {
@@ -589,22 +512,45 @@ func initModules(dir string) error {
 }
 
 func runPlayback(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("playback requires snapshot file argument")
+	fs := flag.NewFlagSet("playback", flag.ContinueOnError)
+	timed := fs.Bool("timed", false, "replay output with its recorded timing")
+	speed := fs.Float64("speed", 1, "playback speed multiplier, with -timed")
+	idleLimit := fs.Float64("idle-time-limit", 0, "limit pauses to `seconds`, with -timed")
+	color := fs.Bool("color", false, "color stderr output red")
+	timingFile := fs.String("t", "", "timing `file` of a typescript (default: <typescript>.timing)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("playback requires a snapshot, asciicast or typescript file argument")
+	}
+	rec, err := playback.Load(fs.Arg(0), *timingFile)
+	if err != nil {
+		return err
 	}
-	snapshotPath := args[0]
-
-	// Use scriptreplay for playback
-	cmd := exec.Command("scriptreplay", snapshotPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return playback.Play(rec, os.Stdout, os.Stderr, playback.Options{
+		Timed:         *timed,
+		Speed:         *speed,
+		IdleTimeLimit: *idleLimit,
+		ColorStderr:   *color,
+	})
 }
 
 func runTests(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	goToolchains := fs.String("go", "", "comma-separated Go `toolchains` to run the tests with, such as 1.22,1.23,tip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 	// If pattern provided as argument, override flag
-	if len(args) > 0 {
-		pattern = args[0]
+	if fs.NArg() > 0 {
+		pattern = fs.Arg(0)
+	}
+	if *goToolchains != "" {
+		if useDocker {
+			return fmt.Errorf("-go cannot be used with -docker; set the Go version in the image instead")
+		}
+		return runMatrix(pattern, strings.Split(*goToolchains, ","))
 	}
 	if useDocker {
 		return runTestInDocker(pattern)
@@ -636,26 +582,6 @@ func runHelp(args []string) error {
 	return nil
 }
 
-
-func runRecord(args []string) error {
-	if len(args) < 2 {
-		return fmt.Errorf("record requires test file and output file arguments")
-	}
-	testFile := args[0]
-	outputFile := args[1]
-	
-	return recordAsciicast(testFile, outputFile)
-}
-
-func runPlayCast(args []string) error {
-	if len(args) != 1 {
-		return fmt.Errorf("play-cast requires asciicast file argument")
-	}
-	asciicastFile := args[0]
-	
-	return playAsciicast(asciicastFile)
-}
-
 func runConvertCast(args []string) error {
 	if len(args) != 2 {
 		return fmt.Errorf("convert-cast requires snapshot file and output file arguments")
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// A matrixToolchain is a Go toolchain the scripts are run with by runMatrix.
+type matrixToolchain struct {
+	name   string // such as go1.22.3, tip or local
+	goroot string // "" for the go command on $PATH
+}
+
+// runMatrix runs the scripts matching pattern once with each of the Go
+// toolchains named in toolchains (see parseToolchain), installing them as
+// needed, and prints a grid of the results of each script with each
+// toolchain.
+func runMatrix(pattern string, toolchains []string) error {
+	// Install every toolchain before running anything, so a bad version
+	// fails fast
+	var tcs []matrixToolchain
+	for _, name := range toolchains {
+		tcName, goroot, err := installToolchain(name)
+		if err != nil {
+			return fmt.Errorf("failed to install Go toolchain %s: %v", name, err)
+		}
+		tcs = append(tcs, matrixToolchain{tcName, goroot})
+	}
+
+	dir, matches, err := prepareWorkDir(pattern, func(abs string) string { return abs })
+	if err != nil {
+		return err
+	}
+	// Results are reported by subtest name
+	var scripts []string
+	for _, file := range matches {
+		scripts = append(scripts, subtestName(strings.TrimSuffix(filepath.Base(file), ".txt")))
+	}
+
+	var failed []string
+	results := make([]map[string]string, len(tcs))
+	for i, tc := range tcs {
+		if verbose {
+			log.Printf("running tests with %s", tc.name)
+		}
+		var stderr bytes.Buffer
+		cmd := exec.Command("go", "test", "-json")
+		cmd.Dir = dir
+		cmd.Env = toolchainEnv(os.Environ(), tc.goroot)
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to run tests with %s: %v", tc.name, err)
+		}
+		var output io.Writer = io.Discard
+		if verbose {
+			output = os.Stdout
+		}
+		res, failures := matrixResults(stdout, output)
+		runErr := cmd.Wait()
+		results[i] = res
+
+		// Show why the tests failed, including failures to build them
+		if runErr != nil || len(failures) > 0 {
+			failed = append(failed, tc.name)
+			fmt.Printf("--- %s\n%s%s", tc.name, failures, stderr.String())
+		}
+	}
+
+	printMatrix(os.Stdout, scripts, tcs, results)
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed with %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// toolchainEnv returns env set up to run the go command of the toolchain
+// in goroot, and only that one, or env itself if goroot is "".
+func toolchainEnv(env []string, goroot string) []string {
+	if goroot == "" {
+		return env
+	}
+	path := filepath.Join(goroot, "bin")
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, "PATH="); ok && v != "" {
+			path += string(os.PathListSeparator) + v
+		}
+	}
+	// Later entries win
+	return append(env, "PATH="+path, "GOROOT="+goroot, "GOTOOLCHAIN=local")
+}
+
+// subtestName returns the name go test reports for a subtest run with
+// t.Run(name): spaces become underscores and unprintable characters are
+// escaped.
+func subtestName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte('_')
+		case !strconv.IsPrint(r):
+			q := strconv.QuoteRune(r)
+			b.WriteString(q[1 : len(q)-1])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// A testEvent is an event printed by go test -json.
+type testEvent struct {
+	Action string
+	Test   string
+	Output string
+}
+
+// matrixResults reads the events go test -json prints for the test harness
+// and returns the result of each script that ran, by name: ok, FAIL or
+// skip. The output of the tests is copied to w as it is read. It also
+// returns the output of the failing scripts and of the test binary itself,
+// which explains failures. Lines that are not events, such as build
+// errors, are part of that output too.
+func matrixResults(r io.Reader, w io.Writer) (map[string]string, string) {
+	results := make(map[string]string)
+	type output struct{ script, text string }
+	var outputs []output
+	// Lines are read whatever their length: stopping early would leave go
+	// test blocked writing the rest
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			line = fmt.Appendf(nil, "failed to read test output: %v", err)
+		}
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 && err != nil {
+			break
+		}
+		var e testEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			e = testEvent{Action: "output", Output: string(line) + "\n"}
+		}
+		// The harness runs each script as a subtest of Test
+		var script string
+		if rest, ok := strings.CutPrefix(e.Test, "Test/"); ok {
+			script, _, _ = strings.Cut(rest, "/")
+		}
+		switch e.Action {
+		case "output":
+			io.WriteString(w, e.Output)
+			outputs = append(outputs, output{script, e.Output})
+		case "pass", "fail", "skip":
+			if script != "" && e.Test == "Test/"+script {
+				results[script] = map[string]string{"pass": "ok", "fail": "FAIL", "skip": "skip"}[e.Action]
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	io.Copy(io.Discard, r)
+
+	// The output of the test binary itself only matters if something failed
+	failed := len(results) == 0 || anyFailed(results)
+	var failures strings.Builder
+	for _, o := range outputs {
+		if o.script == "" && failed || o.script != "" && results[o.script] == "FAIL" {
+			failures.WriteString(o.text)
+		}
+	}
+	return results, failures.String()
+}
+
+// anyFailed reports whether any script in results failed.
+func anyFailed(results map[string]string) bool {
+	for _, result := range results {
+		if result == "FAIL" {
+			return true
+		}
+	}
+	return false
+}
+
+// printMatrix prints the results of scripts with each toolchain as a grid
+// with a row per script and a column per toolchain. Scripts without a
+// result, because the tests failed to build or run, are shown as error.
+func printMatrix(w io.Writer, scripts []string, tcs []matrixToolchain, results []map[string]string) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprint(tw, "SCRIPT")
+	for _, tc := range tcs {
+		fmt.Fprintf(tw, "\t%s", tc.name)
+	}
+	fmt.Fprintln(tw)
+	for _, script := range scripts {
+		fmt.Fprint(tw, script)
+		for i := range tcs {
+			result := results[i][script]
+			if result == "" {
+				result = "error"
+			}
+			fmt.Fprintf(tw, "\t%s", result)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
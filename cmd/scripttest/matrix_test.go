@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatrixResults(t *testing.T) {
+	events := `{"Action":"start","Package":"scripttest"}
+{"Action":"run","Package":"scripttest","Test":"Test"}
+{"Action":"output","Package":"scripttest","Test":"Test","Output":"=== RUN   Test\n"}
+{"Action":"run","Package":"scripttest","Test":"Test/hello"}
+{"Action":"output","Package":"scripttest","Test":"Test/hello","Output":"=== RUN   Test/hello\n"}
+{"Action":"pass","Package":"scripttest","Test":"Test/hello","Elapsed":0.1}
+{"Action":"run","Package":"scripttest","Test":"Test/broken"}
+{"Action":"output","Package":"scripttest","Test":"Test/broken","Output":"    broken.txt:3: FAIL: unexpected stdout\n"}
+{"Action":"run","Package":"scripttest","Test":"Test/broken/nested"}
+{"Action":"pass","Package":"scripttest","Test":"Test/broken/nested"}
+{"Action":"fail","Package":"scripttest","Test":"Test/broken","Elapsed":0.2}
+{"Action":"run","Package":"scripttest","Test":"Test/later"}
+{"Action":"skip","Package":"scripttest","Test":"Test/later","Elapsed":0}
+{"Action":"fail","Package":"scripttest","Test":"Test","Elapsed":0.3}
+{"Action":"output","Package":"scripttest","Output":"FAIL\n"}
+{"Action":"fail","Package":"scripttest","Elapsed":0.4}
+`
+	var copied strings.Builder
+	results, failures := matrixResults(strings.NewReader(events), &copied)
+	want := map[string]string{"hello": "ok", "broken": "FAIL", "later": "skip"}
+	if len(results) != len(want) {
+		t.Errorf("results = %v, want %v", results, want)
+	}
+	for script, result := range want {
+		if results[script] != result {
+			t.Errorf("result of %s = %q, want %q", script, results[script], result)
+		}
+	}
+	if want := "=== RUN   Test\n    broken.txt:3: FAIL: unexpected stdout\nFAIL\n"; failures != want {
+		t.Errorf("failures = %q, want %q", failures, want)
+	}
+	if !strings.Contains(copied.String(), "=== RUN   Test/hello\n") {
+		t.Errorf("output not copied: %q", copied.String())
+	}
+
+	// A run that passes explains nothing
+	passing := `{"Action":"pass","Package":"scripttest","Test":"Test/hello"}
+{"Action":"output","Package":"scripttest","Output":"ok  \tscripttest\t0.1s\n"}
+`
+	if _, failures := matrixResults(strings.NewReader(passing), &copied); failures != "" {
+		t.Errorf("failures of a passing run = %q, want none", failures)
+	}
+
+	// Build failures are printed as text
+	build := "# scripttest\n./test_main.go:3:2: undefined: x\n"
+	results, failures = matrixResults(strings.NewReader(build), &copied)
+	if len(results) != 0 || failures != build {
+		t.Errorf("build failure: results = %v, failures = %q", results, failures)
+	}
+
+	// Events after very long lines are still read, and the writer is never
+	// left blocked
+	long := strings.Repeat("x", 2<<20)
+	r, w := io.Pipe()
+	go func() {
+		fmt.Fprintf(w, "{\"Action\":\"output\",\"Test\":\"Test/big\",\"Output\":\"%s\\n\"}\n", long)
+		fmt.Fprintf(w, "%s\n{\"Action\":\"pass\",\"Test\":\"Test/big\"}\n", long)
+		w.Close()
+	}()
+	results, _ = matrixResults(r, io.Discard)
+	if results["big"] != "ok" {
+		t.Errorf("results after long lines = %v, want big to pass", results)
+	}
+}
+
+func TestSubtestName(t *testing.T) {
+	for name, want := range map[string]string{
+		"hello":              "hello",
+		"with space":         "with_space",
+		"tab\tand\u00a0nbsp": "tab_and_nbsp",
+		"bell\a":             `bell\a`,
+		"héllo":              "héllo",
+	} {
+		if got := subtestName(name); got != want {
+			t.Errorf("subtestName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestPrintMatrix(t *testing.T) {
+	var out strings.Builder
+	printMatrix(&out, []string{"hello", "a_longer_name"},
+		[]matrixToolchain{{name: "go1.22.10"}, {name: "go1.23.4"}, {name: "tip"}},
+		[]map[string]string{
+			{"hello": "ok", "a_longer_name": "ok"},
+			{"hello": "ok", "a_longer_name": "FAIL"},
+			{},
+		})
+	want := `SCRIPT         go1.22.10  go1.23.4  tip
+hello          ok         ok        error
+a_longer_name  ok         FAIL      error
+`
+	if out.String() != want {
+		t.Errorf("printMatrix printed:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestToolchainEnv(t *testing.T) {
+	env := []string{"HOME=/home/gopher", "PATH=/usr/bin", "GOROOT=/usr/lib/go"}
+	if got := toolchainEnv(env, ""); len(got) != len(env) {
+		t.Errorf("toolchainEnv for the local toolchain = %v, want %v", got, env)
+	}
+	goroot := filepath.Join("cache", "go1.22.10")
+	got := toolchainEnv(env, goroot)
+	want := map[string]string{
+		"PATH":        filepath.Join(goroot, "bin") + string(os.PathListSeparator) + "/usr/bin",
+		"GOROOT":      goroot,
+		"GOTOOLCHAIN": "local",
+		"HOME":        "/home/gopher",
+	}
+	last := make(map[string]string)
+	for _, kv := range got {
+		k, v, _ := strings.Cut(kv, "=")
+		last[k] = v
+	}
+	for k, v := range want {
+		if last[k] != v {
+			t.Errorf("$%s = %q, want %q", k, last[k], v)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Requests that get and set terminal attributes.
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+// openPTY opens a new pseudo-terminal of the given size, returning its
+// master and slave ends.
+func openPTY(cols, rows int) (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	name := make([]byte, 128)
+	for _, req := range []struct {
+		op  uintptr
+		arg uintptr
+	}{
+		{syscall.TIOCPTYGRANT, 0},
+		{syscall.TIOCPTYUNLK, 0},
+		{syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))},
+	} {
+		if err := ioctl(master, req.op, req.arg); err != nil {
+			master.Close()
+			return nil, nil, err
+		}
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	slave, err = os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := setWinsize(slave, cols, rows); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
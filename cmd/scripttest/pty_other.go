@@ -0,0 +1,22 @@
+//go:build !darwin && !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// openPTY reports that pseudo-terminals are not supported on this system.
+func openPTY(cols, rows int) (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
+
+func ptyAttr() *syscall.SysProcAttr { return nil }
+
+// makeRaw reports that terminals cannot be put in raw mode on this system.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
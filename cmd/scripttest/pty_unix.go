@@ -0,0 +1,47 @@
+//go:build darwin || linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ptyAttr returns the process attributes that make the pseudo-terminal
+// attached to a command's stdout its controlling terminal.
+func ptyAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+}
+
+// setWinsize sets the size of the terminal f.
+func setWinsize(f *os.File, cols, rows int) error {
+	ws := struct{ rows, cols, x, y uint16 }{uint16(rows), uint16(cols), 0, 0}
+	return ioctl(f, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+func ioctl(f *os.File, op, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), op, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts the terminal f in raw mode, so that key presses are read
+// immediately and not echoed, and returns a function that restores its
+// previous mode. It fails if f is not a terminal.
+func makeRaw(f *os.File) (restore func(), err error) {
+	var old syscall.Termios
+	if err := ioctl(f, ioctlGetTermios, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f, ioctlSetTermios, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(f, ioctlSetTermios, uintptr(unsafe.Pointer(&old))) }, nil
+}
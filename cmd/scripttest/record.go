@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/scripttestutil/asciicast"
+	"github.com/tmc/scripttestutil/internal/scriptexec"
+	"github.com/tmc/scripttestutil/snapshot"
+	"golang.org/x/tools/txtar"
+	"rsc.io/script"
+	"rsc.io/script/scripttest"
+)
+
+// runRecord implements the record command.
+func runRecord(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	usePTY := fs.Bool("pty", false, "attach a pseudo-terminal to the stdout of exec commands")
+	cols := fs.Int("cols", 80, "terminal width")
+	rows := fs.Int("rows", 24, "terminal height")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("record requires test file and output file arguments")
+	}
+	r := &recorder{pty: *usePTY, cols: *cols, rows: *rows}
+	return r.record(fs.Arg(0), fs.Arg(1))
+}
+
+// A recorder runs a script through the script engine, writing the echo of
+// each command and its output to an asciicast as they happen.
+type recorder struct {
+	pty        bool // attach a pseudo-terminal to exec commands
+	cols, rows int  // terminal size
+
+	cast *castWriter
+}
+
+// record runs testFile and writes its recording to outputFile. The
+// recording is written even if the script fails.
+func (r *recorder) record(testFile, outputFile string) error {
+	env := map[string]string{"SHELL": os.Getenv("SHELL"), "TERM": os.Getenv("TERM")}
+	if env["TERM"] == "" && r.pty {
+		env["TERM"] = "xterm-256color"
+	}
+	for k, v := range env {
+		if v == "" {
+			delete(env, k)
+		}
+	}
+
+	// Set up the script the same way the generated test harness does
+	work, err := os.MkdirTemp("", "scripttest-record-*")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %v", err)
+	}
+	defer os.RemoveAll(work)
+	scriptEnv := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+	}
+	if gopath := os.Getenv("GOPATH"); gopath != "" {
+		scriptEnv = append(scriptEnv, "GOPATH="+gopath)
+	}
+	if term := env["TERM"]; term != "" {
+		scriptEnv = append(scriptEnv, "TERM="+term)
+	}
+	s, a, err := snapshot.NewState(context.Background(), work, testFile, scriptEnv)
+	if err != nil {
+		return fmt.Errorf("failed to set up test: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %v", err)
+	}
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %v", err)
+	}
+	defer out.Close()
+	r.cast, err = newCastWriter(out, asciicast.Header{
+		Version: asciicast.Version,
+		Width:   r.cols,
+		Height:  r.rows,
+		Title:   filepath.Base(testFile),
+		Env:     env,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write recording: %v", err)
+	}
+
+	if verbose {
+		fmt.Printf("Recording %s to %s\n", testFile, outputFile)
+	}
+	runErr := r.run(s, testFile, a)
+	if err := r.cast.close(); err != nil {
+		return fmt.Errorf("failed to write recording: %v", err)
+	}
+	if runErr != nil {
+		return fmt.Errorf("recorded script failed: %v", runErr)
+	}
+	if verbose {
+		fmt.Printf("Recording saved to %s\n", outputFile)
+	}
+	return nil
+}
+
+// run runs the script a, read from file, in the state s.
+func (r *recorder) run(s *script.State, file string, a *txtar.Archive) error {
+	log := io.Discard
+	if verbose {
+		log = os.Stderr
+	}
+	engine := &script.Engine{Cmds: r.cmds(), Conds: recordConds(), Quiet: !verbose}
+	err := engine.Execute(s, file, bufio.NewReader(bytes.NewReader(a.Comment)), log)
+	if closeErr := s.CloseAndWait(log); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// cmds returns the commands of the generated test harness, each wrapped to
+// record its invocation and output. exec is replaced by a command that
+// records its output as it is written.
+func (r *recorder) cmds() map[string]script.Cmd {
+	cmds := scripttest.DefaultCmds()
+	for name, cmd := range snapshot.Commands(snapshot.Config{
+		Update: os.Getenv("UPDATE_SNAPSHOTS") == "1",
+		Format: snapshotFormat,
+	}) {
+		cmds[name] = cmd
+	}
+	recorded := make(map[string]script.Cmd, len(cmds))
+	for name, cmd := range cmds {
+		rc := &recordedCmd{name: name, cmd: cmd, cast: r.cast}
+		if name == "exec" {
+			rc.cmd, rc.streamed = r.execCmd(), true
+		}
+		recorded[name] = rc
+	}
+	return recorded
+}
+
+// recordConds returns the conditions of the generated test harness.
+// scripttest.DefaultConds is not used because its short and verbose
+// conditions require the testing package.
+func recordConds() map[string]script.Cond {
+	conds := script.DefaultConds()
+	conds["exec"] = scripttest.CachedExec()
+	for _, goos := range []string{"darwin", "linux", "windows"} {
+		goos := goos
+		conds[goos] = script.OnceCondition(goos+" system", func() (bool, error) {
+			return runtime.GOOS == goos, nil
+		})
+	}
+	conds["unix"] = script.OnceCondition("unix system", func() (bool, error) {
+		return runtime.GOOS != "windows", nil
+	})
+	return conds
+}
+
+// A recordedCmd records the invocation of a command and its output.
+type recordedCmd struct {
+	name     string
+	cmd      script.Cmd
+	cast     *castWriter
+	streamed bool // cmd records its own output
+}
+
+func (c *recordedCmd) Usage() *script.CmdUsage { return c.cmd.Usage() }
+
+func (c *recordedCmd) Run(s *script.State, args ...string) (script.WaitFunc, error) {
+	c.cast.output("$ "+quoteCommand(c.name, args)+"\n", false)
+	wait, err := c.cmd.Run(s, args...)
+	if err != nil {
+		c.cast.output(err.Error()+"\n", true)
+		return nil, err
+	}
+	if wait == nil {
+		return nil, nil
+	}
+	return func(s *script.State) (string, string, error) {
+		stdout, stderr, err := wait(s)
+		if !c.streamed {
+			c.cast.output(stdout, false)
+			c.cast.output(stderr, true)
+		}
+		if err != nil {
+			c.cast.output(err.Error()+"\n", true)
+		}
+		return stdout, stderr, err
+	}, nil
+}
+
+// quoteCommand formats a command line the way it would be written in a
+// script, quoting arguments that contain spaces or quotes.
+func quoteCommand(name string, args []string) string {
+	words := []string{name}
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t'\"#$") {
+			arg = quoteArg(arg)
+		}
+		words = append(words, arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// quoteArg quotes s as a single script argument.
+func quoteArg(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// execCmd returns an exec command that behaves like script.Exec but also
+// writes the program's output to the recording as it is produced.
+func (r *recorder) execCmd() script.Cmd {
+	return script.Command(scriptexec.Usage(), func(s *script.State, args ...string) (script.WaitFunc, error) {
+		cmd, err := scriptexec.Command(s, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		var stdout, stderr strings.Builder
+		castStderr := r.cast.stream(true, false)
+		cmd.Stderr = io.MultiWriter(&stderr, castStderr)
+		if !r.pty {
+			castStdout := r.cast.stream(false, false)
+			cmd.Stdout = io.MultiWriter(&stdout, castStdout)
+			if err := cmd.Start(); err != nil {
+				return nil, err
+			}
+			return func(*script.State) (string, string, error) {
+				err := cmd.Wait()
+				castStdout.Flush()
+				castStderr.Flush()
+				return stdout.String(), stderr.String(), err
+			}, nil
+		}
+
+		// The terminal translates newlines to CRLF, which is what the
+		// recording should show but not what the script should see.
+		master, slave, err := openPTY(r.cols, r.rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open pseudo-terminal: %v", err)
+		}
+		cmd.Stdout = slave
+		cmd.SysProcAttr = ptyAttr()
+		err = cmd.Start()
+		slave.Close()
+		if err != nil {
+			master.Close()
+			return nil, err
+		}
+		castStdout := r.cast.stream(false, true)
+		done := make(chan struct{})
+		go func() {
+			// Reading fails with EIO once the program closes the terminal
+			io.Copy(io.MultiWriter(&stdout, castStdout), master)
+			close(done)
+		}()
+		return func(*script.State) (string, string, error) {
+			err := cmd.Wait()
+			<-done
+			master.Close()
+			castStdout.Flush()
+			castStderr.Flush()
+			return strings.ReplaceAll(stdout.String(), "\r\n", "\n"), stderr.String(), err
+		}, nil
+	})
+}
+
+// A castWriter writes asciicast output events, timed from its creation.
+// It is safe for concurrent use.
+type castWriter struct {
+	mu    sync.Mutex
+	buf   *bufio.Writer
+	w     *asciicast.Writer
+	start time.Time
+	err   error
+}
+
+// newCastWriter writes the header h, with the current time, to w and
+// returns a castWriter for the recording's events.
+func newCastWriter(w io.Writer, h asciicast.Header) (*castWriter, error) {
+	c := &castWriter{buf: bufio.NewWriter(w), start: time.Now()}
+	h.Timestamp = c.start.Unix()
+	var err error
+	if c.w, err = asciicast.NewWriter(c.buf, h); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// output writes text as an output event.
+func (c *castWriter) output(text string, stderr bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.emit(text, stderr, false)
+}
+
+// stream returns a writer that records each write as an output event,
+// holding back partial UTF-8 sequences until they are complete. Writes
+// from a terminal (raw) are recorded verbatim. The writer must be flushed
+// once the program is done writing.
+func (c *castWriter) stream(stderr, raw bool) *scriptexec.TextWriter {
+	return scriptexec.NewTextWriter(func(text string) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.emit(text, stderr, raw)
+	})
+}
+
+// emit writes text as an output event. Unless the text comes from a
+// terminal (raw), newlines are written as CRLF, as a terminal would
+// display them. stderr is colored red. c.mu must be held.
+func (c *castWriter) emit(text string, stderr, raw bool) {
+	if text == "" {
+		return
+	}
+	if !raw {
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	}
+	if stderr {
+		text = "\033[31m" + text + "\033[0m"
+	}
+	c.event(text)
+}
+
+// event writes an output event with the current time. c.mu must be held.
+func (c *castWriter) event(data string) {
+	if c.err == nil {
+		c.err = c.w.WriteEvent(asciicast.Event{Time: time.Since(c.start).Seconds(), Type: asciicast.Output, Data: data})
+	}
+}
+
+// close flushes the recording and returns the first error writing it.
+func (c *castWriter) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	return c.buf.Flush()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/scripttestutil/asciicast"
+)
+
+// outputs returns the data of the cast's events, checking that they are
+// output events in time order.
+func outputs(t *testing.T, cast *asciicast.Cast) []string {
+	t.Helper()
+	var data []string
+	for i, e := range cast.Events {
+		if e.Type != asciicast.Output {
+			t.Errorf("event %d has type %q, want output", i, e.Type)
+		}
+		if i > 0 && e.Time < cast.Events[i-1].Time {
+			t.Errorf("event %d at %vs is before the previous one at %vs", i, e.Time, cast.Events[i-1].Time)
+		}
+		data = append(data, e.Data)
+	}
+	return data
+}
+
+func TestCastWriter(t *testing.T) {
+	var buf strings.Builder
+	before := time.Now().Unix()
+	c, err := newCastWriter(&buf, asciicast.Header{Version: asciicast.Version, Width: 80, Height: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.output("$ echo hi\n", false)
+	c.output("", false)
+	c.output("failed\r\n", true)
+	stdout := c.stream(false, false)
+	// "é" is 0xc3 0xa9
+	for _, p := range []string{"caf\xc3", "\xa9\nnext", "\n"} {
+		stdout.Write([]byte(p))
+	}
+	stdout.Flush()
+	term := c.stream(false, true)
+	term.Write([]byte("a\r\nb\n"))
+	term.Flush()
+	if err := c.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cast, err := asciicast.Read(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("reading recording: %v\n%s", err, buf.String())
+	}
+	if cast.Header.Timestamp < before {
+		t.Errorf("header timestamp %d is before the recording started", cast.Header.Timestamp)
+	}
+	want := []string{
+		"$ echo hi\r\n",
+		"\033[31mfailed\r\n\033[0m",
+		"caf",
+		"é\r\nnext",
+		"\r\n",
+		"a\r\nb\n",
+	}
+	if got := outputs(t, cast); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	script := writeScript(t, dir, "record.txt", `echo hello
+exec sh -c 'printf first; sleep 0.2; printf second >&2; printf "\303"; sleep 0.1; printf "\251\n"'
+! exec false
+`)
+	file := filepath.Join(dir, "out", "record.cast")
+	r := &recorder{cols: 100, rows: 30}
+	if err := r.record(script, file); err != nil {
+		t.Fatal(err)
+	}
+
+	cast, err := asciicast.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := cast.Header; h.Width != 100 || h.Height != 30 || h.Title != "record.txt" {
+		t.Errorf("header = %+v, want a 100x30 terminal titled record.txt", h)
+	}
+	want := []string{
+		"$ echo hello\r\n",
+		"hello\r\n",
+		`$ exec sh -c 'printf first; sleep 0.2; printf second >&2; printf "\303"; sleep 0.1; printf "\251\n"'` + "\r\n",
+		"first",
+		"\033[31msecond\033[0m",
+		"é\r\n",
+		"$ exec false\r\n",
+		"\033[31mexit status 1\r\n\033[0m",
+	}
+	if got := outputs(t, cast); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	// Output is recorded as it is written, not when the command is done,
+	// allowing for the first write to be recorded late
+	if gap := cast.Events[4].Time - cast.Events[3].Time; gap < 0.1 {
+		t.Errorf("output recorded %vs apart, want about the 0.2s the program slept", gap)
+	}
+
+	// A failing script is recorded up to the failure
+	script = writeScript(t, dir, "fail.txt", "echo before\nexec false\necho after\n")
+	if err := r.record(script, file); err == nil || !strings.Contains(err.Error(), "recorded script failed") {
+		t.Fatalf("recording failing script: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "before") || strings.Contains(string(data), "after") {
+		t.Errorf("recording of failing script:\n%s", data)
+	}
+}
+
+func TestRecordState(t *testing.T) {
+	// The script runs as in the test harness: in $WORK, with its own
+	// temporary directory and its files, but not its inline snapshots
+	dir := t.TempDir()
+	script := writeScript(t, dir, "state.txt", `cat data.txt
+! exists __snapshot__/greeting
+[!windows] env TMPDIR
+[!windows] stdout '^TMPDIR='$WORK'/tmp$'
+exists $WORK/tmp
+-- data.txt --
+hello
+-- __snapshot__/greeting --
+hello
+`)
+	file := filepath.Join(dir, "state.cast")
+	if err := (&recorder{cols: 80, rows: 24}).record(script, file); err != nil {
+		t.Fatal(err)
+	}
+	cast, err := asciicast.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := outputs(t, cast); len(got) < 2 || got[1] != "hello\r\n" {
+		t.Errorf("events = %q, want the contents of data.txt", got)
+	}
+
+	if err := (&recorder{}).record(filepath.Join(dir, "missing.txt"), file); err == nil || !strings.Contains(err.Error(), "failed to set up test") {
+		t.Errorf("recording a missing script: %v", err)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmc/scripttestutil/asciicast"
+	"github.com/tmc/scripttestutil/snapshot"
+	"github.com/tmc/scripttestutil/vt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// frameInterval is the shortest time a frame of an animation is shown.
+	// Output written sooner after a frame is merged into the next one.
+	frameInterval = 50 * time.Millisecond
+
+	// endDelay is how long the last frame is shown before an animation
+	// starts over.
+	endDelay = 3 * time.Second
+)
+
+// runRender implements the render command.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	output := fs.String("o", "", "write the image to `file`, a .svg or .png file (default: the input name with .svg)")
+	still := fs.Bool("still", false, "render a single frame instead of an animation")
+	at := fs.Float64("at", -1, "render the frame shown at `seconds` into playback with -still (default: the end)")
+	speed := fs.Float64("speed", 1, "playback speed")
+	idleLimit := fs.Float64("idle-time-limit", 0, "limit pauses to `seconds` (default: the recording's limit, or 2)")
+	fontSize := fs.Float64("font-size", 14, "font size in pixels")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("render requires a .cast or snapshot file argument")
+	}
+	if *speed <= 0 || *fontSize <= 0 {
+		return fmt.Errorf("-speed and -font-size must be positive")
+	}
+	input := fs.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(input, filepath.Ext(input)) + ".svg"
+	}
+	ext := filepath.Ext(*output)
+	if ext != ".svg" && ext != ".png" {
+		return fmt.Errorf("unsupported image format %q; use .svg or .png", ext)
+	}
+
+	cast, err := readRenderInput(input)
+	if err != nil {
+		return err
+	}
+	if *idleLimit == 0 {
+		*idleLimit = cast.Header.IdleTimeLimit
+		if *idleLimit == 0 {
+			*idleLimit = 2
+		}
+	}
+	fonts, err := loadFonts(*fontSize)
+	if err != nil {
+		return err
+	}
+	r := &renderer{
+		fonts: fonts,
+		theme: castTheme(cast.Header.Theme),
+		cols:  cast.Header.Width,
+		rows:  cast.Header.Height,
+	}
+	frames := castFrames(cast, *speed, *idleLimit)
+	if *still || ext == ".png" {
+		// A PNG image cannot be animated
+		frames = []frame{stillFrame(frames, *at)}
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create image: %v", err)
+	}
+	w := bufio.NewWriter(f)
+	if ext == ".png" {
+		err = png.Encode(w, r.image(frames[0]))
+	} else {
+		r.writeSVG(w, frames)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write image: %v", err)
+	}
+	if verbose {
+		fmt.Printf("Rendered %d frames to %s\n", len(frames), *output)
+	}
+	return nil
+}
+
+// readRenderInput reads an asciicast file, or a snapshot file of either
+// format as a recording of its output.
+func readRenderInput(file string) (*asciicast.Cast, error) {
+	if filepath.Ext(file) == ".cast" {
+		return asciicast.ReadFile(file)
+	}
+	snap, err := snapshot.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot file: %v", err)
+	}
+	return snapshotCast(snap, filepath.Base(file)), nil
+}
+
+// A frame is the screen as it is shown at a time during playback. The
+// cell under a visible cursor is inverted.
+type frame struct {
+	at    time.Duration
+	cells [][]vt.Cell
+}
+
+// castFrames plays cast on an emulated terminal and returns the distinct
+// screens it shows, starting with the blank screen.
+func castFrames(cast *asciicast.Cast, speed, idleLimit float64) []frame {
+	cols, rows := cast.Header.Width, cast.Header.Height
+	screen := vt.NewScreen(cols, rows)
+	frames := []frame{screenFrame(screen, cols, rows, 0)}
+	timeline := cast.Timeline(speed, idleLimit)
+	for i, e := range cast.Events {
+		switch e.Type {
+		case asciicast.Output:
+			screen.WriteString(e.Data)
+		case asciicast.Resize:
+			var w, h int
+			if _, err := fmt.Sscanf(e.Data, "%dx%d", &w, &h); err == nil {
+				screen.Resize(w, h)
+			}
+		default:
+			continue
+		}
+		if i+1 < len(timeline) && timeline[i+1]-timeline[i] < frameInterval {
+			continue
+		}
+		frames = addFrame(frames, screenFrame(screen, cols, rows, timeline[i]))
+	}
+	if n := len(timeline); n > 0 {
+		// Output followed closely by other events is not shown yet
+		frames = addFrame(frames, screenFrame(screen, cols, rows, timeline[n-1]))
+	}
+	return frames
+}
+
+// addFrame appends f to frames unless it shows the same screen as the last
+// frame. A frame that follows the last one too soon replaces it instead.
+func addFrame(frames []frame, f frame) []frame {
+	last := &frames[len(frames)-1]
+	switch {
+	case sameCells(f.cells, last.cells):
+	case f.at-last.at < frameInterval && len(frames) > 1:
+		last.cells = f.cells
+	default:
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// screenFrame returns the top left cols x rows cells of screen as a frame.
+func screenFrame(screen *vt.Screen, cols, rows int, at time.Duration) frame {
+	w, h := screen.Size()
+	cells := make([][]vt.Cell, rows)
+	for y := range cells {
+		cells[y] = make([]vt.Cell, cols)
+		for x := 0; x < cols && y < h && x < w; x++ {
+			cells[y][x] = screen.Cell(x, y)
+		}
+	}
+	if x, y, visible := screen.Cursor(); visible && x < cols && y < rows {
+		cells[y][x].Attr ^= vt.Inverse
+	}
+	return frame{at: at, cells: cells}
+}
+
+func sameCells(a, b [][]vt.Cell) bool {
+	for y := range a {
+		for x := range a[y] {
+			if a[y][x] != b[y][x] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// stillFrame returns the frame shown at seconds into playback, or the last
+// frame if seconds is negative.
+func stillFrame(frames []frame, seconds float64) frame {
+	if seconds < 0 {
+		return frames[len(frames)-1]
+	}
+	at := time.Duration(seconds * float64(time.Second))
+	f := frames[0]
+	for _, next := range frames[1:] {
+		if next.at > at {
+			break
+		}
+		f = next
+	}
+	return f
+}
+
+// A fontSet holds Go Mono in its four styles and the size of the cells
+// of a terminal using it.
+type fontSet struct {
+	size   float64
+	faces  [4]font.Face // regular, bold, italic and bold italic
+	cellW  float64
+	cellH  int
+	ascent int
+}
+
+func loadFonts(size float64) (*fontSet, error) {
+	fonts := &fontSet{size: size}
+	for i, ttf := range [][]byte{gomono.TTF, gomonobold.TTF, gomonoitalic.TTF, gomonobolditalic.TTF} {
+		f, err := opentype.Parse(ttf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load font: %v", err)
+		}
+		fonts.faces[i], err = opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load font: %v", err)
+		}
+	}
+	advance, _ := fonts.faces[0].GlyphAdvance('M')
+	m := fonts.faces[0].Metrics()
+	fonts.cellW = float64(advance) / 64
+	fonts.cellH = m.Height.Ceil()
+	fonts.ascent = m.Ascent.Ceil()
+	return fonts, nil
+}
+
+// face returns the face for a cell's attributes.
+func (fs *fontSet) face(attr vt.Attr) font.Face {
+	i := 0
+	if attr&vt.Bold != 0 {
+		i |= 1
+	}
+	if attr&vt.Italic != 0 {
+		i |= 2
+	}
+	return fs.faces[i]
+}
+
+// A theme holds the colors of a terminal.
+type theme struct {
+	fg, bg  color.RGBA
+	palette [16]color.RGBA
+}
+
+// defaultTheme is asciinema's default theme.
+var defaultTheme = theme{
+	fg: hexColor(0xcccccc),
+	bg: hexColor(0x121314),
+	palette: [16]color.RGBA{
+		hexColor(0x000000), hexColor(0xdd3c69), hexColor(0x4ebf22), hexColor(0xddaf3c),
+		hexColor(0x26b0d7), hexColor(0xb954e1), hexColor(0x54e1b9), hexColor(0xd9d9d9),
+		hexColor(0x4d4d4d), hexColor(0xdd3c69), hexColor(0x4ebf22), hexColor(0xddaf3c),
+		hexColor(0x26b0d7), hexColor(0xb954e1), hexColor(0x54e1b9), hexColor(0xffffff),
+	},
+}
+
+func hexColor(v uint32) color.RGBA {
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}
+}
+
+func parseHexColor(s string) (color.RGBA, bool) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "#"), 16, 32)
+	if err != nil || len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	return hexColor(uint32(v)), true
+}
+
+// castTheme returns the theme of a recording, falling back to the default
+// theme for colors it does not set. Themes with 8 colors are used for the
+// bright colors too.
+func castTheme(t *asciicast.Theme) theme {
+	th := defaultTheme
+	if t == nil {
+		return th
+	}
+	if c, ok := parseHexColor(t.Fg); ok {
+		th.fg = c
+	}
+	if c, ok := parseHexColor(t.Bg); ok {
+		th.bg = c
+	}
+	palette := strings.Split(t.Palette, ":")
+	if len(palette) != 8 && len(palette) != 16 {
+		return th
+	}
+	for i := range th.palette {
+		if c, ok := parseHexColor(palette[i%len(palette)]); ok {
+			th.palette[i] = c
+		}
+	}
+	return th
+}
+
+// rgb returns the RGB value of c, or def for the default color.
+func (th *theme) rgb(c vt.Color, def color.RGBA) color.RGBA {
+	switch c.Type {
+	case vt.RGBColor:
+		return color.RGBA{c.R, c.G, c.B, 0xff}
+	case vt.IndexedColor:
+		switch i := int(c.Index); {
+		case i < 16:
+			return th.palette[i]
+		case i < 232:
+			// 6x6x6 color cube
+			levels := [6]uint8{0, 95, 135, 175, 215, 255}
+			i -= 16
+			return color.RGBA{levels[i/36], levels[i/6%6], levels[i%6], 0xff}
+		default:
+			v := uint8(8 + 10*(i-232))
+			return color.RGBA{v, v, v, 0xff}
+		}
+	}
+	return def
+}
+
+// colors returns the foreground and background colors of a cell.
+func (th *theme) colors(c vt.Cell) (fg, bg color.RGBA) {
+	fg, bg = th.rgb(c.FG, th.fg), th.rgb(c.BG, th.bg)
+	if c.Attr&vt.Inverse != 0 {
+		fg, bg = bg, fg
+	}
+	if c.Attr&vt.Faint != 0 {
+		fg = color.RGBA{uint8((int(fg.R) + int(bg.R)) / 2), uint8((int(fg.G) + int(bg.G)) / 2), uint8((int(fg.B) + int(bg.B)) / 2), 0xff}
+	}
+	return fg, bg
+}
+
+// A renderer draws frames of a terminal screen.
+type renderer struct {
+	fonts      *fontSet
+	theme      theme
+	cols, rows int
+}
+
+// padding returns the space around the screen, in pixels.
+func (r *renderer) padding() int {
+	return r.fonts.cellH / 2
+}
+
+// size returns the size of the image in pixels.
+func (r *renderer) size() (w, h int) {
+	pad := r.padding()
+	return int(float64(r.cols)*r.fonts.cellW+0.5) + 2*pad, r.rows*r.fonts.cellH + 2*pad
+}
+
+// image draws a frame as an image.
+func (r *renderer) image(f frame) image.Image {
+	w, h := r.size()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(r.theme.bg), image.Point{}, draw.Src)
+	pad, cellH := r.padding(), r.fonts.cellH
+	for y, line := range f.cells {
+		for x, c := range line {
+			fg, bg := r.theme.colors(c)
+			x0 := pad + int(float64(x)*r.fonts.cellW+0.5)
+			x1 := pad + int(float64(x+1)*r.fonts.cellW+0.5)
+			y0 := pad + y*cellH
+			if bg != r.theme.bg {
+				draw.Draw(img, image.Rect(x0, y0, x1, y0+cellH), image.NewUniform(bg), image.Point{}, draw.Src)
+			}
+			if c.Rune != 0 && c.Rune != ' ' {
+				d := font.Drawer{
+					Dst:  img,
+					Src:  image.NewUniform(fg),
+					Face: r.fonts.face(c.Attr),
+					Dot:  fixed.P(x0, y0+r.fonts.ascent),
+				}
+				d.DrawString(string(c.Rune))
+			}
+			if c.Attr&vt.Underline != 0 {
+				uy := y0 + r.fonts.ascent + 2
+				draw.Draw(img, image.Rect(x0, uy, x1, uy+1), image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+			if c.Attr&vt.Strikethrough != 0 {
+				sy := y0 + cellH/2
+				draw.Draw(img, image.Rect(x0, sy, x1, sy+1), image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// writeSVG writes frames as an SVG image. Multiple frames are animated
+// with SMIL, looping after endDelay.
+func (r *renderer) writeSVG(w io.Writer, frames []frame) {
+	width, height := r.size()
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(w, "<style>text{font-family:\"Go Mono\",\"DejaVu Sans Mono\",Menlo,Consolas,monospace;font-size:%gpx;white-space:pre;fill:%s}"+
+		".b{font-weight:bold}.i{font-style:italic}.u{text-decoration:underline}.s{text-decoration:line-through}.us{text-decoration:underline line-through}</style>\n",
+		r.fonts.size, svgColor(r.theme.fg))
+	fmt.Fprintf(w, "<rect width=\"100%%\" height=\"100%%\" rx=\"5\" fill=\"%s\"/>\n", svgColor(r.theme.bg))
+	fmt.Fprintf(w, "<g transform=\"translate(%d %d)\">\n", r.padding(), r.padding())
+	if len(frames) == 1 {
+		r.writeSVGFrame(w, frames[0])
+	} else {
+		// Every frame is shown for its time in each iteration of a loop
+		total := frames[len(frames)-1].at + endDelay
+		fmt.Fprintf(w, "<rect width=\"0\" height=\"0\"><animate id=\"loop\" attributeName=\"x\" values=\"0;0\" dur=\"%.3fs\" begin=\"0s;loop.end\"/></rect>\n", total.Seconds())
+		for i, f := range frames {
+			end := total
+			if i+1 < len(frames) {
+				end = frames[i+1].at
+			}
+			fmt.Fprintf(w, "<g visibility=\"hidden\"><set attributeName=\"visibility\" to=\"visible\" begin=\"loop.begin+%.3fs\" dur=\"%.3fs\"/>\n", f.at.Seconds(), (end - f.at).Seconds())
+			r.writeSVGFrame(w, f)
+			fmt.Fprintf(w, "</g>\n")
+		}
+	}
+	fmt.Fprintf(w, "</g>\n</svg>\n")
+}
+
+// writeSVGFrame writes the backgrounds and text of a frame, a run of
+// cells with the same colors and attributes at a time. Text is stretched
+// to the cell width with textLength, so that columns line up whichever
+// monospace font displays it.
+func (r *renderer) writeSVGFrame(w io.Writer, f frame) {
+	cellW, cellH := r.fonts.cellW, r.fonts.cellH
+	for y, line := range f.cells {
+		for x := 0; x < len(line); {
+			start := x
+			style := line[x]
+			var text []rune
+			for ; x < len(line) && sameStyle(line[x], style); x++ {
+				ch := line[x].Rune
+				if ch == 0 {
+					ch = ' '
+				}
+				text = append(text, ch)
+			}
+			fg, bg := r.theme.colors(style)
+			if bg != r.theme.bg {
+				fmt.Fprintf(w, "<rect x=\"%g\" y=\"%d\" width=\"%g\" height=\"%d\" fill=\"%s\"/>\n",
+					round2(float64(start)*cellW), y*cellH, round2(float64(x-start)*cellW), cellH, svgColor(bg))
+			}
+			s := strings.TrimRight(string(text), " ")
+			if s == "" {
+				continue
+			}
+			fmt.Fprintf(w, "<text x=\"%g\" y=\"%d\" textLength=\"%g\" lengthAdjust=\"spacingAndGlyphs\"",
+				round2(float64(start)*cellW), y*cellH+r.fonts.ascent, round2(float64(len([]rune(s)))*cellW))
+			if fg != r.theme.fg {
+				fmt.Fprintf(w, " fill=\"%s\"", svgColor(fg))
+			}
+			if class := svgClass(style.Attr); class != "" {
+				fmt.Fprintf(w, " class=\"%s\"", class)
+			}
+			fmt.Fprintf(w, ">%s</text>\n", html.EscapeString(s))
+		}
+	}
+}
+
+func sameStyle(a, b vt.Cell) bool {
+	return a.FG == b.FG && a.BG == b.BG && a.Attr == b.Attr
+}
+
+// svgClass returns the style classes for attributes.
+func svgClass(attr vt.Attr) string {
+	var classes []string
+	if attr&vt.Bold != 0 {
+		classes = append(classes, "b")
+	}
+	if attr&vt.Italic != 0 {
+		classes = append(classes, "i")
+	}
+	switch attr & (vt.Underline | vt.Strikethrough) {
+	case vt.Underline:
+		classes = append(classes, "u")
+	case vt.Strikethrough:
+		classes = append(classes, "s")
+	case vt.Underline | vt.Strikethrough:
+		classes = append(classes, "us")
+	}
+	return strings.Join(classes, " ")
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func round2(v float64) float64 {
+	return float64(int64(v*100+0.5)) / 100
+}
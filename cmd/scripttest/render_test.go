@@ -0,0 +1,141 @@
+package main
+
+import (
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmc/scripttestutil/asciicast"
+	"github.com/tmc/scripttestutil/vt"
+)
+
+// renderCast returns a 6x2 recording that hides the cursor and writes "hi"
+// and a red "!" on the first line, then a red bold "ok" on the second line
+// a second later.
+func renderCast() *asciicast.Cast {
+	c := cast(
+		asciicast.Event{Time: 0, Type: asciicast.Output, Data: "\x1b[?25lhi"},
+		asciicast.Event{Time: 0.01, Type: asciicast.Output, Data: "\x1b[31m!"},
+		asciicast.Event{Time: 1, Type: asciicast.Output, Data: "\r\n\x1b[1mok"},
+		asciicast.Event{Time: 1, Type: asciicast.Marker, Data: "done"},
+	)
+	c.Header.Width, c.Header.Height = 6, 2
+	return c
+}
+
+// text returns the lines of a frame.
+func text(f frame) []string {
+	var lines []string
+	for _, line := range f.cells {
+		var b strings.Builder
+		for _, c := range line {
+			if c.Rune == 0 {
+				b.WriteRune(' ')
+			} else {
+				b.WriteRune(c.Rune)
+			}
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	return lines
+}
+
+func TestCastFrames(t *testing.T) {
+	frames := castFrames(renderCast(), 1, 2)
+	want := []struct {
+		at    time.Duration
+		lines []string
+	}{
+		// Output written within frameInterval is shown at once
+		{0, []string{"", ""}},
+		{10 * time.Millisecond, []string{"hi!", ""}},
+		{time.Second, []string{"hi!", "ok"}},
+	}
+	if len(frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(want))
+	}
+	for i, f := range frames {
+		if f.at != want[i].at || strings.Join(text(f), "|") != strings.Join(want[i].lines, "|") {
+			t.Errorf("frame %d at %v shows %q, want %q at %v", i, f.at, text(f), want[i].lines, want[i].at)
+		}
+	}
+	red := vt.Color{Type: vt.IndexedColor, Index: 1}
+	last := frames[len(frames)-1].cells
+	if c := last[0][0]; c.FG != (vt.Color{}) || c.Attr != 0 {
+		t.Errorf("cell h = %+v, want default colors", c)
+	}
+	if c := last[0][2]; c.FG != red {
+		t.Errorf("cell ! = %+v, want red", c)
+	}
+	if c := last[1][0]; c.FG != red || c.Attr != vt.Bold {
+		t.Errorf("cell o = %+v, want red and bold", c)
+	}
+
+	if f := stillFrame(frames, 0.5); f.at != 10*time.Millisecond {
+		t.Errorf("frame shown at 0.5s is the one at %v", f.at)
+	}
+	if f := stillFrame(frames, -1); f.at != time.Second {
+		t.Errorf("last frame is the one at %v", f.at)
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "demo.cast")
+	if err := asciicast.WriteFile(in, renderCast()); err != nil {
+		t.Fatal(err)
+	}
+	// render renders the recording to out and returns the image
+	render := func(out string, args ...string) string {
+		t.Helper()
+		if err := runRender(append(args, "-o", out, in)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	svg := render(filepath.Join(dir, "demo.svg"))
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg"`,
+		`lengthAdjust="spacingAndGlyphs">hi</text>`,
+		`fill="#dd3c69">!</text>`,
+		`fill="#dd3c69" class="b">ok</text>`,
+		`begin="loop.begin+1.000s" dur="3.000s"`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("animation does not contain %q:\n%s", want, svg)
+		}
+	}
+	if n := strings.Count(svg, `<set attributeName="visibility"`); n != 3 {
+		t.Errorf("animation has %d frames, want 3:\n%s", n, svg)
+	}
+
+	svg = render(filepath.Join(dir, "still.svg"), "-still", "-at", "0.5")
+	if strings.Contains(svg, "<animate") || !strings.Contains(svg, ">hi</text>") || strings.Contains(svg, ">ok</text>") {
+		t.Errorf("still image at 0.5s:\n%s", svg)
+	}
+
+	img, err := png.Decode(strings.NewReader(render(filepath.Join(dir, "demo.png"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fonts, err := loadFonts(14)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &renderer{fonts: fonts, cols: 6, rows: 2}
+	if w, h := r.size(); img.Bounds().Dx() != w || img.Bounds().Dy() != h {
+		t.Errorf("image is %v, want %dx%d", img.Bounds().Size(), w, h)
+	}
+	if c := color.RGBAModel.Convert(img.At(0, 0)); c != defaultTheme.bg {
+		t.Errorf("corner pixel is %v, want the background %v", c, defaultTheme.bg)
+	}
+}
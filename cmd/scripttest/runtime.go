@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// runtimes lists the supported container runtimes, in the order they are
+// tried when -runtime is not set.
+var runtimes = []string{"docker", "podman", "nerdctl"}
+
+// A containerRuntime builds images and runs containers.
+type containerRuntime interface {
+	// name returns the runtime's name, such as "docker".
+	name() string
+
+	// build builds images from their Dockerfiles in dir, which is the
+	// build context.
+	build(dir string, images []*containerImage) error
+
+	// run runs a container and waits for it to exit.
+	run(c containerRun) error
+}
+
+// A containerRun describes a container to run.
+type containerRun struct {
+	image    string
+	platform string // platform to run the image for, or "" for the default
+	workdir  string
+	mounts   []string // host:container directory pairs
+	env      []string // NAME=value pairs
+	command  []string // command to run instead of the image's, if any
+
+	// stdout receives the container's standard output, which is passed
+	// through if it is nil.
+	stdout io.Writer
+}
+
+// A commandRunner runs external commands. Tests replace it with a fake.
+type commandRunner interface {
+	// lookPath reports whether the program is installed.
+	lookPath(program string) bool
+
+	// check reports whether a command succeeds, discarding its output.
+	check(program string, args ...string) bool
+
+	// run runs a command in dir with its standard output written to
+	// stdout, or passed through if stdout is nil, and its standard error
+	// passed through.
+	run(dir string, stdout io.Writer, program string, args ...string) error
+}
+
+// execRunner runs commands with os/exec.
+type execRunner struct {
+	stdout, stderr io.Writer
+}
+
+func (execRunner) lookPath(program string) bool {
+	_, err := exec.LookPath(program)
+	return err == nil
+}
+
+func (execRunner) check(program string, args ...string) bool {
+	return exec.Command(program, args...).Run() == nil
+}
+
+func (r execRunner) run(dir string, stdout io.Writer, program string, args ...string) error {
+	cmd := exec.Command(program, args...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	if stdout == nil {
+		cmd.Stdout = r.stdout
+	}
+	cmd.Stderr = r.stderr
+	return cmd.Run()
+}
+
+// newRuntime returns the container runtime with the given name, or the
+// first installed one if name is empty.
+func newRuntime(name string, r commandRunner) (containerRuntime, error) {
+	if name == "" {
+		for _, rt := range runtimes {
+			if r.lookPath(rt) {
+				name = rt
+				break
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("no container runtime found; install one of %s", strings.Join(runtimes, ", "))
+		}
+	}
+	switch name {
+	case "docker":
+		// Build with buildx bake when the buildx plugin is installed
+		return &cliRuntime{program: name, runner: r, bake: r.check("docker", "buildx", "version")}, nil
+	case "podman", "nerdctl":
+		// Both accept docker's build and run arguments
+		return &cliRuntime{program: name, runner: r}, nil
+	}
+	return nil, fmt.Errorf("unknown container runtime %q; use one of %s", name, strings.Join(runtimes, ", "))
+}
+
+// A cliRuntime is a container runtime driven through a docker-compatible
+// command line interface.
+type cliRuntime struct {
+	program string
+	runner  commandRunner
+	bake    bool // build with docker buildx bake
+}
+
+func (rt *cliRuntime) name() string { return rt.program }
+
+func (rt *cliRuntime) build(dir string, images []*containerImage) error {
+	if err := writeDockerfiles(dir, images); err != nil {
+		return err
+	}
+	if rt.bake {
+		// Build all images at once
+		if err := writeBakeFile(dir, images); err != nil {
+			return err
+		}
+		if err := rt.runner.run(dir, nil, rt.program, "buildx", "bake"); err != nil {
+			return fmt.Errorf("failed to build images with %s buildx bake: %v", rt.program, err)
+		}
+		return nil
+	}
+	for _, img := range images {
+		args := []string{"build", "-f", img.dockerfileName(), "-t", img.tag}
+		if img.platform != "" {
+			args = append(args, "--platform", img.platform)
+		}
+		if err := rt.runner.run(dir, nil, rt.program, append(args, ".")...); err != nil {
+			return fmt.Errorf("failed to build image %s with %s: %v", img.tag, rt.program, err)
+		}
+	}
+	return nil
+}
+
+func (rt *cliRuntime) run(c containerRun) error {
+	args := []string{"run", "--rm"}
+	if c.platform != "" {
+		args = append(args, "--platform", c.platform)
+	}
+	if c.workdir != "" {
+		args = append(args, "-w", c.workdir)
+	}
+	for _, m := range c.mounts {
+		args = append(args, "-v", m)
+	}
+	for _, e := range c.env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, c.image)
+	args = append(args, c.command...)
+	return rt.runner.run("", c.stdout, rt.program, args...)
+}
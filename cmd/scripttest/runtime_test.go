@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeRunner records the commands a runtime runs instead of running them.
+type fakeRunner struct {
+	installed []string // programs on $PATH
+	buildx    bool     // whether docker buildx is installed
+	fail      string   // commands containing this fail
+	failed    []string // scripts whose tests fail, by subtest name
+	checks    []string // commands run by check
+	commands  []string // commands run by run
+}
+
+func (r *fakeRunner) lookPath(program string) bool {
+	for _, p := range r.installed {
+		if p == program {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeRunner) check(program string, args ...string) bool {
+	r.checks = append(r.checks, strings.Join(append([]string{program}, args...), " "))
+	return r.buildx && program == "docker" && len(args) > 0 && args[0] == "buildx"
+}
+
+func (r *fakeRunner) run(dir string, stdout io.Writer, program string, args ...string) error {
+	cmd := strings.Join(append([]string{program}, args...), " ")
+	r.commands = append(r.commands, cmd)
+	if r.fail != "" && strings.Contains(cmd, r.fail) {
+		return errors.New("exit status 1")
+	}
+
+	// Report the result of each script the harness runs, as go test -json
+	// would, and fail if any of them failed
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	var err error
+	for _, arg := range args {
+		pattern, ok := strings.CutPrefix(arg, "SCRIPTTEST_PATTERN=")
+		if !ok {
+			continue
+		}
+		for _, file := range strings.Split(pattern, ":") {
+			name := strings.TrimSuffix(path.Base(file), ".txt")
+			action := "pass"
+			if slices.Contains(r.failed, name) {
+				action, err = "fail", errors.New("exit status 1")
+				fmt.Fprintf(stdout, `{"Action":"output","Test":"Test/%s","Output":"%s failed\n"}`+"\n", name, name)
+			}
+			fmt.Fprintf(stdout, `{"Action":%q,"Test":"Test/%s"}`+"\n", action, name)
+		}
+	}
+	return err
+}
+
+func TestNewRuntime(t *testing.T) {
+	tests := []struct {
+		name      string
+		installed []string
+		want      string
+		wantErr   string
+	}{
+		{"", []string{"docker", "podman"}, "docker", ""},
+		{"", []string{"nerdctl", "podman"}, "podman", ""},
+		{"", nil, "", "no container runtime found"},
+		{"nerdctl", nil, "nerdctl", ""},
+		{"rkt", nil, "", `unknown container runtime "rkt"`},
+	}
+	for _, tt := range tests {
+		rt, err := newRuntime(tt.name, &fakeRunner{installed: tt.installed})
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newRuntime(%q) with %v installed: error %v, want %q", tt.name, tt.installed, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newRuntime(%q): %v", tt.name, err)
+			continue
+		}
+		if rt.name() != tt.want {
+			t.Errorf("newRuntime(%q) with %v installed = %s, want %s", tt.name, tt.installed, rt.name(), tt.want)
+		}
+	}
+}
+
+func testImages() []*containerImage {
+	return []*containerImage{
+		{dockerfile: "FROM golang\n", name: "scripttest-aaa", tag: "scripttest-runner:aaa", scripts: []string{"testdata/a.txt", "testdata/b.txt"}},
+		{dockerfile: "FROM alpine\n", name: "scripttest-bbb", tag: "scripttest-runner:bbb", scripts: []string{"other/c.txt"}},
+	}
+}
+
+func TestRuntimeBuild(t *testing.T) {
+	tests := []struct {
+		runtime string
+		buildx  bool
+		want    []string
+	}{
+		{"docker", true, []string{"docker buildx bake"}},
+		{"docker", false, []string{
+			"docker build -f Dockerfile.aaa -t scripttest-runner:aaa .",
+			"docker build -f Dockerfile.bbb -t scripttest-runner:bbb .",
+		}},
+		{"podman", true, []string{
+			"podman build -f Dockerfile.aaa -t scripttest-runner:aaa .",
+			"podman build -f Dockerfile.bbb -t scripttest-runner:bbb .",
+		}},
+		{"nerdctl", false, []string{
+			"nerdctl build -f Dockerfile.aaa -t scripttest-runner:aaa .",
+			"nerdctl build -f Dockerfile.bbb -t scripttest-runner:bbb .",
+		}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		r := &fakeRunner{buildx: tt.buildx}
+		rt, err := newRuntime(tt.runtime, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.build(dir, testImages()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.commands, tt.want) {
+			t.Errorf("%s (buildx %v) ran %q, want %q", tt.runtime, tt.buildx, r.commands, tt.want)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "Dockerfile.bbb"))
+		if err != nil || string(data) != "FROM alpine\n" {
+			t.Errorf("%s: Dockerfile.bbb = %q, %v", tt.runtime, data, err)
+		}
+		_, err = os.Stat(filepath.Join(dir, "docker-bake.hcl"))
+		if hasBake := err == nil; hasBake != (tt.runtime == "docker" && tt.buildx) {
+			t.Errorf("%s (buildx %v): docker-bake.hcl written = %v", tt.runtime, tt.buildx, hasBake)
+		}
+	}
+}
+
+func TestRunImages(t *testing.T) {
+	r := &fakeRunner{fail: "SCRIPTTEST_PATTERN=testdata/a.txt"}
+	rt, err := newRuntime("podman", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = runImages(rt, dir, []string{"/src/testdata", "/src/other"}, testImages())
+	if err == nil || !strings.Contains(err.Error(), "tests failed in podman: testdata/a.txt, testdata/b.txt") {
+		t.Errorf("runImages error = %v, want failure of a.txt and b.txt", err)
+	}
+	// Every image runs despite the failure
+	mounts := "-v " + dir + ":/app -v /src/testdata:/scripts/0 -v /src/other:/scripts/1"
+	want := []string{
+		"podman build -f Dockerfile.aaa -t scripttest-runner:aaa .",
+		"podman build -f Dockerfile.bbb -t scripttest-runner:bbb .",
+		"podman run --rm -w /app " + mounts + " -e SCRIPTTEST_PATTERN=testdata/a.txt:testdata/b.txt scripttest-runner:aaa go test -json",
+		"podman run --rm -w /app " + mounts + " -e SCRIPTTEST_PATTERN=testdata/c.txt scripttest-runner:bbb go test -json",
+	}
+	if !reflect.DeepEqual(r.commands, want) {
+		t.Errorf("ran:\n%s\nwant:\n%s", strings.Join(r.commands, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRunImagesScriptResults(t *testing.T) {
+	// Only the script that failed is reported, not the others in its
+	// container
+	r := &fakeRunner{failed: []string{"b"}}
+	rt, err := newRuntime("podman", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runImages(rt, t.TempDir(), nil, testImages())
+	if err == nil || !strings.HasSuffix(err.Error(), "tests failed in podman: testdata/b.txt") {
+		t.Errorf("runImages error = %v, want failure of only b.txt", err)
+	}
+
+	r = &fakeRunner{}
+	if rt, err = newRuntime("podman", r); err != nil {
+		t.Fatal(err)
+	}
+	if err := runImages(rt, t.TempDir(), nil, testImages()); err != nil {
+		t.Errorf("runImages with passing scripts: %v", err)
+	}
+}
+
+func TestRunImagesBuildFailure(t *testing.T) {
+	r := &fakeRunner{fail: "build"}
+	rt, err := newRuntime("nerdctl", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runImages(rt, t.TempDir(), nil, testImages())
+	if err == nil || !strings.Contains(err.Error(), "failed to build image scripttest-runner:aaa with nerdctl") {
+		t.Errorf("runImages error = %v, want build failure", err)
+	}
+	if len(r.commands) != 1 {
+		t.Errorf("ran %q after the build failed", r.commands)
+	}
+}
+
+func TestRuntimePlatform(t *testing.T) {
+	images := testImages()[:1]
+	images[0].platform = "linux/arm64"
+	for _, buildx := range []bool{false, true} {
+		dir := t.TempDir()
+		r := &fakeRunner{buildx: buildx}
+		rt, err := newRuntime("docker", r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := runImages(rt, dir, nil, images); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"docker build -f Dockerfile.aaa -t scripttest-runner:aaa --platform linux/arm64 .",
+			"docker run --rm --platform linux/arm64 -w /app -v " + dir + ":/app -e SCRIPTTEST_PATTERN=testdata/a.txt:testdata/b.txt scripttest-runner:aaa go test -json",
+		}
+		if buildx {
+			want[0] = "docker buildx bake"
+			bake, err := os.ReadFile(filepath.Join(dir, "docker-bake.hcl"))
+			if err != nil || !strings.Contains(string(bake), `platforms = ["linux/arm64"]`) {
+				t.Errorf("docker-bake.hcl does not set the platform:\n%s", bake)
+			}
+		}
+		if !reflect.DeepEqual(r.commands, want) {
+			t.Errorf("buildx %v: ran:\n%s\nwant:\n%s", buildx, strings.Join(r.commands, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestRuntimeRun(t *testing.T) {
+	c := containerRun{
+		image:    "scripttest-runner:aaa",
+		platform: "linux/amd64",
+		workdir:  "/app",
+		mounts:   []string{"/tmp/build:/app", "/src/testdata:/scripts/0"},
+		env:      []string{"SCRIPTTEST_PATTERN=testdata/a.txt", "UPDATE_SNAPSHOTS=1"},
+		command:  []string{"go", "test", "-json"},
+	}
+	args := " run --rm --platform linux/amd64 -w /app -v /tmp/build:/app -v /src/testdata:/scripts/0" +
+		" -e SCRIPTTEST_PATTERN=testdata/a.txt -e UPDATE_SNAPSHOTS=1 scripttest-runner:aaa go test -json"
+	tests := []struct {
+		runtime string
+		checks  []string
+		want    string
+	}{
+		{"docker", []string{"docker buildx version"}, "docker" + args},
+		{"podman", nil, "podman" + args},
+		{"nerdctl", nil, "nerdctl" + args},
+	}
+	for _, tt := range tests {
+		r := &fakeRunner{buildx: true}
+		rt, err := newRuntime(tt.runtime, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rt.run(c); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.checks, tt.checks) {
+			t.Errorf("%s checked %q, want %q", tt.runtime, r.checks, tt.checks)
+		}
+		if want := []string{tt.want}; !reflect.DeepEqual(r.commands, want) {
+			t.Errorf("%s ran %q, want %q", tt.runtime, r.commands, want)
+		}
+
+		// Only the image is required
+		r.commands = nil
+		if err := rt.run(containerRun{image: "alpine"}); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{tt.runtime + " run --rm alpine"}; !reflect.DeepEqual(r.commands, want) {
+			t.Errorf("%s ran %q, want %q", tt.runtime, r.commands, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/tmc/scripttestutil/snapshot"
+)
+
+// runSnapshots implements the snapshots command and its subcommands.
+func runSnapshots(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("snapshots requires a subcommand: check, prune or review")
+	}
+	sub, args := args[0], args[1:]
+
+	// The scripts matching the configured pattern share the snapshots
+	// even if a pattern argument selects fewer of them
+	all, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	// If pattern provided as argument, override flag
+	if len(args) > 0 {
+		pattern = args[0]
+	}
+	scripts, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern: %v", err)
+	}
+	if len(scripts) == 0 {
+		return fmt.Errorf("no files match pattern: %s", pattern)
+	}
+
+	switch sub {
+	case "check":
+		return checkSnapshots(scripts, all)
+	case "prune":
+		return pruneSnapshots(scripts, all)
+	case "review":
+		return reviewSnapshots(scripts, os.Stdin, os.Stdout)
+	default:
+		return fmt.Errorf("unknown snapshots subcommand %q (want check, prune or review)", sub)
+	}
+}
+
+// checkSnapshots reports orphaned and missing snapshots, failing if there
+// are any. The other scripts share the snapshot directories (see
+// snapshot.Audit).
+func checkSnapshots(scripts, others []string) error {
+	orphaned, missing, err := snapshot.Audit(scripts, others, runtime.GOOS, snapshotFormat)
+	if err != nil {
+		return fmt.Errorf("failed to audit snapshots: %v", err)
+	}
+	for _, file := range orphaned {
+		fmt.Printf("orphaned: %s\n", file)
+	}
+	for _, ref := range missing {
+		fmt.Printf("missing: %s (referenced at %s:%d)\n", ref.File, ref.Script, ref.Line)
+	}
+	if n := len(orphaned) + len(missing); n > 0 {
+		return fmt.Errorf("%d orphaned and %d missing snapshots", len(orphaned), len(missing))
+	}
+	if verbose {
+		fmt.Printf("snapshots for %d scripts are up to date\n", len(scripts))
+	}
+	return nil
+}
+
+// pruneSnapshots deletes orphaned snapshots along with their diff and
+// candidate artifacts. The other scripts share the snapshot directories
+// (see snapshot.Audit).
+func pruneSnapshots(scripts, others []string) error {
+	orphaned, missing, err := snapshot.Audit(scripts, others, runtime.GOOS, snapshotFormat)
+	if err != nil {
+		return fmt.Errorf("failed to audit snapshots: %v", err)
+	}
+	for _, file := range orphaned {
+		if err := os.Remove(file); err != nil {
+			return fmt.Errorf("failed to remove snapshot: %v", err)
+		}
+		if err := snapshot.RemoveArtifacts(file); err != nil {
+			return err
+		}
+		fmt.Printf("removed: %s\n", file)
+	}
+	for _, ref := range missing {
+		fmt.Printf("missing: %s (referenced at %s:%d)\n", ref.File, ref.Script, ref.Line)
+	}
+	return nil
+}
+
+// reviewSnapshots walks through the candidates left by failing snapshot
+// commands, showing each as a diff against the stored snapshot and asking
+// whether to accept, reject or skip it.
+func reviewSnapshots(scripts []string, in io.Reader, out io.Writer) error {
+	candidates, err := snapshot.Candidates(scripts)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot candidates: %v", err)
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintln(out, "no snapshots to review")
+		return nil
+	}
+
+	color := useColor(out)
+	answers := bufio.NewScanner(in)
+	var accepted, rejected, skipped int
+	for i, candidate := range candidates {
+		file := strings.TrimSuffix(candidate, ".new")
+		fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(candidates), file)
+		diff, err := snapshot.DiffCandidate(file)
+		if err != nil {
+			return err
+		}
+		if diff == "" {
+			fmt.Fprintln(out, "(no changes)")
+		} else if color {
+			fmt.Fprint(out, colorDiff(diff))
+		} else {
+			fmt.Fprint(out, diff)
+		}
+
+		answer, ok := ask(answers, out)
+		if !ok || answer == "q" {
+			// Leave the remaining candidates for a later review
+			skipped += len(candidates) - i
+			break
+		}
+		switch answer {
+		case "a":
+			if err := snapshot.AcceptCandidate(file); err != nil {
+				return fmt.Errorf("failed to accept snapshot: %v", err)
+			}
+			accepted++
+		case "r":
+			if err := snapshot.RemoveArtifacts(file); err != nil {
+				return err
+			}
+			rejected++
+		case "s":
+			skipped++
+		}
+	}
+	fmt.Fprintf(out, "\n%d accepted, %d rejected, %d skipped\n", accepted, rejected, skipped)
+	return answers.Err()
+}
+
+// ask prompts for a review decision until it reads a valid answer, which is
+// returned as a single letter. It returns false when the input is exhausted.
+func ask(answers *bufio.Scanner, out io.Writer) (string, bool) {
+	for {
+		fmt.Fprint(out, "[a]ccept, [r]eject, [s]kip, [q]uit? ")
+		if !answers.Scan() {
+			fmt.Fprintln(out)
+			return "", false
+		}
+		switch answer := strings.ToLower(strings.TrimSpace(answers.Text())); answer {
+		case "a", "accept", "r", "reject", "s", "skip", "q", "quit":
+			return answer[:1], true
+		case "":
+			return "s", true
+		}
+	}
+}
+
+// useColor reports whether to color output written to w: only terminals
+// are colored, and never when NO_COLOR is set.
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorDiff colors the lines of a unified diff with ANSI escapes.
+func colorDiff(diff string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			b.WriteString("\033[1m" + strings.TrimSuffix(line, "\n") + "\033[0m\n")
+		case strings.HasPrefix(line, "@@"):
+			b.WriteString("\033[36m" + strings.TrimSuffix(line, "\n") + "\033[0m\n")
+		case strings.HasPrefix(line, "-"):
+			b.WriteString("\033[31m" + strings.TrimSuffix(line, "\n") + "\033[0m\n")
+		case strings.HasPrefix(line, "+"):
+			b.WriteString("\033[32m" + strings.TrimSuffix(line, "\n") + "\033[0m\n")
+		default:
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
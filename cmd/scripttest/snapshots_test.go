@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tmc/scripttestutil/snapshot"
+)
+
+// pendingSnapshots creates the scripts a, b and c with a candidate each:
+// a and b change their stored snapshot, and c has none yet. It returns the
+// scripts and their snapshot directory.
+func pendingSnapshots(t *testing.T) ([]string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	var scripts []string
+	for _, name := range []string{"a", "b", "c"} {
+		scripts = append(scripts, writeScript(t, dir, name+".txt", "echo "+name+"\nsnapshot\n"))
+	}
+	snapshots := snapshot.Dir(scripts[0])
+	if err := os.MkdirAll(snapshots, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		file := filepath.Join(snapshots, name+".json")
+		if name != "c" {
+			if err := snapshot.WriteFile(file, &snapshot.Snapshot{Stdout: "old " + name + "\n"}); err != nil {
+				t.Fatal(err)
+			}
+			writeScript(t, snapshots, name+".json.diff", "diff\n")
+		}
+		if err := snapshot.WriteFile(snapshot.Candidate(file), &snapshot.Snapshot{Stdout: "new " + name + "\n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return scripts, snapshots
+}
+
+// stored returns the stdout stored in the snapshot file, or "" if there is
+// none.
+func stored(t *testing.T, file string) string {
+	t.Helper()
+	snap, err := snapshot.ReadFile(file)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap.Stdout
+}
+
+func TestReviewSnapshots(t *testing.T) {
+	scripts, snapshots := pendingSnapshots(t)
+	var out strings.Builder
+	// An unknown answer is asked again, and an empty one skips
+	if err := reviewSnapshots(scripts, strings.NewReader("accept\nmaybe\nr\n\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"[1/3] " + filepath.Join(snapshots, "a.json") + "\n",
+		"-old a\n+new a\n",
+		"[3/3] " + filepath.Join(snapshots, "c.json") + "\n",
+		"\n1 accepted, 1 rejected, 1 skipped\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("review output does not contain %q:\n%s", want, out.String())
+		}
+	}
+	if n := strings.Count(out.String(), "[a]ccept, [r]eject, [s]kip, [q]uit? "); n != 4 {
+		t.Errorf("asked %d times, want 4", n)
+	}
+
+	file := func(name string) string { return filepath.Join(snapshots, name) }
+	if got := stored(t, file("a.json")); got != "new a\n" {
+		t.Errorf("accepted snapshot stores %q, want the candidate", got)
+	}
+	if got := stored(t, file("b.json")); got != "old b\n" {
+		t.Errorf("rejected snapshot stores %q, want the old output", got)
+	}
+	for _, name := range []string{"a.json.new", "a.json.diff", "b.json.new", "b.json.diff"} {
+		if _, err := os.Stat(file(name)); !os.IsNotExist(err) {
+			t.Errorf("%s left after review", name)
+		}
+	}
+	if got := stored(t, file("c.json.new")); got != "new c\n" {
+		t.Errorf("skipped candidate stores %q, want it kept", got)
+	}
+	if got := stored(t, file("c.json")); got != "" {
+		t.Errorf("skipped snapshot was created with %q", got)
+	}
+
+	// Only the skipped candidate is left to review
+	out.Reset()
+	if err := reviewSnapshots(scripts, strings.NewReader("a\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[1/1] "+file("c.json")) || stored(t, file("c.json")) != "new c\n" {
+		t.Errorf("accepting a new snapshot:\n%s", out.String())
+	}
+	out.Reset()
+	if err := reviewSnapshots(scripts, strings.NewReader(""), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "no snapshots to review\n" {
+		t.Errorf("review without candidates printed %q", out.String())
+	}
+}
+
+func TestReviewSnapshotsQuit(t *testing.T) {
+	for _, input := range []string{"s\nq\n", "s\n"} {
+		scripts, snapshots := pendingSnapshots(t)
+		var out strings.Builder
+		if err := reviewSnapshots(scripts, strings.NewReader(input), &out); err != nil {
+			t.Fatal(err)
+		}
+		// Quitting or running out of answers leaves the rest for later
+		if !strings.HasSuffix(out.String(), "\n0 accepted, 0 rejected, 3 skipped\n") {
+			t.Errorf("review with input %q printed:\n%s", input, out.String())
+		}
+		candidates, err := snapshot.Candidates(scripts)
+		if err != nil || len(candidates) != 3 {
+			t.Errorf("candidates after quitting = %v, %v, want all 3", candidates, err)
+		}
+		if got := stored(t, filepath.Join(snapshots, "a.json")); got != "old a\n" {
+			t.Errorf("snapshot after quitting stores %q", got)
+		}
+	}
+}
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"embed"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"text/template"
 	"time"
+
+	"github.com/tmc/scripttestutil/internal/dockercli"
+	"github.com/tmc/scripttestutil/internal/scriptexec"
+	"github.com/tmc/scripttestutil/services"
+	"github.com/tmc/scripttestutil/snapshot"
+)
+
+// modulePath is the path of this module, and harnessModule that of the
+// test harness module generated from templates/go.mod.tmpl.
+const (
+	modulePath    = "github.com/tmc/scripttestutil"
+	harnessModule = "scripttest"
 )
 
 //go:embed templates/*.tmpl
@@ -47,9 +61,43 @@ func setupTestDir(dir string) error {
 		}
 	}
 
+	if err := writePackage(filepath.Join(dir, "snapshot"), snapshot.Source); err != nil {
+		return fmt.Errorf("failed to write snapshot package: %v", err)
+	}
+	if err := writePackage(filepath.Join(dir, "services"), services.Source); err != nil {
+		return fmt.Errorf("failed to write services package: %v", err)
+	}
+	if err := writePackage(filepath.Join(dir, "internal", "scriptexec"), scriptexec.Source); err != nil {
+		return fmt.Errorf("failed to write scriptexec package: %v", err)
+	}
+	if err := writePackage(filepath.Join(dir, "internal", "dockercli"), dockercli.Source); err != nil {
+		return fmt.Errorf("failed to write dockercli package: %v", err)
+	}
+
 	return nil
 }
 
+// writePackage copies the Go source files in src into dir so that the
+// generated module can import them without depending on scripttestutil.
+// Imports of other packages of scripttestutil, which are copied too, are
+// rewritten to import the copies.
+func writePackage(dir string, src fs.FS) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return fs.WalkDir(src, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(src, path)
+		if err != nil {
+			return err
+		}
+		data = bytes.ReplaceAll(data, []byte(`"`+modulePath+`/`), []byte(`"`+harnessModule+`/`))
+		return os.WriteFile(filepath.Join(dir, filepath.Base(path)), data, 0644)
+	})
+}
+
 func getBuildID() string {
 	// Default if we can't get VCS info
 	buildID := fmt.Sprintf("scripttest-%s", time.Now().Format("20060102"))
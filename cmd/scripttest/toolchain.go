@@ -1,6 +1,12 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -8,10 +14,13 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 // ensureGoToolchain ensures the Go toolchain is available.
-// If autoGoToolchain is true and Go is not installed, it will download and install Go.
+// If autoGoToolchain is true and Go is not installed, it installs the
+// version the current module needs (see resolveGoVersion) and adds it to
+// $PATH.
 func ensureGoToolchain() error {
 	// Skip if auto-obtaining is disabled
 	if !autoGoToolchain {
@@ -23,125 +32,327 @@ func ensureGoToolchain() error {
 		return nil // Go is already installed
 	}
 
-	// Determine OS and architecture
-	goOS := runtime.GOOS
-	goArch := runtime.GOARCH
-
-	// Determine latest stable Go version
-	goVersion, err := getLatestGoVersion()
+	// Determine the Go version the module in the current directory needs
+	goVersion, err := resolveGoVersion(".")
 	if err != nil {
-		return fmt.Errorf("failed to determine latest Go version: %v", err)
+		return fmt.Errorf("failed to determine Go version: %v", err)
+	}
+	if verbose {
+		fmt.Printf("Go toolchain not found. Installing Go %s for %s/%s\n", goVersion, runtime.GOOS, runtime.GOARCH)
+	}
+	goroot, err := installGo(goVersion)
+	if err != nil {
+		return err
 	}
 
+	// Add Go bin to PATH for the current process
+	bin := filepath.Join(goroot, "bin")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
 	if verbose {
-		fmt.Printf("Go toolchain not found. Downloading Go %s for %s/%s\n", goVersion, goOS, goArch)
+		fmt.Printf("Added %s to PATH\n", bin)
+	}
+
+	// Verify installation
+	cmd := exec.Command("go", "version")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Go installation verification failed: %v", err)
 	}
 
-	// Get download URL for the platform
-	downloadURL := fmt.Sprintf("https://go.dev/dl/go%s.%s-%s.tar.gz", goVersion, goOS, goArch)
-	if goOS == "windows" {
-		downloadURL = fmt.Sprintf("https://go.dev/dl/go%s.%s-%s.zip", goVersion, goOS, goArch)
+	if verbose {
+		fmt.Printf("Go installation verified: %s\n", string(output))
 	}
 
-	// Determine installation directory
-	var installDir string
-	homeDir, err := os.UserHomeDir()
+	return nil
+}
+
+// userCacheDir returns the user's cache directory. Tests replace it.
+var userCacheDir = os.UserCacheDir
+
+// toolchainsDir returns the directory Go toolchains are installed in, each
+// in a directory named after its version, such as go1.23.1.
+func toolchainsDir() (string, error) {
+	cache, err := userCacheDir()
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %v", err)
+		return "", fmt.Errorf("failed to find cache directory: %v", err)
 	}
+	return filepath.Join(cache, "scripttest", "go"), nil
+}
 
-	if goOS == "windows" {
-		installDir = filepath.Join(homeDir, "go")
-	} else {
-		installDir = "/usr/local/go"
-		// Use home directory if /usr/local/go is not writable
-		if err := os.MkdirAll(installDir, 0755); err != nil {
-			installDir = filepath.Join(homeDir, "go")
+// installGo installs the Go release version, such as 1.23.1, for the host
+// platform under toolchainsDir, unless it is already installed, and returns
+// its GOROOT. The download is verified against the checksum in the download
+// index.
+func installGo(version string) (string, error) {
+	dir, err := toolchainsDir()
+	if err != nil {
+		return "", err
+	}
+	goroot := filepath.Join(dir, "go"+version)
+	if _, err := os.Stat(filepath.Join(goroot, "bin", exe("go"))); err == nil {
+		if verbose {
+			fmt.Printf("Using Go %s in %s\n", version, goroot)
 		}
+		return goroot, nil
 	}
 
-	// Create temporary directory for download
-	tempDir, err := os.MkdirTemp("", "go-download-*")
+	// Find the archive for this platform in the index
+	releases, err := fetchGoReleases(goDownloadURL)
+	if err != nil {
+		return "", err
+	}
+	file, err := goArchive(releases, version, runtime.GOOS, runtime.GOARCH)
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
+		return "", err
 	}
-	defer os.RemoveAll(tempDir)
 
-	// Download Go archive
-	archivePath := filepath.Join(tempDir, filepath.Base(downloadURL))
-	if err := downloadFile(downloadURL, archivePath); err != nil {
-		return fmt.Errorf("failed to download Go: %v", err)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create toolchain directory: %v", err)
 	}
+	tmp, err := os.MkdirTemp(dir, ".download-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
 
-	// Extract archive
-	if goOS == "windows" {
-		// Extract zip file for Windows
-		if err := extractZip(archivePath, filepath.Dir(installDir)); err != nil {
-			return fmt.Errorf("failed to extract Go archive: %v", err)
-		}
+	// Download and verify the archive
+	archivePath := filepath.Join(tmp, file.Filename)
+	sum, err := downloadFile(goDownloadLink(file.Filename), archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to download Go %s: %v", version, err)
+	}
+	if !strings.EqualFold(sum, file.SHA256) {
+		return "", fmt.Errorf("checksum mismatch for %s: got sha256 %s, want %s", file.Filename, sum, file.SHA256)
+	}
+
+	// Extract the archive's go directory next to the final one, then move it
+	// into place, so that an interrupted install is never used
+	if verbose {
+		fmt.Printf("Extracting %s to %s...\n", file.Filename, goroot)
+	}
+	extracted := filepath.Join(tmp, "root")
+	if strings.HasSuffix(file.Filename, ".zip") {
+		err = extractZip(archivePath, extracted)
 	} else {
-		// Extract tar.gz for Unix systems
-		if err := extractTarGz(archivePath, filepath.Dir(installDir)); err != nil {
-			return fmt.Errorf("failed to extract Go archive: %v", err)
+		err = extractTarGz(archivePath, extracted)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %v", file.Filename, err)
+	}
+	if err := os.Rename(filepath.Join(extracted, "go"), goroot); err != nil {
+		if _, statErr := os.Stat(filepath.Join(goroot, "bin", exe("go"))); statErr == nil {
+			return goroot, nil // installed concurrently
 		}
+		return "", fmt.Errorf("failed to install Go %s: %v", version, err)
 	}
+	if verbose {
+		fmt.Printf("Go %s installed to %s\n", version, goroot)
+	}
+	return goroot, nil
+}
 
-	// Add Go bin to PATH for the current process
-	path := os.Getenv("PATH")
-	newPath := filepath.Join(installDir, "bin") + string(os.PathListSeparator) + path
-	os.Setenv("PATH", newPath)
+// goTipRepo is the Git repository Go at tip is built from.
+var goTipRepo = "https://go.googlesource.com/go"
 
-	if verbose {
-		fmt.Printf("Go %s installed to %s\n", goVersion, installDir)
-		fmt.Printf("Added %s to PATH\n", filepath.Join(installDir, "bin"))
+// parseToolchain parses a toolchain as named in $GOTOOLCHAIN or on the
+// command line: a version such as 1.22, 1.22.3 or go1.23rc1, "tip" for the
+// development version, "local" (or "path") for the go command on $PATH, or
+// "auto" for the version the current module needs.
+func parseToolchain(name string) (string, error) {
+	name, _, _ = strings.Cut(name, "+") // as in go1.22.3+auto
+	switch name {
+	case "tip", "gotip":
+		return "tip", nil
+	case "local", "path":
+		return "local", nil
+	case "auto":
+		return "auto", nil
 	}
+	version, _, _ := strings.Cut(strings.TrimPrefix(name, "go"), "-")
+	if major, minor, _ := parseGoVersion(version); major == "" || strings.Trim(major, "0123456789") != "" || minor == "" {
+		return "", fmt.Errorf("invalid Go toolchain %q: want a version such as 1.22 or go1.22.3, tip or local", name)
+	}
+	return version, nil
+}
 
-	// Verify installation
-	cmd := exec.Command("go", "version")
-	output, err := cmd.CombinedOutput()
+// installToolchain installs the toolchain named name (see parseToolchain)
+// unless it is already installed, and returns the name of the version
+// installed, such as go1.22.3, and its GOROOT. A language version such as
+// 1.22 installs its latest release, and auto the one resolveGoVersion
+// picks. The local toolchain is not installed and has an empty GOROOT.
+func installToolchain(name string) (string, string, error) {
+	version, err := parseToolchain(name)
 	if err != nil {
-		return fmt.Errorf("Go installation verification failed: %v", err)
+		return "", "", err
+	}
+	switch version {
+	case "local":
+		return "local", "", nil
+	case "tip":
+		goroot, err := installGoTip(false)
+		return "tip", goroot, err
+	case "auto":
+		if version, err = resolveGoVersion("."); err != nil {
+			return "", "", err
+		}
+	}
+	if !isGoRelease(version) {
+		releases, err := fetchGoReleases(goDownloadURL)
+		if err != nil {
+			return "", "", err
+		}
+		if version, err = latestGoRelease(releases, version); err != nil {
+			return "", "", err
+		}
+	}
+	goroot, err := installGo(version)
+	return "go" + version, goroot, err
+}
+
+// tipBuilt is the file installGoTip creates in the gotip directory once
+// tip is built, so that a failed or interrupted build is never used.
+const tipBuilt = ".scripttest-built"
+
+// installGoTip installs Go at tip, the development version, in the gotip
+// directory under toolchainsDir, building it from source with the latest
+// release. An installed tip is reused unless update is set, in which case
+// it is updated and rebuilt. A tip that failed to build is rebuilt.
+func installGoTip(update bool) (string, error) {
+	dir, err := toolchainsDir()
+	if err != nil {
+		return "", err
+	}
+	goroot := filepath.Join(dir, "gotip")
+	marker := filepath.Join(goroot, tipBuilt)
+	if _, err := os.Stat(marker); err == nil && !update {
+		return goroot, nil
+	}
+
+	// Building Go needs a Go release to bootstrap with
+	releases, err := fetchGoReleases(goDownloadURL)
+	if err != nil {
+		return "", err
+	}
+	latest, err := latestGoRelease(releases, "")
+	if err != nil {
+		return "", err
+	}
+	bootstrap, err := installGo(latest)
+	if err != nil {
+		return "", fmt.Errorf("failed to install Go %s to build tip with: %v", latest, err)
+	}
+
+	git := func(args ...string) error {
+		cmd := exec.Command("git", args...)
+		if verbose {
+			cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		}
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("git %s failed: %v", args[0], err)
+		}
+		return nil
+	}
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(goroot, ".git")); err != nil {
+		if verbose {
+			fmt.Printf("Cloning %s into %s\n", goTipRepo, goroot)
+		}
+		if err := os.RemoveAll(goroot); err != nil {
+			return "", err
+		}
+		if err := git("clone", "--depth=1", goTipRepo, goroot); err != nil {
+			return "", err
+		}
+	} else {
+		if err := git("-C", goroot, "fetch", "--depth=1", goTipRepo, "HEAD"); err != nil {
+			return "", err
+		}
+		if err := git("-C", goroot, "reset", "--hard", "FETCH_HEAD"); err != nil {
+			return "", err
+		}
 	}
 
+	// Build it
+	script := "make.bash"
+	if runtime.GOOS == "windows" {
+		script = "make.bat"
+	}
 	if verbose {
-		fmt.Printf("Go installation verified: %s\n", string(output))
+		fmt.Printf("Building Go at tip with Go %s\n", latest)
 	}
+	cmd := exec.Command(filepath.Join(goroot, "src", script))
+	cmd.Dir = filepath.Join(goroot, "src")
+	cmd.Env = append(os.Environ(), "GOROOT_BOOTSTRAP="+bootstrap, "GOTOOLCHAIN=local")
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if verbose {
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to build Go at tip: %v\n%s", err, output.String())
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return "", err
+	}
+	return goroot, nil
+}
 
-	return nil
+// goArchive returns the archive of a Go release for a platform.
+func goArchive(releases []goRelease, version, goos, goarch string) (goFile, error) {
+	for _, r := range releases {
+		if r.Version != "go"+version {
+			continue
+		}
+		for _, f := range r.Files {
+			if f.Kind == "archive" && f.OS == goos && f.Arch == goarch {
+				if f.SHA256 == "" {
+					return goFile{}, fmt.Errorf("no checksum for %s in the Go release index", f.Filename)
+				}
+				return f, nil
+			}
+		}
+		return goFile{}, fmt.Errorf("Go %s is not available for %s/%s", version, goos, goarch)
+	}
+	return goFile{}, fmt.Errorf("Go %s not found at %s", version, goDownloadURL)
 }
 
-// getLatestGoVersion fetches the latest stable Go version
-func getLatestGoVersion() (string, error) {
-	// For simplicity, we'll use a hardcoded recent version
-	// In a real implementation, you might want to fetch this from https://go.dev/dl/?mode=json
-	return "1.22.0", nil
+// exe returns the name of an executable on the host.
+func exe(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
 }
 
-// downloadFile downloads a file from a URL to a local path
-func downloadFile(url, filepath string) error {
+// downloadFile downloads a file from a URL to a local path and returns
+// its SHA-256 checksum in hex.
+func downloadFile(url, file string) (string, error) {
 	// Create the file
-	out, err := os.Create(filepath)
+	out, err := os.Create(file)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer out.Close()
 
 	// Get the data
 	resp, err := http.Get(url)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	// Check server response
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+		return "", fmt.Errorf("bad status: %s", resp.Status)
 	}
 
 	// Writer to track download progress
-	var writer io.Writer = out
+	h := sha256.New()
+	writer := io.MultiWriter(out, h)
 	if verbose {
-		writer = io.MultiWriter(out, newProgressWriter())
+		writer = io.MultiWriter(writer, newProgressWriter())
 	}
 
 	// Write the body to file
@@ -149,7 +360,13 @@ func downloadFile(url, filepath string) error {
 	if verbose {
 		fmt.Println() // End the progress line
 	}
-	return err
+	if err != nil {
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // progressWriter is a simple writer that shows download progress
@@ -165,50 +382,110 @@ func newProgressWriter() *progressWriter {
 func (pw *progressWriter) Write(p []byte) (int, error) {
 	n := len(p)
 	pw.totalBytes += int64(n)
-	
+
 	// Report progress every 1MB
 	if pw.totalBytes-pw.lastReport >= 1024*1024 {
 		fmt.Printf("\rDownloading... %d MB", pw.totalBytes/(1024*1024))
 		pw.lastReport = pw.totalBytes
 	}
-	
+
 	return n, nil
 }
 
 // extractTarGz extracts a .tar.gz file to a destination directory
 func extractTarGz(tarGzFile, destDir string) error {
-	// Use tar command for Unix systems
-	cmd := exec.Command("tar", "-xzf", tarGzFile, "-C", destDir)
-	if verbose {
-		fmt.Printf("Extracting %s to %s...\n", tarGzFile, destDir)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	f, err := os.Open(tarGzFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target, err := extractPath(destDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeExtracted(target, tr, hdr.FileInfo().Mode())
+		case tar.TypeSymlink, tar.TypeLink:
+			// Go releases have none, and links could let later entries
+			// write outside destDir
+			return fmt.Errorf("archive has a link, which Go releases don't: %s -> %s", hdr.Name, hdr.Linkname)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return cmd.Run()
 }
 
 // extractZip extracts a .zip file to a destination directory
 func extractZip(zipFile, destDir string) error {
-	if verbose {
-		fmt.Printf("Extracting %s to %s...\n", zipFile, destDir)
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return err
 	}
-	
-	// Use PowerShell for Windows
-	if runtime.GOOS == "windows" {
-		cmd := exec.Command("powershell", "-Command", 
-			fmt.Sprintf("Expand-Archive -Path %s -DestinationPath %s -Force", zipFile, destDir))
-		if verbose {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
+	defer zr.Close()
+	for _, f := range zr.File {
+		target, err := extractPath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeExtracted(target, r, f.Mode())
+		r.Close()
+		if err != nil {
+			return err
 		}
-		return cmd.Run()
 	}
-	
-	// Use unzip for Unix systems that might have it
-	cmd := exec.Command("unzip", "-o", zipFile, "-d", destDir)
-	if verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	return nil
+}
+
+// extractPath returns where to extract an archive entry to in destDir,
+// rejecting entries that would be written outside of it.
+func extractPath(destDir, name string) (string, error) {
+	name = filepath.FromSlash(strings.TrimSuffix(name, "/"))
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("archive has an entry outside of it: %s", name)
 	}
-	return cmd.Run()
-}
\ No newline at end of file
+	return filepath.Join(destDir, name), nil
+}
+
+// writeExtracted writes an extracted file with the given mode, creating
+// its directory if needed.
+func writeExtracted(file string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// A testEntry is a file in a test archive.
+type testEntry struct {
+	name, content string
+	mode          os.FileMode
+}
+
+// goEntries are the files of a fake Go release.
+var goEntries = []testEntry{
+	{"go/", "", 0755 | os.ModeDir},
+	{"go/VERSION", "go1.23.1\n", 0644},
+	{"go/bin/" + exe("go"), "#!/bin/sh\necho go version go1.23.1\n", 0755},
+}
+
+func tarGz(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: int64(e.mode.Perm()), Size: int64(len(e.content)), Typeflag: tar.TypeReg}
+		switch {
+		case e.mode.IsDir():
+			hdr.Typeflag, hdr.Size = tar.TypeDir, 0
+		case e.mode&os.ModeSymlink != 0:
+			// The content is the link's target
+			hdr.Typeflag, hdr.Size, hdr.Linkname = tar.TypeSymlink, 0, e.content
+			e.content = ""
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T, entries []testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		hdr.SetMode(e.mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// serveRelease serves a download index listing archive as Go 1.23.1 for
+// the host platform, with checksum sum (the archive's if empty), and
+// installs toolchains in a temporary cache directory. It returns the
+// directory the archive is served from.
+func serveRelease(t *testing.T, filename string, archive []byte, sum string) string {
+	t.Helper()
+	if sum == "" {
+		h := sha256.Sum256(archive)
+		sum = hex.EncodeToString(h[:])
+	}
+	index, err := json.Marshal([]goRelease{{
+		Version: "go1.23.1",
+		Stable:  true,
+		Files: []goFile{
+			{Filename: "go1.23.1.src.tar.gz", Kind: "source", SHA256: "0000"},
+			{Filename: filename, OS: runtime.GOOS, Arch: runtime.GOARCH, Kind: "archive", SHA256: sum},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := serveGoDownloads(t, map[string]string{"index.html": string(index), filename: string(archive)})
+
+	cache := t.TempDir()
+	old := userCacheDir
+	userCacheDir = func() (string, error) { return cache, nil }
+	t.Cleanup(func() { userCacheDir = old })
+	return dir
+}
+
+func TestInstallGo(t *testing.T) {
+	for _, tt := range []struct {
+		filename string
+		archive  func(*testing.T, []testEntry) []byte
+	}{
+		{"go1.23.1.test.tar.gz", tarGz},
+		{"go1.23.1.test.zip", zipArchive},
+	} {
+		dir := serveRelease(t, tt.filename, tt.archive(t, goEntries), "")
+		goroot, err := installGo("1.23.1")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.filename, err)
+		}
+		cache, _ := userCacheDir()
+		if want := filepath.Join(cache, "scripttest", "go", "go1.23.1"); goroot != want {
+			t.Errorf("%s: installed in %s, want %s", tt.filename, goroot, want)
+		}
+		data, err := os.ReadFile(filepath.Join(goroot, "VERSION"))
+		if err != nil || string(data) != "go1.23.1\n" {
+			t.Errorf("%s: VERSION = %q, %v", tt.filename, data, err)
+		}
+		if runtime.GOOS != "windows" {
+			fi, err := os.Stat(filepath.Join(goroot, "bin", "go"))
+			if err != nil || fi.Mode().Perm()&0100 == 0 {
+				t.Errorf("%s: bin/go is not executable: %v, %v", tt.filename, fi.Mode(), err)
+			}
+		}
+		entries, _ := os.ReadDir(filepath.Dir(goroot))
+		if len(entries) != 1 {
+			t.Errorf("%s: toolchain directory has %d entries, want only the install", tt.filename, len(entries))
+		}
+
+		// An installed version is reused without downloading it again
+		if err := os.Remove(filepath.Join(dir, tt.filename)); err != nil {
+			t.Fatal(err)
+		}
+		if again, err := installGo("1.23.1"); err != nil || again != goroot {
+			t.Errorf("%s: second installGo = %s, %v, want %s", tt.filename, again, err, goroot)
+		}
+	}
+}
+
+func TestInstallGoChecksumMismatch(t *testing.T) {
+	serveRelease(t, "go1.23.1.test.tar.gz", tarGz(t, goEntries), strings.Repeat("ab", 32))
+	_, err := installGo("1.23.1")
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for go1.23.1.test.tar.gz") {
+		t.Fatalf("installGo error = %v, want checksum mismatch", err)
+	}
+	dir, _ := toolchainsDir()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("failed install left %d entries in %s", len(entries), dir)
+	}
+}
+
+func TestInstallGoErrors(t *testing.T) {
+	serveRelease(t, "go1.23.1.test.tar.gz", tarGz(t, goEntries), "")
+	if _, err := installGo("1.99.0"); err == nil || !strings.Contains(err.Error(), "Go 1.99.0 not found") {
+		t.Errorf("installGo(1.99.0) error = %v", err)
+	}
+
+	_, err := goArchive([]goRelease{{Version: "go1.23.1", Files: []goFile{{Filename: "go.tar.gz", OS: "plan9", Arch: "386", Kind: "archive", SHA256: "00"}}}}, "1.23.1", "linux", "amd64")
+	if err == nil || !strings.Contains(err.Error(), "Go 1.23.1 is not available for linux/amd64") {
+		t.Errorf("goArchive for a missing platform error = %v", err)
+	}
+}
+
+func TestExtractRejectsLinks(t *testing.T) {
+	// Even a link within the archive is rejected
+	for _, target := range []string{"../src", "/etc", "../../evil"} {
+		entries := []testEntry{{"go/src/", "", os.ModeDir | 0755}, {"go/bin/link", target, os.ModeSymlink | 0777}}
+		dir := t.TempDir()
+		archive := filepath.Join(dir, "a.tar.gz")
+		if err := os.WriteFile(archive, tarGz(t, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractTarGz(archive, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "archive has a link") {
+			t.Errorf("extractTarGz with a link to %s: error = %v", target, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "out", "go", "bin", "link")); err == nil {
+			t.Errorf("link to %s was created", target)
+		}
+	}
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	for _, name := range []string{"../evil", "go/../../evil", "/etc/evil"} {
+		entries := []testEntry{{name, "x", 0644}}
+		dir := t.TempDir()
+		archive := filepath.Join(dir, "a.tar.gz")
+		if err := os.WriteFile(archive, tarGz(t, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractTarGz(archive, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("extractTarGz with %s: error = %v", name, err)
+		}
+		archive = filepath.Join(dir, "a.zip")
+		if err := os.WriteFile(archive, zipArchive(t, entries), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := extractZip(archive, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("extractZip with %s: error = %v", name, err)
+		}
+	}
+}
+
+func TestParseToolchain(t *testing.T) {
+	for name, want := range map[string]string{
+		"1.22":            "1.22",
+		"go1.22.3":        "1.22.3",
+		"1.23rc1":         "1.23rc1",
+		"go1.22.3+auto":   "1.22.3",
+		"go1.21.1-custom": "1.21.1",
+		"1.20":            "1.20",
+		"tip":             "tip",
+		"gotip":           "tip",
+		"local":           "local",
+		"path":            "local",
+		"auto":            "auto",
+	} {
+		if got, err := parseToolchain(name); err != nil || got != want {
+			t.Errorf("parseToolchain(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"", "go", "1", "latest", "v1.22", "x.22"} {
+		if got, err := parseToolchain(name); err == nil {
+			t.Errorf("parseToolchain(%q) = %q, want an error", name, got)
+		}
+	}
+}
+
+func TestInstallToolchain(t *testing.T) {
+	serveRelease(t, "go1.23.1.test.tar.gz", tarGz(t, goEntries), "")
+	for _, name := range []string{"1.23", "go1.23.1"} {
+		version, goroot, err := installToolchain(name)
+		if err != nil {
+			t.Fatalf("installToolchain(%s): %v", name, err)
+		}
+		if version != "go1.23.1" || filepath.Base(goroot) != "go1.23.1" {
+			t.Errorf("installToolchain(%s) = %s, %s, want go1.23.1 in go1.23.1", name, version, goroot)
+		}
+	}
+	if version, goroot, err := installToolchain("local"); version != "local" || goroot != "" || err != nil {
+		t.Errorf("installToolchain(local) = %q, %q, %v", version, goroot, err)
+	}
+	if _, _, err := installToolchain("1.24"); err == nil || !strings.Contains(err.Error(), "no stable Go 1.24 release") {
+		t.Errorf("installToolchain(1.24) error = %v", err)
+	}
+}
+
+func TestInstallGoTip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake make.bash needs a shell")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	serveRelease(t, "go1.23.1.test.tar.gz", tarGz(t, goEntries), "")
+
+	// A Go repository whose make.bash records the version it builds and
+	// what it was bootstrapped with
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	commit := func(version string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(repo, "src"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		makeBash := "#!/bin/sh\nset -e\nmkdir -p ../bin\nprintf '#!/bin/sh\\necho %s\\n' \"" + version + " $GOROOT_BOOTSTRAP\" > ../bin/go\nchmod +x ../bin/go\n"
+		if version == "broken" {
+			makeBash += "exit 1\n"
+		}
+		if err := os.WriteFile(filepath.Join(repo, "src", "make.bash"), []byte(makeBash), 0755); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "-A")
+		git("commit", "-q", "-m", version)
+	}
+	git("init", "-q")
+	commit("devel-1")
+	old := goTipRepo
+	goTipRepo = repo
+	t.Cleanup(func() { goTipRepo = old })
+
+	goroot, err := installGoTip(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, _ := userCacheDir()
+	if want := filepath.Join(cache, "scripttest", "go", "gotip"); goroot != want {
+		t.Errorf("installed in %s, want %s", goroot, want)
+	}
+	built := func() string {
+		t.Helper()
+		out, err := exec.Command(filepath.Join(goroot, "bin", "go")).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(out)
+	}
+	bootstrap := filepath.Join(cache, "scripttest", "go", "go1.23.1")
+	if got, want := built(), "devel-1 "+bootstrap+"\n"; got != want {
+		t.Errorf("built go printed %q, want %q", got, want)
+	}
+
+	// Tip is reused until it is updated
+	commit("devel-2")
+	if _, err := installGoTip(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := built(); !strings.HasPrefix(got, "devel-1 ") {
+		t.Errorf("reused go printed %q, want devel-1", got)
+	}
+	if _, err := installGoTip(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := built(); !strings.HasPrefix(got, "devel-2 ") {
+		t.Errorf("updated go printed %q, want devel-2", got)
+	}
+
+	// A failed build leaves a go command behind, which is not reused
+	commit("broken")
+	if _, err := installGoTip(true); err == nil {
+		t.Fatal("installing a tip that fails to build succeeded")
+	}
+	if tcs, _ := installedToolchains(); len(tcs) != 1 || tcs[0].name != "go1.23.1" {
+		t.Errorf("installed toolchains after a failed build = %v, want only go1.23.1", tcs)
+	}
+	commit("devel-3")
+	if _, err := installGoTip(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := built(); !strings.HasPrefix(got, "devel-3 ") {
+		t.Errorf("go rebuilt after a failure printed %q, want devel-3", got)
+	}
+}
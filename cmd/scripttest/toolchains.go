@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// runToolchain runs the toolchain command, which manages the Go toolchains
+// installed by -auto-go and test -go.
+func runToolchain(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("toolchain requires a subcommand: list, install, remove or which")
+	}
+	sub, args := args[0], args[1:]
+	switch sub {
+	case "list":
+		return listToolchains(os.Stdout)
+	case "install":
+		if len(args) == 0 {
+			args = []string{"auto"}
+		}
+		for _, name := range args {
+			if err := installToolchainCmd(os.Stdout, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "remove":
+		if len(args) == 0 {
+			return fmt.Errorf("toolchain remove requires the versions to remove")
+		}
+		for _, name := range args {
+			if err := removeToolchain(os.Stdout, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "which":
+		name := "auto"
+		if len(args) > 0 {
+			name = args[0]
+		}
+		goCmd, err := whichToolchain(name)
+		if err != nil {
+			return err
+		}
+		fmt.Println(goCmd)
+		return nil
+	default:
+		return fmt.Errorf("unknown toolchain subcommand %q (want list, install, remove or which)", sub)
+	}
+}
+
+// An installedToolchain is a Go toolchain installed under toolchainsDir.
+type installedToolchain struct {
+	name    string // such as go1.22.3 or tip
+	version string // such as 1.22.3 or tip
+	goroot  string
+}
+
+// installedToolchains returns the toolchains installed under toolchainsDir,
+// oldest first, with tip last. Interrupted downloads and builds are not
+// included.
+func installedToolchains() ([]installedToolchain, error) {
+	dir, err := toolchainsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var tcs []installedToolchain
+	for _, e := range entries {
+		goroot := filepath.Join(dir, e.Name())
+		if _, err := os.Stat(filepath.Join(goroot, "bin", exe("go"))); err != nil {
+			continue
+		}
+		switch version := strings.TrimPrefix(e.Name(), "go"); {
+		case e.Name() == "gotip":
+			if _, err := os.Stat(filepath.Join(goroot, tipBuilt)); err == nil {
+				tcs = append(tcs, installedToolchain{"tip", "tip", goroot})
+			}
+		case strings.HasPrefix(e.Name(), "go") && isGoRelease(version):
+			tcs = append(tcs, installedToolchain{e.Name(), version, goroot})
+		}
+	}
+	sort.Slice(tcs, func(i, j int) bool {
+		if tcs[i].version == "tip" || tcs[j].version == "tip" {
+			return tcs[j].version == "tip" && tcs[i].version != "tip"
+		}
+		return compareGoVersions(tcs[i].version, tcs[j].version) < 0
+	})
+	return tcs, nil
+}
+
+// matchToolchains returns the installed toolchains matching the toolchain
+// named name (see parseToolchain), oldest first: the release itself, every
+// release of a language version such as 1.22, or tip. For auto, it matches
+// the version the current module needs, or any version outside a module.
+// It also returns the version matched, such as 1.22, or "" for any.
+func matchToolchains(tcs []installedToolchain, name string) ([]installedToolchain, string, error) {
+	version, err := parseToolchain(name)
+	if err != nil {
+		return nil, "", err
+	}
+	switch version {
+	case "local":
+		return nil, "", fmt.Errorf("the local toolchain is not managed by scripttest")
+	case "auto":
+		if version, err = moduleGoVersion("."); err != nil {
+			return nil, "", err
+		}
+	}
+	var matches []installedToolchain
+	for _, tc := range tcs {
+		switch {
+		case version == "" && tc.version != "tip",
+			tc.version == version,
+			!isGoRelease(version) && tc.version != "tip" && goLanguage(tc.version) == version:
+			matches = append(matches, tc)
+		}
+	}
+	return matches, version, nil
+}
+
+// listToolchains prints the installed toolchains and the disk space they
+// use.
+func listToolchains(w io.Writer) error {
+	dir, err := toolchainsDir()
+	if err != nil {
+		return err
+	}
+	tcs, err := installedToolchains()
+	if err != nil {
+		return err
+	}
+	if len(tcs) == 0 {
+		fmt.Fprintf(w, "no Go toolchains installed in %s\n", dir)
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, tc := range tcs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", tc.name, formatSize(dirSize(tc.goroot)), tc.goroot)
+	}
+	// The total includes what interrupted downloads left behind
+	fmt.Fprintf(tw, "total\t%s\t%s\n", formatSize(dirSize(dir)), dir)
+	return tw.Flush()
+}
+
+// installToolchainCmd installs the toolchain named name and prints where.
+// Unlike test -go, it updates an installed tip.
+func installToolchainCmd(w io.Writer, name string) error {
+	var version, goroot string
+	var err error
+	if v, _ := parseToolchain(name); v == "tip" {
+		version = "tip"
+		goroot, err = installGoTip(true)
+	} else {
+		version, goroot, err = installToolchain(name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to install Go toolchain %s: %v", name, err)
+	}
+	if goroot == "" {
+		return fmt.Errorf("the local toolchain is not managed by scripttest")
+	}
+	fmt.Fprintf(w, "%s installed in %s (%s)\n", version, goroot, formatSize(dirSize(goroot)))
+	return nil
+}
+
+// removeToolchain removes the installed toolchains matching name (see
+// matchToolchains) and prints the space freed.
+func removeToolchain(w io.Writer, name string) error {
+	tcs, err := installedToolchains()
+	if err != nil {
+		return err
+	}
+	matches, version, err := matchToolchains(tcs, name)
+	if err != nil {
+		return err
+	}
+	// Outside a module, auto matches every release, which is no way to
+	// choose what to delete
+	if version == "" {
+		return fmt.Errorf("no go.mod requires a Go version here; name the toolchains to remove, as listed by scripttest toolchain list")
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("Go toolchain %s is not installed; see scripttest toolchain list", toolchainName(version))
+	}
+	for _, tc := range matches {
+		size := dirSize(tc.goroot)
+		if err := os.RemoveAll(tc.goroot); err != nil {
+			return fmt.Errorf("failed to remove %s: %v", tc.name, err)
+		}
+		fmt.Fprintf(w, "removed %s (%s)\n", tc.name, formatSize(size))
+	}
+	return nil
+}
+
+// whichToolchain returns the go command of the installed toolchain that
+// name (see matchToolchains) selects: the latest matching one. The local
+// toolchain is the go command on $PATH.
+func whichToolchain(name string) (string, error) {
+	if v, err := parseToolchain(name); err == nil && v == "local" {
+		goCmd, err := exec.LookPath("go")
+		if err != nil {
+			return "", fmt.Errorf("no go command on $PATH")
+		}
+		return goCmd, nil
+	}
+	tcs, err := installedToolchains()
+	if err != nil {
+		return "", err
+	}
+	matches, version, err := matchToolchains(tcs, name)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("Go toolchain %s is not installed; install it with scripttest toolchain install %s", toolchainName(version), name)
+	}
+	return filepath.Join(matches[len(matches)-1].goroot, "bin", exe("go")), nil
+}
+
+// toolchainName returns the name of the toolchain for a version returned
+// by matchToolchains.
+func toolchainName(version string) string {
+	switch version {
+	case "":
+		return "release"
+	case "tip":
+		return "tip"
+	}
+	return "go" + version
+}
+
+// dirSize returns the size of the files in dir, ignoring those it cannot
+// read.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// formatSize formats a size in bytes for people.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f kB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
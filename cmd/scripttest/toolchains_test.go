@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeToolchains installs fake toolchains with the given directory names
+// in a temporary cache directory and returns the toolchains directory.
+func fakeToolchains(t *testing.T, names ...string) string {
+	t.Helper()
+	cache := t.TempDir()
+	old := userCacheDir
+	userCacheDir = func() (string, error) { return cache, nil }
+	t.Cleanup(func() { userCacheDir = old })
+	dir, _ := toolchainsDir()
+	for _, name := range names {
+		bin := filepath.Join(dir, name, "bin")
+		if err := os.MkdirAll(bin, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeScript(t, bin, exe("go"), strings.Repeat("x", 2048))
+		if name == "gotip" {
+			writeScript(t, filepath.Join(dir, name), tipBuilt, "")
+		}
+	}
+	return dir
+}
+
+func TestListToolchains(t *testing.T) {
+	dir := fakeToolchains(t)
+	var out strings.Builder
+	if err := listToolchains(&out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "no Go toolchains installed in " + dir + "\n"; out.String() != want {
+		t.Errorf("listToolchains printed %q, want %q", out.String(), want)
+	}
+
+	dir = fakeToolchains(t, "gotip", "go1.22.10", "go1.21.13", "go1.22.2", "go1.23rc1")
+	// Interrupted downloads count towards disk usage only
+	if err := os.MkdirAll(filepath.Join(dir, ".download-123"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeScript(t, filepath.Join(dir, ".download-123"), "go.tar.gz", strings.Repeat("x", 1024))
+	out.Reset()
+	if err := listToolchains(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.ReplaceAll(`go1.21.13  2.0 kB   DIR/go1.21.13
+go1.22.2   2.0 kB   DIR/go1.22.2
+go1.22.10  2.0 kB   DIR/go1.22.10
+go1.23rc1  2.0 kB   DIR/go1.23rc1
+tip        2.0 kB   DIR/gotip
+total      11.0 kB  DIR
+`, "DIR", dir)
+	if out.String() != want {
+		t.Errorf("listToolchains printed:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestWhichToolchain(t *testing.T) {
+	dir := fakeToolchains(t, "gotip", "go1.22.10", "go1.22.2", "go1.21.13")
+	goCmd := func(name string) string { return filepath.Join(dir, name, "bin", exe("go")) }
+
+	// Outside a module, auto is the latest release
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for name, want := range map[string]string{
+		"1.22":           goCmd("go1.22.10"),
+		"go1.22.2":       goCmd("go1.22.2"),
+		"go1.21.13+auto": goCmd("go1.21.13"),
+		"tip":            goCmd("gotip"),
+		"auto":           goCmd("go1.22.10"),
+	} {
+		if got, err := whichToolchain(name); err != nil || got != want {
+			t.Errorf("whichToolchain(%s) = %s, %v, want %s", name, got, err, want)
+		}
+	}
+	if _, err := whichToolchain("1.23"); err == nil || !strings.Contains(err.Error(), "go1.23 is not installed; install it with scripttest toolchain install 1.23") {
+		t.Errorf("whichToolchain(1.23) error = %v", err)
+	}
+
+	// In a module, auto is the version it needs
+	writeScript(t, ".", "go.mod", "module m\n\ngo 1.21\n")
+	if got, err := whichToolchain("auto"); err != nil || got != goCmd("go1.21.13") {
+		t.Errorf("whichToolchain(auto) in a go 1.21 module = %s, %v", got, err)
+	}
+}
+
+func TestRemoveToolchain(t *testing.T) {
+	dir := fakeToolchains(t, "gotip", "go1.22.10", "go1.22.2", "go1.21.13")
+	var out strings.Builder
+	if err := removeToolchain(&out, "1.22"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "removed go1.22.2 (2.0 kB)\nremoved go1.22.10 (2.0 kB)\n"; out.String() != want {
+		t.Errorf("removeToolchain(1.22) printed %q, want %q", out.String(), want)
+	}
+	if err := removeToolchain(&out, "1.22"); err == nil || !strings.Contains(err.Error(), "go1.22 is not installed") {
+		t.Errorf("removing 1.22 again: error = %v", err)
+	}
+	if err := removeToolchain(&out, "local"); err == nil {
+		t.Error("removing the local toolchain succeeded")
+	}
+
+	// Outside a module, auto names no toolchain and removes nothing
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := removeToolchain(&out, "auto"); err == nil || !strings.Contains(err.Error(), "no go.mod requires a Go version here") {
+		t.Errorf("removing auto outside a module: error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("removing auto outside a module left %d toolchains, want 2", len(entries))
+	}
+
+	if err := removeToolchain(&out, "gotip"); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "go1.21.13" {
+		t.Errorf("after removing, toolchains directory has %v, want only go1.21.13", entries)
+	}
+}
+
+func TestInstallToolchainCmd(t *testing.T) {
+	serveRelease(t, "go1.23.1.test.tar.gz", tarGz(t, goEntries), "")
+	var out strings.Builder
+	if err := installToolchainCmd(&out, "go1.23"); err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := toolchainsDir()
+	if want := "go1.23.1 installed in " + filepath.Join(dir, "go1.23.1") + " ("; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("installToolchainCmd printed %q, want prefix %q", out.String(), want)
+	}
+	if err := installToolchainCmd(&out, "local"); err == nil {
+		t.Error("installing the local toolchain succeeded")
+	}
+}
@@ -3,19 +3,23 @@
 package commands
 
 import (
+	"github.com/tmc/scripttestutil/commands/docker"
 	"github.com/tmc/scripttestutil/commands/expect"
 	"rsc.io/script"
 )
 
 // RegisterAll adds all available command sets to the provided command map.
 // This is a convenience function for registering all command sets at once.
+// Like RegisterDocker, it requires removing the containers of the docker
+// commands: testscript does so after each script; other code running
+// scripts should call docker.Remove or docker.Wait.
 func RegisterAll(cmds map[string]script.Cmd) {
 	// Register each command set
 	RegisterExpect(cmds)
+	RegisterDocker(cmds)
 	
 	// Add more command sets here as they become available
 	// RegisterSSH(cmds)
-	// RegisterDocker(cmds)
 	// etc.
 }
 
@@ -30,4 +34,16 @@ func RegisterExpect(cmds map[string]script.Cmd) {
 	for name, cmd := range expectCmds {
 		cmds[name] = cmd
 	}
+}
+
+// RegisterDocker adds the docker command set to the provided command map.
+// These commands start containers, such as databases, for the duration of
+// a script and remove them when the script ends.
+// The removal happens in the background: unless the scripts are run with
+// testscript, call docker.Remove after each script or docker.Wait before the
+// test binary exits.
+func RegisterDocker(cmds map[string]script.Cmd) {
+	for name, cmd := range docker.Commands() {
+		cmds[name] = cmd
+	}
 }
\ No newline at end of file
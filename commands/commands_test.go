@@ -80,4 +80,11 @@ func TestCommandRegistration(t *testing.T) {
 	if _, ok := cmds["expect:send"]; !ok {
 		t.Error("expect:send command was not registered")
 	}
+	
+	// Verify that docker commands were registered
+	for _, name := range []string{"docker:run", "docker:exec", "docker:logs", "docker:stop", "docker:wait-healthy", "docker:cp"} {
+		if _, ok := cmds[name]; !ok {
+			t.Errorf("%s command was not registered", name)
+		}
+	}
 }
\ No newline at end of file
@@ -0,0 +1,257 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"rsc.io/script"
+)
+
+// fakeDocker is a docker command that logs its arguments to $DOCKER_LOG.
+// Containers report the health in $FAKE_DIR/health, and exec and logs
+// print their arguments.
+const fakeDocker = `#!/bin/sh
+echo "$@" >> "$DOCKER_LOG"
+case "$1" in
+run) echo 0123456789ab ;;
+port) echo "0.0.0.0:49153"; echo "[::]:49153" ;;
+inspect) cat "$FAKE_DIR/health" ;;
+exec) shift 2; echo "exec $@" ;;
+logs) echo "log line"; echo "log error" >&2 ;;
+stop) echo "$2" ;;
+cp) case "$2" in */missing) echo "no such file" >&2; exit 1 ;; esac ;;
+esac
+`
+
+// setup installs the fake docker command and returns the directory holding
+// its log and state.
+func setup(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker command is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "health"), []byte("running healthy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("DOCKER_LOG", filepath.Join(dir, "log"))
+	t.Setenv("FAKE_DIR", dir)
+	return dir
+}
+
+// runScript runs a script with the docker commands, waits for its
+// containers to be removed and returns the docker commands it ran.
+func runScript(t *testing.T, dir, text string) ([]string, error) {
+	t.Helper()
+	cmds := script.DefaultCmds()
+	for name, cmd := range Commands() {
+		cmds[name] = cmd
+	}
+	engine := &script.Engine{Cmds: cmds, Conds: script.DefaultConds(), Quiet: true}
+	s, err := script.NewState(context.Background(), t.TempDir(), os.Environ())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	err = engine.Execute(s, "test.txt", bufio.NewReader(strings.NewReader(text)), &log)
+	if closeErr := s.CloseAndWait(&log); err == nil {
+		err = closeErr
+	}
+	Wait()
+
+	data, readErr := os.ReadFile(filepath.Join(dir, "log"))
+	if readErr != nil && !os.IsNotExist(readErr) {
+		t.Fatal(readErr)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		lines = nil
+	}
+	return lines, err
+}
+
+func TestLifecycle(t *testing.T) {
+	dir := setup(t)
+	commands, err := runScript(t, dir, `
+docker:run -e POSTGRES_PASSWORD=test -p 5432 -health-cmd pg_isready db postgres:16
+env DOCKER_DB_PORT_5432
+stdout '=49153$'
+docker:wait-healthy db 5s
+docker:exec db psql -c 'select 1'
+stdout '^exec psql -c select 1$'
+docker:logs db
+stdout 'log line'
+stderr 'log error'
+docker:cp db:/etc/hosts hosts
+docker:stop db
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != 8 {
+		t.Fatalf("ran %d docker commands, want 8:\n%s", len(commands), strings.Join(commands, "\n"))
+	}
+
+	// Every command uses the same script-scoped container name
+	run := commands[0]
+	i := strings.Index(run, "--name ")
+	if i < 0 {
+		t.Fatalf("docker run without a container name: %s", run)
+	}
+	c := strings.Fields(run[i:])[1]
+	if !strings.HasPrefix(c, "scripttest-") || !strings.HasSuffix(c, "-db") {
+		t.Errorf("container name = %s, want scripttest-...-db", c)
+	}
+	want := []string{
+		"run -d -e POSTGRES_PASSWORD=test -p 5432 --health-cmd pg_isready --health-interval 1s --name " + c + " --label scripttest=1 postgres:16",
+		"port " + c + " 5432",
+		"inspect -f {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}} " + c,
+		"exec " + c + " psql -c select 1",
+		"logs " + c,
+		"cp " + c + ":/etc/hosts ",
+		"stop " + c,
+		"rm -f -v " + c,
+	}
+	for i, cmd := range commands {
+		if !strings.HasPrefix(cmd, want[i]) {
+			t.Errorf("command %d = %q, want %q", i, cmd, want[i])
+		}
+	}
+	if !strings.HasSuffix(commands[5], "/hosts") {
+		t.Errorf("docker cp destination not in the work directory: %s", commands[5])
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	dir := setup(t)
+	commands, err := runScript(t, dir, "docker:run -d --read-only -e A=b --network=host --init -p 8080:80 web nginx nginx -g 'daemon off;'\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) < 1 {
+		t.Fatal("docker run not run")
+	}
+	want := "run -d --read-only -e A=b --network=host --init -p 8080:80 --name "
+	if !strings.HasPrefix(commands[0], want) || !strings.HasSuffix(commands[0], " --label scripttest=1 nginx nginx -g daemon off;") {
+		t.Errorf("docker run = %q, want %q...nginx nginx -g daemon off;", commands[0], want)
+	}
+}
+
+func TestScriptScope(t *testing.T) {
+	dir := setup(t)
+	first, err := runScript(t, dir, "docker:run db postgres:16\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "log")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second script does not reuse the first script's container name
+	second, err := runScript(t, dir, "docker:run db postgres:16\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || len(second) != 2 {
+		t.Fatalf("first script ran %q, second ran %q", first, second)
+	}
+	if first[0] == second[0] {
+		t.Errorf("both scripts ran %q", first[0])
+	}
+	if !strings.HasPrefix(second[1], "rm -f -v ") || strings.Contains(second[1], first[1][len("rm -f -v "):]) {
+		t.Errorf("second script removed %q, want only its own container", second[1])
+	}
+}
+
+func TestRemove(t *testing.T) {
+	dir := setup(t)
+	cmds := script.DefaultCmds()
+	for name, cmd := range Commands() {
+		cmds[name] = cmd
+	}
+	engine := &script.Engine{Cmds: cmds, Conds: script.DefaultConds(), Quiet: true}
+	s, err := script.NewState(context.Background(), t.TempDir(), os.Environ())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log strings.Builder
+	if err := engine.Execute(s, "test.txt", bufio.NewReader(strings.NewReader("docker:run db postgres:16\n")), &log); err != nil {
+		t.Fatalf("%v\n%s", err, log.String())
+	}
+	if err := s.CloseAndWait(&log); err != nil {
+		t.Fatal(err)
+	}
+
+	// The containers are gone once Remove returns, without waiting for
+	// every script with Wait
+	if err := Remove(s); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "rm -f -v scripttest-") {
+		t.Errorf("docker commands after Remove:\n%s\nwant the container removed", data)
+	}
+
+	// Removing again does nothing
+	if err := Remove(s); err != nil {
+		t.Fatal(err)
+	}
+	Wait()
+	if again, _ := os.ReadFile(filepath.Join(dir, "log")); string(again) != string(data) {
+		t.Errorf("docker commands after removing again:\n%s", again)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	dir := setup(t)
+	if err := os.WriteFile(filepath.Join(dir, "health"), []byte("exited \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		script, want string
+	}{
+		{"docker:run db", "invalid usage"},
+		{"docker:run -x 1 db postgres", "invalid usage"},
+		{"docker:run --name=x db postgres", "invalid usage"},
+		{"docker:run --rm db postgres", "--rm is not supported"},
+		{"docker:run -e", "invalid usage"},
+		{"docker:run 'bad name' postgres", `invalid container name "bad name"`},
+		{"docker:run db postgres:16\ndocker:run db postgres:16", `container "db" already started by this script`},
+		{"docker:run db postgres:16\ndocker:wait-healthy db", "container db exited"},
+		{"docker:run db postgres:16\ndocker:wait-healthy db soon", "invalid timeout"},
+		{"docker:run db postgres:16\ndocker:cp missing db:/tmp", "no such file"},
+		{"docker:logs db", `no container "db" started by this script`},
+	}
+	for _, tt := range tests {
+		_, err := runScript(t, dir, tt.script+"\n")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("script:\n%s\nerror %v, want %q", tt.script, err, tt.want)
+		}
+	}
+}
+
+func TestWaitHealthyTimeout(t *testing.T) {
+	dir := setup(t)
+	if err := os.WriteFile(filepath.Join(dir, "health"), []byte("running starting\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(d time.Duration) { pollInterval = d }(pollInterval)
+	pollInterval = 10 * time.Millisecond
+	_, err := runScript(t, dir, "docker:run db postgres:16\ndocker:wait-healthy db 50ms\n")
+	if err == nil || !strings.Contains(err.Error(), "container db not ready after 50ms (starting)") {
+		t.Errorf("error %v, want timeout", err)
+	}
+}
@@ -12,7 +12,7 @@ The primary interface to scripttestutil is the 'scripttest' command-line tool, w
   - Scaffolds new test directories (scripttest scaffold)
   - Creates snapshots of command output (with snapshot command)
   - Supports running tests in Docker containers (scripttest -docker test)
-  - Can play back recorded snapshots (scripttest playback)
+  - Can play back recorded snapshots, asciicasts and typescripts (scripttest playback)
 
 # Writing Tests
 
@@ -44,7 +44,7 @@ Commands:
   - ! CMD - Assert command fails
   - exec CMD - Run command without shell interpretation
   - [condition] CMD - Run command only if condition is true
-  - snapshot [path] - Record/verify command output
+  - snapshot [path] - Record/verify command output (path is relative to the script's directory)
 
 Assertions:
   - stdout PATTERN - Standard output matches pattern
@@ -123,6 +123,12 @@ Run tests inside Docker containers using one of these approaches:
    scripttest -docker -docker-image=node:18 test
    ```
 
+   Containers are run with docker, podman or nerdctl, whichever is installed
+   first in that order, or the one named with -runtime:
+   ```
+   scripttest -docker -runtime=podman test
+   ```
+
 3. Embedding a Dockerfile in your test file:
    ```
    # Test in Docker
@@ -149,8 +155,25 @@ Run tests inside Docker containers using one of these approaches:
    RUN powershell -Command "Install-PackageProvider -Name NuGet -Force"
    ```
 
+   The most specific section for the target platform is used:
+   Dockerfile.<os>.<arch>, then Dockerfile.<os>, Dockerfile.<arch> and
+   Dockerfile. The target platform defaults to linux on the host's
+   architecture; select another with -docker-platform, which is also passed
+   to the build and run commands:
+   ```
+   scripttest -docker -docker-platform=windows/amd64 test
+   ```
+
+Each script runs in an image built from its own Dockerfile section, or from
+the default image if it has none. Images are tagged with a hash of their
+Dockerfile, so scripts that share a Dockerfile share an image and a container,
+and unchanged Dockerfiles reuse the build cache.
+
 The Docker container will:
-- Mount your test directory as /app in the container
+- Mount the same test harness used for local runs as /app, and the directory
+  of each script as /scripts/<n>, so that snapshots are updated on the host
+- Run exactly the matched scripts, so that scripttest -docker test testdata/foo.txt
+  runs only foo.txt
 - Pass through environment variables like UPDATE_SNAPSHOTS
 - Automatically clean up after test completion
 - Support snapshot creation and verification
@@ -4,6 +4,12 @@ go 1.22.0
 
 toolchain go1.23.1
 
-require rsc.io/script v0.0.2
-
-require golang.org/x/tools v0.14.0 // indirect
+require (
+	golang.org/x/image v0.18.0
+	golang.org/x/mod v0.17.0
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d
+	gopkg.in/yaml.v3 v3.0.1
+	rsc.io/script v0.0.2
+)
+
+require golang.org/x/text v0.16.0 // indirect
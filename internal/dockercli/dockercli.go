@@ -0,0 +1,74 @@
+// Package dockercli runs a docker-compatible command line interface for
+// the packages that start containers, services and commands/docker.
+//
+// The package only depends on the standard library so that its source can
+// be copied into the standalone test harness generated by the scripttest
+// command (see Source).
+package dockercli
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// A Command runs a docker-compatible command line interface.
+type Command struct {
+	Program string   // program to run, such as "docker"
+	Dir     string   // working directory, or "" for the current one
+	Env     []string // environment, or nil for the current one
+}
+
+// Run runs the program with args and returns its output, with its
+// standard error in the error if it fails.
+func (c Command) Run(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, c.Program, args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s: %v: %s", c.Program, args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s %s: %v", c.Program, args[0], err)
+	}
+	return string(out), nil
+}
+
+// WaitReady waits until container is ready: healthy if it has a health
+// check, and running otherwise. It checks every interval and fails if the
+// container exits or becomes unhealthy, or after timeout. Errors refer to
+// the container as name.
+func (c Command) WaitReady(ctx context.Context, container, name string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := c.Run(ctx, "inspect", "-f", "{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}", container)
+		if err != nil {
+			return err
+		}
+		status, health, _ := strings.Cut(strings.TrimSpace(out), " ")
+		switch {
+		case status == "exited" || status == "dead":
+			return fmt.Errorf("container %s %s", name, status)
+		case health == "unhealthy":
+			return fmt.Errorf("container %s is unhealthy", name)
+		case health == "healthy" || (health == "" && status == "running"):
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if health != "" {
+				status = health
+			}
+			return fmt.Errorf("container %s not ready after %v (%s)", name, timeout, status)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package scriptexec holds what the exec commands that replace the script
+// package's own share: finding and starting programs like script.Exec does,
+// and passing their output on as valid text while it is written.
+//
+// The package only depends on the standard library and rsc.io/script so
+// that its source can be copied into the standalone test harness generated
+// by the scripttest command (see Source).
+package scriptexec
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"rsc.io/script"
+)
+
+// WaitDelay is how long a program may keep running after it is
+// interrupted, as in script.DefaultCmds.
+const WaitDelay = 100 * time.Millisecond
+
+// Usage returns the usage of the script package's exec command.
+func Usage() script.CmdUsage {
+	return *script.Exec(nil, WaitDelay).Usage()
+}
+
+// Command returns a command that runs the program args[0] with the
+// arguments args[1:] in the script's working directory and environment,
+// set up like the script package's exec command does. It is interrupted
+// when the script's context is done.
+func Command(s *script.State, args ...string) (*exec.Cmd, error) {
+	if len(args) < 1 {
+		return nil, script.ErrUsage
+	}
+	path, err := LookPath(s, args[0])
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(s.Context(), path, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Dir = s.Getwd()
+	cmd.Env = s.Environ()
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = WaitDelay
+	return cmd, nil
+}
+
+// LookPath searches for command in the script's PATH, like the script
+// package's exec command does.
+func LookPath(s *script.State, command string) (string, error) {
+	if strings.ContainsAny(command, `/\`) {
+		return s.Path(command), nil
+	}
+	var exts []string
+	if runtime.GOOS == "windows" && filepath.Ext(command) == "" {
+		exts = strings.Split(strings.ToLower(os.Getenv("PATHEXT")), string(filepath.ListSeparator))
+	}
+	pathEnv, _ := s.LookupEnv("PATH")
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" {
+			continue
+		}
+		for _, ext := range append([]string{""}, exts...) {
+			file := filepath.Join(dir, command+ext)
+			info, err := os.Stat(file)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			if runtime.GOOS == "windows" || info.Mode().Perm()&0111 != 0 {
+				return file, nil
+			}
+		}
+	}
+	return "", &exec.Error{Name: command, Err: exec.ErrNotFound}
+}
+
+// A TextWriter passes what is written to it on to a function as text. A
+// trailing partial UTF-8 sequence is held back until the rest of it is
+// written, so that the function only sees whole characters. It is not
+// safe for concurrent use; the function must do its own locking.
+type TextWriter struct {
+	write   func(text string)
+	pending []byte
+}
+
+// NewTextWriter returns a TextWriter that passes text on to write.
+func NewTextWriter(write func(text string)) *TextWriter {
+	return &TextWriter{write: write}
+}
+
+func (w *TextWriter) Write(p []byte) (int, error) {
+	data := append(w.pending, p...)
+	n := len(data)
+	for i := n - 1; i >= 0 && i >= n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				n = i
+			}
+			break
+		}
+	}
+	w.pending = append([]byte(nil), data[n:]...)
+	if n > 0 {
+		w.write(string(data[:n]))
+	}
+	return len(p), nil
+}
+
+// Flush passes on any held back output, even though it is not valid text.
+func (w *TextWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.write(string(w.pending))
+		w.pending = nil
+	}
+}
@@ -0,0 +1,22 @@
+package scriptexec
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTextWriter(t *testing.T) {
+	var got []string
+	w := NewTextWriter(func(text string) { got = append(got, text) })
+	// "é" is 0xc3 0xa9 and "世" is 0xe4 0xb8 0x96
+	for _, p := range []string{"caf\xc3", "\xa9 ", "\xe4", "\xb8", "\x96!", "\xff", "\xe4\xb8"} {
+		if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", p, n, err)
+		}
+	}
+	w.Flush()
+	want := []string{"caf", "é ", "世!", "\xff", "\xe4\xb8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("text written = %q, want %q", got, want)
+	}
+}
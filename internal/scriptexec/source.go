@@ -0,0 +1,9 @@
+package scriptexec
+
+import "embed"
+
+// Source holds the Go source of this package. The scripttest command writes
+// it into the test harness it generates, which cannot import this module.
+//
+//go:embed scriptexec.go
+var Source embed.FS
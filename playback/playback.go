@@ -0,0 +1,284 @@
+// Package playback replays recorded terminal output. It reads scripttest
+// snapshots in either format, asciicast recordings, and typescripts
+// written by the util-linux script command along with their timing files.
+//
+// Output can be written all at once or with the pauses it was recorded
+// with, and output written to stderr can be colored red so that it stands
+// out when both streams go to the same terminal.
+package playback
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tmc/scripttestutil/asciicast"
+	"github.com/tmc/scripttestutil/snapshot"
+)
+
+// A Recording is recorded output.
+type Recording struct {
+	Width, Height int // terminal size, or 0 if unknown
+	Chunks        []Chunk
+}
+
+// A Chunk is a piece of output, as it was written.
+type Chunk struct {
+	Time   float64 // seconds since the recording started
+	Stderr bool
+	Data   string
+}
+
+// typescriptHeader starts the first line of a typescript, which is not
+// part of the recorded output.
+const typescriptHeader = "Script started on "
+
+// Load reads the recording in file, detecting its format. A typescript's
+// timing is read from timingFile if it is not empty, or otherwise from
+// file+".timing" if that exists; a typescript without timing is replayed
+// as a single chunk.
+func Load(file, timingFile string) (*Recording, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if timingFile == "" {
+		if _, err := os.Stat(file + ".timing"); err == nil {
+			timingFile = file + ".timing"
+		}
+	}
+	switch {
+	case timingFile != "":
+		timing, err := os.Open(timingFile)
+		if err != nil {
+			return nil, err
+		}
+		defer timing.Close()
+		rec, err := ReadTypescript(bytes.NewReader(data), timing)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", timingFile, err)
+		}
+		return rec, nil
+	case bytes.HasPrefix(data, []byte(typescriptHeader)):
+		return ReadTypescript(bytes.NewReader(data), nil)
+	case filepath.Ext(file) == ".cast" || isCast(data):
+		cast, err := asciicast.Read(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		return FromCast(cast), nil
+	}
+	snap, err := snapshot.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a snapshot, asciicast or typescript: %v", file, err)
+	}
+	return FromSnapshot(snap), nil
+}
+
+// isCast reports whether data starts with an asciicast header: a line
+// holding a JSON object with a version.
+func isCast(data []byte) bool {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	var header struct {
+		Version *int `json:"version"`
+	}
+	return json.Unmarshal(line, &header) == nil && header.Version != nil
+}
+
+// FromSnapshot returns the output recorded in a snapshot. Without timing,
+// stdout is followed by stderr, both at time 0.
+func FromSnapshot(snap *snapshot.Snapshot) *Recording {
+	rec := new(Recording)
+	if snap.Terminal != nil {
+		rec.Width, rec.Height = snap.Terminal.Width, snap.Terminal.Height
+	}
+	if len(snap.Chunks) > 0 {
+		for _, c := range snap.Chunks {
+			rec.Chunks = append(rec.Chunks, Chunk{Time: c.Time, Stderr: c.Stream == "stderr", Data: c.Data})
+		}
+		return rec
+	}
+	if snap.Stdout != "" {
+		rec.Chunks = append(rec.Chunks, Chunk{Data: snap.Stdout})
+	}
+	if snap.Stderr != "" {
+		rec.Chunks = append(rec.Chunks, Chunk{Stderr: true, Data: snap.Stderr})
+	}
+	return rec
+}
+
+// FromCast returns the output events of an asciicast recording.
+func FromCast(cast *asciicast.Cast) *Recording {
+	rec := &Recording{Width: cast.Header.Width, Height: cast.Header.Height}
+	for _, e := range cast.Events {
+		if e.Type == asciicast.Output {
+			rec.Chunks = append(rec.Chunks, Chunk{Time: e.Time, Data: e.Data})
+		}
+	}
+	return rec
+}
+
+// ReadTypescript reads a typescript written by the util-linux script
+// command and, if timing is not nil, its timing file.
+//
+// Both timing formats are supported. In the classic format, each line
+// holds the delay before a chunk of output, in seconds, and its length in
+// bytes. In the advanced format, written by script -B and script -T with
+// --logging-format=advanced, each line starts with an entry type: O for
+// output, I for input, H for header information such as the terminal size
+// and S for signals. Input is skipped, so a typescript recording input
+// must hold both streams, as script -B writes.
+func ReadTypescript(typescript, timing io.Reader) (*Recording, error) {
+	data, err := io.ReadAll(typescript)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte(typescriptHeader)) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	rec := new(Recording)
+	if timing == nil {
+		// Without timing, drop the footer script writes on exit, which
+		// starts on a new line
+		if i := bytes.LastIndex(data, []byte("\nScript done on ")); i >= 0 {
+			data = data[:i]
+		}
+		if len(data) > 0 {
+			rec.Chunks = []Chunk{{Data: string(data)}}
+		}
+		return rec, nil
+	}
+
+	var t float64
+	off := 0
+	sc := bufio.NewScanner(timing)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		kind := "O"
+		if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+			kind, fields = fields[0], fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: invalid timing entry %q", n, sc.Text())
+		}
+		delay, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || delay < 0 {
+			return nil, fmt.Errorf("line %d: invalid delay %q", n, fields[0])
+		}
+		t += delay
+		switch kind {
+		case "O", "I":
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("line %d: invalid length %q", n, fields[1])
+			}
+			if off+size > len(data) {
+				return nil, fmt.Errorf("line %d: timing goes past the end of the typescript", n)
+			}
+			if kind == "O" && size > 0 {
+				rec.Chunks = append(rec.Chunks, Chunk{Time: t, Data: string(data[off : off+size])})
+			}
+			off += size
+		case "H":
+			if len(fields) < 3 {
+				continue
+			}
+			v, _ := strconv.Atoi(fields[2])
+			switch fields[1] {
+			case "COLUMNS":
+				rec.Width = v
+			case "LINES":
+				rec.Height = v
+			}
+		case "S":
+			// Signals, such as SIGWINCH on a resize, do not affect output
+		default:
+			return nil, fmt.Errorf("line %d: unknown timing entry type %q", n, kind)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Options control how a recording is played.
+type Options struct {
+	// Timed replays output with the pauses it was recorded with, instead
+	// of writing it all at once.
+	Timed bool
+
+	// Speed divides the pauses of timed playback. Zero means 1.
+	Speed float64
+
+	// IdleTimeLimit limits each pause of timed playback to this many
+	// seconds, before Speed applies. Zero means no limit.
+	IdleTimeLimit float64
+
+	// ColorStderr colors output written to stderr red.
+	ColorStderr bool
+}
+
+// sleep is time.Sleep, replaced in tests.
+var sleep = time.Sleep
+
+// Play writes the output of rec to stdout and stderr.
+func Play(rec *Recording, stdout, stderr io.Writer, opts Options) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	var last float64
+	for _, c := range rec.Chunks {
+		if opts.Timed {
+			pause := c.Time - last
+			if opts.IdleTimeLimit > 0 {
+				pause = min(pause, opts.IdleTimeLimit)
+			}
+			if pause > 0 {
+				sleep(time.Duration(pause / speed * float64(time.Second)))
+			}
+		}
+		last = c.Time
+		w, data := stdout, c.Data
+		if c.Stderr {
+			w = stderr
+			if opts.ColorStderr {
+				data = colorRed(data)
+			}
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// colorRed colors text red, resetting the color before each newline so
+// that it does not spread to other output.
+func colorRed(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		body, ok := strings.CutSuffix(line, "\n")
+		if body == "" {
+			continue
+		}
+		lines[i] = "\033[31m" + body + "\033[0m"
+		if ok {
+			lines[i] += "\n"
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,140 @@
+package playback
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"snap.json":  `{"stderr": "oops\n", "stdout": "hello\n"}`,
+		"snap.linux": `{"stderr": "", "stdout": "no extension\n"}`,
+		"snap.txtar": "-- stdout --\nhi\n-- stderr --\n",
+		"timed.json": `{"stderr": "e", "stdout": "ab", "terminal": {"width": 100, "height": 30},
+			"chunks": [{"time": 0.1, "stream": "stdout", "data": "a"}, {"time": 0.2, "stream": "stderr", "data": "e"}, {"time": 0.5, "stream": "stdout", "data": "b"}]}`,
+		"rec.cast":      "{\"version\": 2, \"width\": 40, \"height\": 10}\n[0.5, \"o\", \"$ \"]\n[1, \"i\", \"l\"]\n[1.5, \"o\", \"ls\\r\\n\"]\n",
+		"rec.log":       "{\"version\": 2, \"width\": 40, \"height\": 10}\n[0.5, \"o\", \"x\"]\n",
+		"typescript":    "Script started on 2024-01-01 10:00:00+00:00 [TERM=\"xterm\"]\n$ ls\r\nfile\r\n\nScript done on 2024-01-01 10:00:01+00:00 [COMMAND_EXIT_CODE=\"0\"]\n",
+		"ts":            "Script started on 2024-01-01 10:00:00+00:00\n$ ls\r\nfile\r\n",
+		"ts.timing":     "0.25 6\n1.5 6\n",
+		"ts2":           "$ ls\r\nfile\r\n",
+		"custom-timing": "0.5 12\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file, timing string
+		want         *Recording
+	}{
+		{"snap.json", "", &Recording{Chunks: []Chunk{{Data: "hello\n"}, {Stderr: true, Data: "oops\n"}}}},
+		{"snap.linux", "", &Recording{Chunks: []Chunk{{Data: "no extension\n"}}}},
+		{"snap.txtar", "", &Recording{Chunks: []Chunk{{Data: "hi\n"}}}},
+		{"timed.json", "", &Recording{Width: 100, Height: 30, Chunks: []Chunk{{0.1, false, "a"}, {0.2, true, "e"}, {0.5, false, "b"}}}},
+		{"rec.cast", "", &Recording{Width: 40, Height: 10, Chunks: []Chunk{{0.5, false, "$ "}, {1.5, false, "ls\r\n"}}}},
+		{"rec.log", "", &Recording{Width: 40, Height: 10, Chunks: []Chunk{{0.5, false, "x"}}}},
+		{"typescript", "", &Recording{Chunks: []Chunk{{Data: "$ ls\r\nfile\r\n"}}}},
+		{"ts", "", &Recording{Chunks: []Chunk{{0.25, false, "$ ls\r\n"}, {1.75, false, "file\r\n"}}}},
+		{"ts2", "custom-timing", &Recording{Chunks: []Chunk{{0.5, false, "$ ls\r\nfile\r\n"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			timing := tt.timing
+			if timing != "" {
+				timing = filepath.Join(dir, timing)
+			}
+			got, err := Load(filepath.Join(dir, tt.file), timing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load(%s) = %+v, want %+v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTypescriptAdvanced(t *testing.T) {
+	// Written by script -B log -T timing: the log holds the typed input
+	// "ls\r" between the prompt and its echo
+	typescript := "Script started on 2024-01-01 10:00:00+00:00\n$ ls\rls\r\nfile\r\n"
+	timing := strings.Join([]string{
+		"H 0.000000 START_TIME 2024-01-01 10:00:00+00:00",
+		"H 0.000000 COLUMNS 120",
+		"H 0.000000 LINES 40",
+		"O 0.250000 2",
+		"I 0.500000 3",
+		"O 0.250000 4",
+		"S 0.500000 SIGWINCH ROWS=40 COLS=100",
+		"O 0.500000 6",
+	}, "\n")
+	got, err := ReadTypescript(strings.NewReader(typescript), strings.NewReader(timing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Recording{Width: 120, Height: 40, Chunks: []Chunk{{0.25, false, "$ "}, {1, false, "ls\r\n"}, {2, false, "file\r\n"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadTypescript = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadTypescriptInvalid(t *testing.T) {
+	tests := []struct {
+		timing, want string
+	}{
+		{"0.5\n", "line 1: invalid timing entry"},
+		{"x 1\n", "line 1: invalid timing entry"},
+		{"-1 2\n", "line 1: invalid delay"},
+		{"0.1 2\n0.1 x\n", "line 2: invalid length"},
+		{"0.1 100\n", "line 1: timing goes past the end"},
+		{"Z 0.1 2\n", `line 1: unknown timing entry type "Z"`},
+	}
+	for _, tt := range tests {
+		_, err := ReadTypescript(strings.NewReader("output"), strings.NewReader(tt.timing))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ReadTypescript with timing %q: error %v, want %q", tt.timing, err, tt.want)
+		}
+	}
+}
+
+func TestPlay(t *testing.T) {
+	var pauses []time.Duration
+	sleep = func(d time.Duration) { pauses = append(pauses, d) }
+	defer func() { sleep = time.Sleep }()
+
+	rec := &Recording{Chunks: []Chunk{{0.5, false, "a\n"}, {1.5, true, "err\nmore\n"}, {6.5, false, "b"}}}
+	tests := []struct {
+		name           string
+		opts           Options
+		stdout, stderr string
+		pauses         []time.Duration
+	}{
+		{"untimed", Options{}, "a\nb", "err\nmore\n", nil},
+		{"timed", Options{Timed: true}, "a\nb", "err\nmore\n", []time.Duration{500 * time.Millisecond, time.Second, 5 * time.Second}},
+		{"speed and limit", Options{Timed: true, Speed: 2, IdleTimeLimit: 2}, "a\nb", "err\nmore\n", []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second}},
+		{"color", Options{ColorStderr: true}, "a\nb", "\033[31merr\033[0m\n\033[31mmore\033[0m\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pauses = nil
+			var stdout, stderr strings.Builder
+			if err := Play(rec, &stdout, &stderr, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if stdout.String() != tt.stdout || stderr.String() != tt.stderr {
+				t.Errorf("output = %q, %q, want %q, %q", stdout.String(), stderr.String(), tt.stdout, tt.stderr)
+			}
+			if !reflect.DeepEqual(pauses, tt.pauses) {
+				t.Errorf("pauses = %v, want %v", pauses, tt.pauses)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package services starts the containers a test script depends on, as
+// declared in a services.yaml section of the script, and stops them when
+// the script is done.
+//
+// The services.yaml section uses a subset of the Compose file format:
+//
+//	-- services.yaml --
+//	services:
+//	  db:
+//	    image: postgres:16
+//	    ports: ["5432"]
+//	    environment:
+//	      POSTGRES_PASSWORD: test
+//	    healthcheck:
+//	      test: ["CMD", "pg_isready", "-U", "postgres"]
+//	      interval: 1s
+//
+// The script sees the address of each service's first published port as
+// $SERVICE_<NAME>_ADDR (see Group.Env).
+//
+// The package only depends on the standard library, gopkg.in/yaml.v3,
+// golang.org/x/tools/txtar and internal/dockercli so that its source can be
+// copied, with that of internal/dockercli, into the standalone test harness
+// generated by the scripttest command (see Source).
+package services
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/tools/txtar"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the script section declaring services.
+const FileName = "services.yaml"
+
+// A Service is a container a script depends on.
+type Service struct {
+	Name        string
+	Image       string
+	Command     []string          // overrides the image's command, if set
+	Ports       []string          // ports to publish, as [[host-ip:]host-port:]port[/protocol]
+	Environment map[string]string // environment variables of the container
+	Healthcheck *Healthcheck      // overrides the image's health check, if set
+}
+
+// A Healthcheck is a command reporting whether a service is ready.
+type Healthcheck struct {
+	Test        []string // command, in exec form as ["CMD", ...] or ["CMD-SHELL", cmd]
+	Interval    string   // time between checks, such as 1s
+	Timeout     string   // time a check may take
+	Retries     int      // failed checks before the service is unhealthy
+	StartPeriod string   // time to start before failed checks count
+}
+
+// file is the structure of a services.yaml file.
+type file struct {
+	Services map[string]struct {
+		Image       string      `yaml:"image"`
+		Command     command     `yaml:"command"`
+		Ports       []string    `yaml:"ports"`
+		Environment environment `yaml:"environment"`
+		Healthcheck *struct {
+			Test        healthTest `yaml:"test"`
+			Interval    string     `yaml:"interval"`
+			Timeout     string     `yaml:"timeout"`
+			Retries     int        `yaml:"retries"`
+			StartPeriod string     `yaml:"start_period"`
+			Disable     bool       `yaml:"disable"`
+		} `yaml:"healthcheck"`
+	} `yaml:"services"`
+}
+
+// Parse parses a services.yaml file, returning its services sorted by name.
+func Parse(data []byte) ([]*Service, error) {
+	var f file
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid %s: %v", FileName, err)
+	}
+
+	var services []*Service
+	for name, s := range f.Services {
+		if !validName(name) {
+			return nil, fmt.Errorf("invalid %s: invalid service name %q", FileName, name)
+		}
+		if s.Image == "" {
+			return nil, fmt.Errorf("invalid %s: service %s has no image", FileName, name)
+		}
+		svc := &Service{
+			Name:        name,
+			Image:       s.Image,
+			Command:     s.Command,
+			Ports:       s.Ports,
+			Environment: s.Environment,
+		}
+		if hc := s.Healthcheck; hc != nil {
+			test := []string(hc.Test)
+			if hc.Disable {
+				test = []string{"NONE"}
+			}
+			for _, d := range []string{hc.Interval, hc.Timeout, hc.StartPeriod} {
+				if _, err := time.ParseDuration(d); d != "" && err != nil {
+					return nil, fmt.Errorf("invalid %s: service %s: %v", FileName, name, err)
+				}
+			}
+			svc.Healthcheck = &Healthcheck{
+				Test:        test,
+				Interval:    hc.Interval,
+				Timeout:     hc.Timeout,
+				Retries:     hc.Retries,
+				StartPeriod: hc.StartPeriod,
+			}
+		}
+		services = append(services, svc)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// FromArchive returns the services declared in a script's services.yaml
+// section, or nil if it has none.
+func FromArchive(a *txtar.Archive) ([]*Service, error) {
+	for _, f := range a.Files {
+		if f.Name == FileName {
+			return Parse(f.Data)
+		}
+	}
+	return nil, nil
+}
+
+// validName reports whether name is a valid service name: letters, digits,
+// '_', '.' and '-', starting with a letter or digit.
+func validName(name string) bool {
+	for i, r := range name {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case i > 0 && (r == '_' || r == '.' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// A command is a container's command, which may be written as a list or
+// as a string of space-separated arguments.
+type command []string
+
+func (c *command) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*c = strings.Fields(n.Value)
+		return nil
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// A healthTest is a health check command, which may be written as a list
+// in exec form or as a string run by the shell.
+type healthTest []string
+
+func (t *healthTest) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*t = []string{"CMD-SHELL", n.Value}
+		return nil
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// environment holds environment variables, which may be written as a map
+// or as a list of NAME=value strings.
+type environment map[string]string
+
+func (e *environment) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.SequenceNode {
+		var list []string
+		if err := n.Decode(&list); err != nil {
+			return err
+		}
+		*e = make(environment)
+		for _, kv := range list {
+			name, value, _ := strings.Cut(kv, "=")
+			(*e)[name] = value
+		}
+		return nil
+	}
+	var m map[string]string
+	if err := n.Decode(&m); err != nil {
+		return err
+	}
+	*e = m
+	return nil
+}
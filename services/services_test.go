@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/tools/txtar"
+)
+
+func TestParse(t *testing.T) {
+	services, err := Parse([]byte(`
+services:
+  db:
+    image: postgres:16
+    ports: [5432, "127.0.0.1:8080:80/tcp"]
+    environment:
+      POSTGRES_PASSWORD: test
+      PGPORT: 5432
+    healthcheck:
+      test: ["CMD", "pg_isready", "-U", "postgres"]
+      interval: 1s
+      retries: 30
+  cache:
+    image: redis:7
+    command: redis-server --appendonly yes
+    environment:
+      - MODE=test
+    healthcheck:
+      test: redis-cli ping | grep PONG
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*Service{
+		{
+			Name:        "cache",
+			Image:       "redis:7",
+			Command:     []string{"redis-server", "--appendonly", "yes"},
+			Environment: map[string]string{"MODE": "test"},
+			Healthcheck: &Healthcheck{Test: []string{"CMD-SHELL", "redis-cli ping | grep PONG"}},
+		},
+		{
+			Name:        "db",
+			Image:       "postgres:16",
+			Ports:       []string{"5432", "127.0.0.1:8080:80/tcp"},
+			Environment: map[string]string{"POSTGRES_PASSWORD": "test", "PGPORT": "5432"},
+			Healthcheck: &Healthcheck{
+				Test:     []string{"CMD", "pg_isready", "-U", "postgres"},
+				Interval: "1s",
+				Retries:  30,
+			},
+		},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Errorf("Parse:\ngot  %+v\nwant %+v", services, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		yaml, want string
+	}{
+		{"services:\n  db:\n    ports: [5432]\n", "service db has no image"},
+		{"services:\n  -db:\n    image: postgres\n", `invalid service name "-db"`},
+		{"services:\n  db:\n    image: postgres\n    volumes: [data]\n", "field volumes not found"},
+		{"services:\n  db:\n    image: postgres\n    healthcheck:\n      interval: often\n", `invalid duration "often"`},
+		{"services: [db]\n", "invalid services.yaml"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.yaml))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.yaml, err, tt.want)
+		}
+	}
+}
+
+func TestFromArchive(t *testing.T) {
+	services, err := FromArchive(txtar.Parse([]byte("exec true\n-- data.txt --\nhello\n")))
+	if err != nil || services != nil {
+		t.Errorf("FromArchive without services.yaml = %v, %v", services, err)
+	}
+	services, err = FromArchive(txtar.Parse([]byte("exec true\n-- services.yaml --\nservices:\n  db:\n    image: postgres\n")))
+	if err != nil || len(services) != 1 || services[0].Name != "db" {
+		t.Errorf("FromArchive = %v, %v, want the db service", services, err)
+	}
+}
+
+// fakeDocker is a docker command that logs its arguments to $DOCKER_LOG.
+// Containers report the status in $FAKE_DIR/status, and docker run fails
+// for images named "broken".
+const fakeDocker = `#!/bin/sh
+echo "$@" >> "$DOCKER_LOG"
+case "$1" in
+run) for arg; do [ "$arg" != broken ] || { echo "pull access denied" >&2; exit 1; }; done; echo 0123456789ab ;;
+port) echo "0.0.0.0:4915${3%%/*}"; echo "[::]:4915${3%%/*}" ;;
+inspect) cat "$FAKE_DIR/status" ;;
+esac
+`
+
+// setup installs the fake docker command with the given container status
+// and returns the file it logs commands to.
+func setup(t *testing.T, status string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker command is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "status"), []byte(status+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("DOCKER_LOG", filepath.Join(dir, "log"))
+	t.Setenv("FAKE_DIR", dir)
+	return filepath.Join(dir, "log")
+}
+
+func readLog(t *testing.T, file string) []string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestStart(t *testing.T) {
+	log := setup(t, "running healthy")
+	services := []*Service{
+		{
+			Name:        "db",
+			Image:       "postgres:16",
+			Ports:       []string{"1", "8080:2/udp"},
+			Environment: map[string]string{"B": "2", "A": "1"},
+			Healthcheck: &Healthcheck{Test: []string{"CMD", "pg_isready", "-d", "my db"}, Interval: "1s", Retries: 3},
+		},
+		{Name: "web-api", Image: "nginx", Command: []string{"nginx", "-g", "daemon off;"}},
+	}
+	g, err := Start(context.Background(), services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := g.Env()
+	if err := g.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := strings.TrimSuffix(strings.TrimPrefix(env[0], "SERVICE_DB_CONTAINER="), "db")
+	if !strings.HasPrefix(prefix, "scripttest-svc-") {
+		t.Fatalf("Env()[0] = %q, want the db container", env[0])
+	}
+	wantEnv := []string{
+		"SERVICE_DB_CONTAINER=" + prefix + "db",
+		"SERVICE_DB_HOST=127.0.0.1",
+		"SERVICE_DB_PORT=49151",
+		"SERVICE_DB_ADDR=127.0.0.1:49151",
+		"SERVICE_DB_PORT_1=49151",
+		"SERVICE_DB_PORT_2=49152",
+		"SERVICE_WEB_API_CONTAINER=" + prefix + "web-api",
+	}
+	if !reflect.DeepEqual(env, wantEnv) {
+		t.Errorf("Env:\n%s\nwant:\n%s", strings.Join(env, "\n"), strings.Join(wantEnv, "\n"))
+	}
+
+	inspect := "inspect -f {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}} "
+	want := []string{
+		"run -d --name " + prefix + "db --label scripttest=1 -p 1 -p 8080:2/udp -e A=1 -e B=2 --health-cmd pg_isready -d 'my db' --health-interval 1s --health-retries 3 postgres:16",
+		"port " + prefix + "db 1",
+		"port " + prefix + "db 2/udp",
+		"run -d --name " + prefix + "web-api --label scripttest=1 nginx nginx -g daemon off;",
+		inspect + prefix + "db",
+		inspect + prefix + "web-api",
+		"rm -f -v " + prefix + "db " + prefix + "web-api",
+	}
+	if got := readLog(t, log); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	log := setup(t, "running healthy")
+	_, err := Start(context.Background(), []*Service{
+		{Name: "db", Image: "postgres:16"},
+		{Name: "web", Image: "broken"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "failed to start service web") || !strings.Contains(err.Error(), "pull access denied") {
+		t.Fatalf("Start error = %v, want failure of web", err)
+	}
+	got := readLog(t, log)
+	if last := got[len(got)-1]; !strings.HasPrefix(last, "rm -f -v ") || !strings.HasSuffix(last, "-web") {
+		t.Errorf("last command = %q, want removal of the started services", last)
+	}
+}
+
+func TestStartNotReady(t *testing.T) {
+	defer func(d, timeout time.Duration) { pollInterval, startTimeout = d, timeout }(pollInterval, startTimeout)
+	pollInterval, startTimeout = time.Millisecond, 20*time.Millisecond
+
+	tests := []struct {
+		status, want string
+	}{
+		{"exited ", "service db did not start: container db exited"},
+		{"running unhealthy", "service db did not start: container db is unhealthy"},
+		{"running starting", "service db did not start: container db not ready after 20ms (starting)"},
+	}
+	for _, tt := range tests {
+		setup(t, tt.status)
+		_, err := Start(context.Background(), []*Service{{Name: "db", Image: "postgres:16"}})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("status %q: Start error = %v, want %q", tt.status, err, tt.want)
+		}
+	}
+}
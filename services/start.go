@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/scripttestutil/internal/dockercli"
+)
+
+// program is the container command line interface services are run with.
+var program = "docker"
+
+// pollInterval is how often Start checks whether services are ready.
+var pollInterval = 500 * time.Millisecond
+
+// startTimeout limits how long Start waits for a service to be ready.
+var startTimeout = 2 * time.Minute
+
+var (
+	mu     sync.Mutex
+	nextID int
+)
+
+// A Group is a set of running services.
+type Group struct {
+	running []*running
+}
+
+// running is a started service.
+type running struct {
+	svc       *Service
+	container string
+	addrs     []string // host addresses of the published ports, in order
+}
+
+// Start starts services and waits until they are ready: healthy if they
+// have a health check, and running otherwise. If a service fails to start,
+// Start stops the services it started and returns an error.
+func Start(ctx context.Context, services []*Service) (*Group, error) {
+	// The svc part keeps the names apart from those of the containers
+	// scripts start with the docker commands, which count separately
+	mu.Lock()
+	nextID++
+	prefix := fmt.Sprintf("scripttest-svc-%d-%d-", os.Getpid(), nextID)
+	mu.Unlock()
+
+	g := new(Group)
+	for _, svc := range services {
+		r := &running{svc: svc, container: prefix + svc.Name}
+		g.running = append(g.running, r)
+		if err := r.start(ctx); err != nil {
+			g.Stop()
+			return nil, fmt.Errorf("failed to start service %s: %v", svc.Name, err)
+		}
+	}
+
+	// Wait for the services together, since they start concurrently
+	for _, r := range g.running {
+		if err := r.wait(ctx); err != nil {
+			g.Stop()
+			return nil, fmt.Errorf("service %s did not start: %v", r.svc.Name, err)
+		}
+	}
+	return g, nil
+}
+
+// Stop stops and removes the services.
+func (g *Group) Stop() error {
+	if len(g.running) == 0 {
+		return nil
+	}
+	args := []string{"rm", "-f", "-v"}
+	for _, r := range g.running {
+		args = append(args, r.container)
+	}
+	g.running = nil
+	if _, err := docker(context.Background(), args...); err != nil {
+		return fmt.Errorf("failed to stop services: %v", err)
+	}
+	return nil
+}
+
+// Env returns environment variables describing the services, as
+// NAME=value pairs. For a service named db, they are:
+//
+//	SERVICE_DB_HOST       host the service's ports are published on
+//	SERVICE_DB_PORT       host port of the first published port
+//	SERVICE_DB_ADDR       host:port of the first published port
+//	SERVICE_DB_PORT_5432  host port of each published port, by container port
+//	SERVICE_DB_CONTAINER  name of the service's container
+//
+// Characters other than letters and digits in service names are replaced
+// by underscores.
+func (g *Group) Env() []string {
+	var env []string
+	for _, r := range g.running {
+		prefix := envPrefix(r.svc.Name)
+		env = append(env, prefix+"_CONTAINER="+r.container)
+		for i, addr := range r.addrs {
+			host, port := splitAddr(addr)
+			if i == 0 {
+				env = append(env,
+					prefix+"_HOST="+host,
+					prefix+"_PORT="+port,
+					prefix+"_ADDR="+addr,
+				)
+			}
+			env = append(env, prefix+"_PORT_"+containerPort(r.svc.Ports[i])+"="+port)
+		}
+	}
+	return env
+}
+
+// start creates and starts the service's container and looks up the host
+// ports its ports are published on.
+func (r *running) start(ctx context.Context) error {
+	args := []string{"run", "-d", "--name", r.container, "--label", "scripttest=1"}
+	for _, p := range r.svc.Ports {
+		args = append(args, "-p", p)
+	}
+	var names []string
+	for name := range r.svc.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "-e", name+"="+r.svc.Environment[name])
+	}
+	if hc := r.svc.Healthcheck; hc != nil {
+		args = append(args, healthArgs(hc)...)
+	}
+	args = append(args, r.svc.Image)
+	args = append(args, r.svc.Command...)
+	if _, err := docker(ctx, args...); err != nil {
+		return err
+	}
+
+	for _, p := range r.svc.Ports {
+		out, err := docker(ctx, "port", r.container, containerPort(p)+protocol(p))
+		if err != nil {
+			return err
+		}
+		line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+		r.addrs = append(r.addrs, hostAddr(line))
+	}
+	return nil
+}
+
+// wait waits until the service is ready.
+func (r *running) wait(ctx context.Context) error {
+	return dockercli.Command{Program: program}.WaitReady(ctx, r.container, r.svc.Name, startTimeout, pollInterval)
+}
+
+// healthArgs returns the docker run arguments setting a health check.
+func healthArgs(hc *Healthcheck) []string {
+	var args []string
+	switch {
+	case len(hc.Test) == 0:
+		// Keep the image's check, with the given timing
+	case hc.Test[0] == "NONE":
+		return []string{"--no-healthcheck"}
+	case hc.Test[0] == "CMD-SHELL":
+		args = append(args, "--health-cmd", strings.Join(hc.Test[1:], " "))
+	default:
+		// docker run takes a shell command, so quote the arguments
+		cmd := hc.Test
+		if cmd[0] == "CMD" {
+			cmd = cmd[1:]
+		}
+		var quoted []string
+		for _, arg := range cmd {
+			quoted = append(quoted, shellQuote(arg))
+		}
+		args = append(args, "--health-cmd", strings.Join(quoted, " "))
+	}
+	if hc.Interval != "" {
+		args = append(args, "--health-interval", hc.Interval)
+	}
+	if hc.Timeout != "" {
+		args = append(args, "--health-timeout", hc.Timeout)
+	}
+	if hc.Retries > 0 {
+		args = append(args, "--health-retries", fmt.Sprint(hc.Retries))
+	}
+	if hc.StartPeriod != "" {
+		args = append(args, "--health-start-period", hc.StartPeriod)
+	}
+	return args
+}
+
+// shellQuote quotes s for the shell if needed.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+.,/:@%") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// containerPort returns the container port of a port specification,
+// [[host-ip:]host-port:]port[/protocol], without the protocol.
+func containerPort(spec string) string {
+	port := spec[strings.LastIndex(spec, ":")+1:]
+	port, _, _ = strings.Cut(port, "/")
+	return port
+}
+
+// protocol returns the protocol suffix of a port specification, such as
+// "/udp", or "" for the default.
+func protocol(spec string) string {
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		return spec[i:]
+	}
+	return ""
+}
+
+// hostAddr returns the address to connect to for an address printed by
+// docker port, such as 0.0.0.0:49153, replacing wildcard hosts with the
+// loopback address.
+func hostAddr(addr string) string {
+	host, port := splitAddr(addr)
+	switch host {
+	case "", "0.0.0.0", "::", "[::]":
+		host = "127.0.0.1"
+	}
+	return host + ":" + port
+}
+
+// splitAddr splits an address into its host and port.
+func splitAddr(addr string) (host, port string) {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return addr, ""
+	}
+	return addr[:i], addr[i+1:]
+}
+
+// envPrefix returns the prefix of the environment variables describing a
+// service.
+func envPrefix(name string) string {
+	b := []byte(strings.ToUpper(name))
+	for i, c := range b {
+		if !('A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	return "SERVICE_" + string(b)
+}
+
+// docker runs the docker command with args and returns its output, with
+// its standard error in the error if it fails.
+func docker(ctx context.Context, args ...string) (string, error) {
+	return dockercli.Command{Program: program}.Run(ctx, args...)
+}
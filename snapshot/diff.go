@@ -0,0 +1,339 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// Diff returns a line-based unified diff from old to new, labelled with
+// oldName and newName. It returns the empty string if old and new are equal.
+func Diff(oldName, old, newName, new string) string {
+	if old == new {
+		return ""
+	}
+	a, b := splitLines(old), splitLines(new)
+	ops := diffLines(a, b)
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(ops) {
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(h.aStart, h.aLen), hunkRange(h.bStart, h.bLen))
+		for _, op := range h.ops {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.text)
+			buf.WriteByte('\n')
+			if op.noEOL {
+				buf.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	return buf.String()
+}
+
+// truncateDiff limits diff to about maxLines lines. Rather than cutting
+// it off after the first change, it shortens each hunk alike, so that
+// every change is shown with the context before it, and notes how many
+// lines were omitted where they were.
+func truncateDiff(diff string, maxLines int) string {
+	lines := strings.SplitAfter(diff, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) <= maxLines {
+		return diff
+	}
+	blocks := diffBlocks(lines)
+	per := max(maxLines/len(blocks), 2*diffContext+2)
+
+	var buf strings.Builder
+	used := 0
+	for i, block := range blocks {
+		if used >= maxLines {
+			rest := 0
+			for _, b := range blocks[i:] {
+				rest += len(b)
+			}
+			fmt.Fprintf(&buf, "... (%d more lines)\n", rest)
+			break
+		}
+		keep := min(len(block), per, maxLines-used)
+		buf.WriteString(strings.Join(block[:keep], ""))
+		if keep < len(block) {
+			fmt.Fprintf(&buf, "... (%d more lines)\n", len(block)-keep)
+		}
+		used += keep
+	}
+	return buf.String()
+}
+
+// diffBlocks splits the lines of a diff into its hunks, each starting with
+// its @@ line, and the runs of lines between them, such as file headers.
+func diffBlocks(lines []string) [][]string {
+	var blocks [][]string
+	for i := 0; i < len(lines); {
+		start := i
+		if aLen, bLen, ok := parseHunkHeader(lines[i]); ok {
+			// A hunk holds the lines its header counts, each possibly
+			// followed by a no newline marker
+			for i++; i < len(lines) && (aLen > 0 || bLen > 0 || strings.HasPrefix(lines[i], "\\")); i++ {
+				switch lines[i][0] {
+				case ' ':
+					aLen, bLen = aLen-1, bLen-1
+				case '-':
+					aLen--
+				case '+':
+					bLen--
+				}
+			}
+		} else {
+			for i++; i < len(lines); i++ {
+				if _, _, ok := parseHunkHeader(lines[i]); ok {
+					break
+				}
+			}
+		}
+		blocks = append(blocks, lines[start:i])
+	}
+	return blocks
+}
+
+// parseHunkHeader parses a hunk's "@@ -a,n +b,m @@" line, returning the
+// number of old and new lines in the hunk.
+func parseHunkHeader(line string) (aLen, bLen int, ok bool) {
+	var a, b string
+	if _, err := fmt.Sscanf(line, "@@ -%s +%s @@", &a, &b); err != nil {
+		return 0, 0, false
+	}
+	count := func(r string) (int, bool) {
+		_, n, found := strings.Cut(r, ",")
+		if !found {
+			return 1, true
+		}
+		var c int
+		_, err := fmt.Sscanf(n, "%d", &c)
+		return c, err == nil
+	}
+	aLen, aok := count(a)
+	bLen, bok := count(b)
+	return aLen, bLen, aok && bok
+}
+
+// line is a single line of text. noEOL records that the final line of the
+// text had no trailing newline, so that adding one shows up in the diff.
+type line struct {
+	text  string
+	noEOL bool
+}
+
+func splitLines(s string) []line {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, "\n")
+	noEOL := true
+	if parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+		noEOL = false
+	}
+	lines := make([]line, len(parts))
+	for i, p := range parts {
+		lines[i] = line{text: p}
+	}
+	lines[len(lines)-1].noEOL = noEOL
+	return lines
+}
+
+// diffOp is a single line of an edit script: ' ' for an unchanged line,
+// '-' for a line only in the old text and '+' for a line only in the new text.
+type diffOp struct {
+	kind  byte
+	text  string
+	noEOL bool
+	a, b  int // line indexes in the old and new text before this op
+}
+
+// maxEditCost bounds the number of insertions and deletions diffLines
+// searches for. Saving the frontier of each round takes O(D²) memory and
+// the search O((N+M)·D) time, so beyond this the lines that differ are
+// reported as deleted and inserted wholesale.
+const maxEditCost = 1000
+
+// diffLines computes an edit script from a to b: a shortest one using
+// Myers' algorithm, unless that takes more than maxEditCost edits.
+func diffLines(a, b []line) []diffOp {
+	// Lines common to both ends need no search
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	var ops []diffOp
+	for i := 0; i < pre; i++ {
+		ops = append(ops, diffOp{kind: ' ', text: a[i].text, noEOL: a[i].noEOL, a: i, b: i})
+	}
+	am, bm := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	mid := myers(am, bm)
+	if mid == nil && (len(am) > 0 || len(bm) > 0) {
+		mid = replaceAll(am, bm)
+	}
+	for _, op := range mid {
+		op.a, op.b = op.a+pre, op.b+pre
+		ops = append(ops, op)
+	}
+	for i := len(a) - suf; i < len(a); i++ {
+		j := i - len(a) + len(b)
+		ops = append(ops, diffOp{kind: ' ', text: a[i].text, noEOL: a[i].noEOL, a: i, b: j})
+	}
+	return ops
+}
+
+// replaceAll returns the edit script deleting all of a and inserting all
+// of b.
+func replaceAll(a, b []line) []diffOp {
+	var ops []diffOp
+	for i, l := range a {
+		ops = append(ops, diffOp{kind: '-', text: l.text, noEOL: l.noEOL, a: i, b: 0})
+	}
+	for j, l := range b {
+		ops = append(ops, diffOp{kind: '+', text: l.text, noEOL: l.noEOL, a: len(a), b: j})
+	}
+	return ops
+}
+
+// myers computes a shortest edit script from a to b using Myers'
+// algorithm, or returns nil if it takes more than maxEditCost edits.
+func myers(a, b []line) []diffOp {
+	n, m := len(a), len(b)
+	// v[off+k] is the furthest x reached on diagonal k, for k from -n-m-1
+	// to n+m+1
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	// trace[d] holds the frontier before round d, for diagonals -d-1 to
+	// d+1, the only ones round d reads
+	var trace [][]int
+	for d := 0; d <= n+m && d <= maxEditCost; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, d)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrack walks the saved Myers frontiers backwards to recover the edit script.
+// trace[d] holds the frontier before round d, and the search ended in round d.
+func backtrack(trace [][]int, a, b []line, d int) []diffOp {
+	x, y := len(a), len(b)
+	var ops []diffOp
+	for ; d >= 0; d-- {
+		frontier := trace[d]
+		v := func(k int) int { return frontier[k+d+1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v(k-1) < v(k+1)) {
+			prevK = k + 1
+		}
+		prevX := v(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, diffOp{kind: ' ', text: a[x].text, noEOL: a[x].noEOL, a: x, b: y})
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{kind: '+', text: b[prevY].text, noEOL: b[prevY].noEOL, a: prevX, b: prevY})
+			} else {
+				ops = append(ops, diffOp{kind: '-', text: a[prevX].text, noEOL: a[prevX].noEOL, a: prevX, b: prevY})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// hunk is a group of changes with surrounding context.
+type hunk struct {
+	aStart, aLen int
+	bStart, bLen int
+	ops          []diffOp
+}
+
+// hunks groups the edit script into hunks, merging changes whose context overlaps.
+func hunks(ops []diffOp) []hunk {
+	var hs []hunk
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		// Extend the hunk until a run of unchanged lines is long enough to split on.
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end += min(diffContext, run-end)
+				break
+			}
+			end = run
+		}
+
+		h := hunk{aStart: ops[start].a, bStart: ops[start].b, ops: ops[start:end]}
+		for _, op := range h.ops {
+			if op.kind != '+' {
+				h.aLen++
+			}
+			if op.kind != '-' {
+				h.bLen++
+			}
+		}
+		hs = append(hs, h)
+		i = end
+	}
+	return hs
+}
+
+// hunkRange formats a unified diff range for a hunk starting at the 0-based line start.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
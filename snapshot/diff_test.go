@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "change",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "from empty",
+			old:  "",
+			new:  "x\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "to empty",
+			old:  "x\ny\n",
+			new:  "",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-x\n-y\n",
+		},
+		{
+			name: "missing newline",
+			old:  "a\n",
+			new:  "a",
+			want: "--- old\n+++ new\n@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "1\nTWO\n3\n4\n5\n6\n7\n8\n9\n10\n11\nTWELVE\n",
+			want: "--- old\n+++ new\n" +
+				"@@ -1,5 +1,5 @@\n 1\n-2\n+TWO\n 3\n 4\n 5\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+TWELVE\n",
+		},
+		{
+			name: "merged hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n",
+			new:  "ONE\n2\n3\n4\n5\n6\nSEVEN\n",
+			want: "--- old\n+++ new\n@@ -1,7 +1,7 @@\n-1\n+ONE\n 2\n 3\n 4\n 5\n 6\n-7\n+SEVEN\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Diff("old", tt.old, "new", tt.new); got != tt.want {
+				t.Errorf("Diff() =\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	diff := strings.Repeat("+line\n", 10)
+	if got := truncateDiff(diff, 10); got != diff {
+		t.Errorf("truncateDiff() changed a diff within the limit:\n%s", got)
+	}
+	want := strings.Repeat("+line\n", 4) + "... (6 more lines)\n"
+	if got := truncateDiff(diff, 4); got != want {
+		t.Errorf("truncateDiff() =\n%s\nwant:\n%s", got, want)
+	}
+
+	// A long change early on does not hide a later one
+	var old, new strings.Builder
+	for i := 1; i <= 200; i++ {
+		fmt.Fprintf(&old, "%d\n", i)
+		if 10 <= i && i < 40 || i == 190 {
+			fmt.Fprintf(&new, "changed %d\n", i)
+		} else {
+			fmt.Fprintf(&new, "%d\n", i)
+		}
+	}
+	diff = Diff("old", old.String(), "new", new.String())
+	got := truncateDiff(diff, 40)
+	for _, want := range []string{
+		"--- old\n+++ new\n@@ -7,36 +7,36 @@\n 7\n 8\n 9\n-10\n",
+		"... (54 more lines)\n",
+		"@@ -187,7 +187,7 @@\n 187\n 188\n 189\n-190\n+changed 190\n 191\n 192\n 193\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("truncateDiff() =\n%s\nwant it to contain:\n%s", got, want)
+		}
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	// Completely different texts take the most edits to compare
+	var old, new strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&old, "old %d\n", i)
+		fmt.Fprintf(&new, "new %d\n", i)
+	}
+	diff := Diff("old", "same\n"+old.String()+"end\n", "new", "same\n"+new.String()+"end\n")
+	if !strings.HasPrefix(diff, "--- old\n+++ new\n@@ -1,50002 +1,50002 @@\n same\n-old 0\n") {
+		t.Errorf("Diff() starts with:\n%s", diff[:min(len(diff), 200)])
+	}
+	if n := strings.Count(diff, "\n-old "); n != 50000 {
+		t.Errorf("Diff() deleted %d lines, want 50000", n)
+	}
+	if n := strings.Count(diff, "\n+new "); n != 50000 {
+		t.Errorf("Diff() inserted %d lines, want 50000", n)
+	}
+	if !strings.HasSuffix(diff, "+new 49999\n end\n") {
+		t.Errorf("Diff() ends with:\n%s", diff[max(0, len(diff)-200):])
+	}
+}
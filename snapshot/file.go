@@ -0,0 +1,281 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/tools/txtar"
+)
+
+// Snapshot file formats.
+const (
+	// FormatJSON stores a snapshot as a JSON object with "stdout" and
+	// "stderr" keys, in a .json file. It is the default.
+	FormatJSON = "json"
+
+	// FormatTxtar stores a snapshot as a txtar archive with stdout and
+	// stderr sections, in a .txtar file. Output is stored verbatim, so
+	// changes read naturally in version control diffs.
+	FormatTxtar = "txtar"
+
+	// FormatInline stores snapshots in sections of the script's own txtar
+	// archive (see InlinePrefix), so that a script and its expected output
+	// are reviewed together.
+	FormatInline = "inline"
+)
+
+// A Snapshot is the recorded output of a command.
+type Snapshot struct {
+	// Fields are ordered to match the key order of snapshots written
+	// before this type existed, when they were encoded from a map.
+	Stderr string `json:"stderr"`
+	Stdout string `json:"stdout"`
+
+	// Terminal and Chunks record how the output was written, when
+	// snapshots are recorded with timing (see Config.Timing), so that it
+	// can be played back with its original pacing. They are not compared.
+	Terminal *Terminal `json:"terminal,omitempty"`
+	Chunks   []Chunk   `json:"chunks,omitempty"`
+}
+
+// Ext returns the file extension of snapshots stored in format, or "" if
+// the format is unknown. An empty format selects FormatJSON.
+func Ext(format string) string {
+	switch format {
+	case "", FormatJSON:
+		return ".json"
+	case FormatTxtar:
+		return ".txtar"
+	}
+	return ""
+}
+
+// FormatOf returns the format of the snapshot file name, based on its
+// extension.
+func FormatOf(name string) string {
+	if filepath.Ext(name) == ".txtar" {
+		return FormatTxtar
+	}
+	return FormatJSON
+}
+
+// Marshal encodes snap in the given snapshot file format.
+func Marshal(snap *Snapshot, format string) ([]byte, error) {
+	switch format {
+	case "", FormatJSON:
+		// Don't escape <, > and & so placeholders stay readable
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(snap); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case FormatTxtar:
+		// The comment lists the flags needed to decode each section,
+		// as "section flag,...".
+		a := new(txtar.Archive)
+		var comment bytes.Buffer
+		for _, sec := range []struct{ name, data string }{
+			{"stdout", snap.Stdout},
+			{"stderr", snap.Stderr},
+		} {
+			data, flags := encodeSection([]byte(sec.data))
+			if len(flags) > 0 {
+				fmt.Fprintf(&comment, "%s %s\n", sec.name, strings.Join(flags, ","))
+			}
+			a.Files = append(a.Files, txtar.File{Name: sec.name, Data: data})
+		}
+		if snap.Terminal != nil || len(snap.Chunks) > 0 {
+			a.Files = append(a.Files, txtar.File{Name: "timing", Data: encodeTiming(snap.Terminal, snap.Chunks)})
+		}
+		a.Comment = comment.Bytes()
+		return txtar.Format(a), nil
+	}
+	return nil, fmt.Errorf("unknown snapshot format %q", format)
+}
+
+// Unmarshal decodes a snapshot file in either format.
+func Unmarshal(data []byte) (*Snapshot, error) {
+	snap := new(Snapshot)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		if err := json.Unmarshal(data, snap); err != nil {
+			return nil, err
+		}
+		return snap, nil
+	}
+
+	a := txtar.Parse(data)
+	flags := make(map[string][]string)
+	for i, line := range strings.Split(strings.TrimSuffix(string(a.Comment), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing flags", i+1)
+		}
+		flags[name] = strings.Split(list, ",")
+	}
+	for _, f := range a.Files {
+		data := f.Data
+		for _, flag := range flags[f.Name] {
+			var err error
+			if data, err = decodeSection(data, flag); err != nil {
+				return nil, fmt.Errorf("section %s: %v", f.Name, err)
+			}
+		}
+		switch f.Name {
+		case "stdout":
+			snap.Stdout = string(data)
+		case "stderr":
+			snap.Stderr = string(data)
+		case "timing":
+			var err error
+			if snap.Terminal, snap.Chunks, err = decodeTiming(data); err != nil {
+				return nil, fmt.Errorf("section timing: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected section %q", f.Name)
+		}
+	}
+	return snap, nil
+}
+
+// ReadFile reads the snapshot file name, in either format.
+func ReadFile(name string) (*Snapshot, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	snap, err := Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot format in %s: %v", name, err)
+	}
+	return snap, nil
+}
+
+// WriteFile writes snap to the snapshot file name, in the format
+// selected by its extension.
+func WriteFile(name string, snap *Snapshot) error {
+	data, err := Marshal(snap, FormatOf(name))
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	return os.WriteFile(name, data, 0644)
+}
+
+// encodeSection returns data in a form that can be stored in a txtar
+// section, along with the flags decodeSection needs to restore it:
+// "noeol" when data does not end in a newline, which txtar would add, and
+// "base64" when data is not text (see isText).
+func encodeSection(data []byte) ([]byte, []string) {
+	switch {
+	case !isText(data):
+		return []byte(base64.StdEncoding.EncodeToString(data) + "\n"), []string{"base64"}
+	case len(data) > 0 && data[len(data)-1] != '\n':
+		return append(data[:len(data):len(data)], '\n'), []string{"noeol"}
+	}
+	return data, nil
+}
+
+// decodeSection reverses the encoding indicated by one flag returned by
+// encodeSection.
+func decodeSection(data []byte, flag string) ([]byte, error) {
+	switch flag {
+	case "noeol":
+		return bytes.TrimSuffix(data, []byte("\n")), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+	return nil, fmt.Errorf("unknown flag %q", flag)
+}
+
+// isText reports whether data can be stored as text in a txtar section:
+// valid UTF-8 without NUL bytes or lines that look like section markers.
+func isText(data []byte) bool {
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0 {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "-- ") && strings.HasSuffix(line, " --") {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffCandidate reports the differences between the snapshot file and its
+// candidate (see Candidate). A missing snapshot file is treated as empty.
+// For an InlineFile, the snapshot is the inline one in the script.
+func DiffCandidate(file string) (string, error) {
+	if script, section, ok := ParseInlineFile(file); ok {
+		got, err := ReadFile(Candidate(file))
+		if err != nil {
+			return "", err
+		}
+		a, err := txtar.ParseFile(script)
+		if err != nil {
+			return "", err
+		}
+		want := readInline(a, section)
+		return Diff("stdout (snapshot)", want.Stdout, "stdout (new)", got.Stdout) +
+			Diff("stderr (snapshot)", want.Stderr, "stderr (new)", got.Stderr), nil
+	}
+
+	if strings.HasSuffix(file, TreeExt) {
+		got, err := ReadTreeFile(Candidate(file))
+		if err != nil {
+			return "", err
+		}
+		want, err := ReadTreeFile(file)
+		if os.IsNotExist(err) {
+			want = new(Tree)
+		} else if err != nil {
+			return "", err
+		}
+		return DiffTree("snapshot", want, "new", got), nil
+	}
+
+	got, err := ReadFile(Candidate(file))
+	if err != nil {
+		return "", err
+	}
+	want, err := ReadFile(file)
+	if os.IsNotExist(err) {
+		want = new(Snapshot)
+	} else if err != nil {
+		return "", err
+	}
+	return Diff("stdout (snapshot)", want.Stdout, "stdout (new)", got.Stdout) +
+		Diff("stderr (snapshot)", want.Stderr, "stderr (new)", got.Stderr), nil
+}
+
+// AcceptCandidate replaces the snapshot file with its candidate (see
+// Candidate) and removes the snapshot's artifacts. For an InlineFile, the
+// candidate is written into the script's inline snapshot.
+func AcceptCandidate(file string) error {
+	script, section, ok := ParseInlineFile(file)
+	if !ok {
+		if err := os.Rename(Candidate(file), file); err != nil {
+			return err
+		}
+		return RemoveArtifacts(file)
+	}
+	got, err := ReadFile(Candidate(file))
+	if err != nil {
+		return err
+	}
+	if err := writeInline(script, section, got); err != nil {
+		return err
+	}
+	return RemoveArtifacts(file)
+}
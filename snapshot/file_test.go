@@ -0,0 +1,52 @@
+package snapshot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalFormats(t *testing.T) {
+	snaps := []*Snapshot{
+		{Stdout: "hello\n", Stderr: ""},
+		{Stdout: "no newline", Stderr: "warning: <x> & y\n"},
+		{Stdout: "-- stdout --\n", Stderr: "\x00binary"},
+		{
+			Stdout:   "a\nb\n",
+			Stderr:   "warn\n",
+			Terminal: &Terminal{Width: 100, Height: 30},
+			Chunks: []Chunk{
+				{Time: 0.001, Stream: "stdout", Data: "a\n"},
+				{Time: 0.25, Stream: "stderr", Data: "warn\n"},
+				{Time: 0.5, Stream: "stdout", Data: "b\n"},
+			},
+		},
+	}
+	for _, format := range []string{FormatJSON, FormatTxtar} {
+		for _, snap := range snaps {
+			data, err := Marshal(snap, format)
+			if err != nil {
+				t.Fatalf("Marshal(%+v, %s): %v", snap, format, err)
+			}
+			got, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v\n%s", format, err, data)
+			}
+			if !reflect.DeepEqual(got, snap) {
+				t.Errorf("%s round trip = %+v, want %+v\nencoded:\n%s", format, got, snap, data)
+			}
+		}
+	}
+
+	data, err := Marshal(&Snapshot{Stdout: "line 1\nline 2\n", Stderr: "oops"}, FormatTxtar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "stderr noeol\n-- stdout --\nline 1\nline 2\n-- stderr --\noops\n"
+	if string(data) != want {
+		t.Errorf("txtar snapshot =\n%s\nwant:\n%s", data, want)
+	}
+
+	if _, err := Marshal(&Snapshot{}, "yaml"); err == nil {
+		t.Error("Marshal with unknown format: expected error")
+	}
+}
@@ -0,0 +1,84 @@
+package snapshot
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"rsc.io/script"
+)
+
+// Filter replaces every match of Pattern in snapshot output with Replacement.
+// Replacement may refer to submatches as described in regexp.Regexp.Expand.
+type Filter struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// NewFilter compiles pattern and returns a Filter that replaces its matches with replacement.
+func NewFilter(pattern, replacement string) (Filter, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Filter{}, fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+	}
+	return Filter{Pattern: re, Replacement: replacement}, nil
+}
+
+// Apply returns s with the filter applied.
+func (f Filter) Apply(s string) string {
+	if f.Pattern == nil {
+		return s
+	}
+	return f.Pattern.ReplaceAllString(s, f.Replacement)
+}
+
+// applyFilters applies filters to s in order.
+func applyFilters(filters []Filter, s string) string {
+	for _, f := range filters {
+		s = f.Apply(s)
+	}
+	return s
+}
+
+// placeholderVars lists the environment variables whose values are replaced
+// with a $NAME placeholder by normalizePaths.
+var placeholderVars = []string{"WORK", "HOME", tempEnvName()}
+
+// normalizePaths replaces the script's work directory, home directory and
+// temporary directory in s with placeholders. Longer values are replaced
+// first so that $TMPDIR, which usually lives under $WORK, wins.
+func normalizePaths(s *script.State, output string) string {
+	type placeholder struct{ value, name string }
+	var ps []placeholder
+	for _, name := range placeholderVars {
+		v, ok := s.LookupEnv(name)
+		if !ok || v == "" || v == string(filepath.Separator) {
+			continue
+		}
+		ps = append(ps, placeholder{v, "$" + name})
+		// Also match the resolved path, as on macOS where /var is a symlink to /private/var
+		if real, err := filepath.EvalSymlinks(v); err == nil && real != v {
+			ps = append(ps, placeholder{real, "$" + name})
+		}
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return len(ps[i].value) > len(ps[j].value) })
+
+	for _, p := range ps {
+		output = strings.ReplaceAll(output, p.value, p.name)
+	}
+	return output
+}
+
+// tempEnvName returns the name of the environment variable holding the
+// temporary directory, matching rsc.io/script/scripttest.
+func tempEnvName() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "TMP"
+	default:
+		return "TMPDIR"
+	}
+}
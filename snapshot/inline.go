@@ -0,0 +1,136 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// InlinePrefix is the name prefix of the sections of a script's txtar
+// archive that hold inline snapshots. The stdout of the snapshot name is
+// stored in the section __snapshot__/name and its stderr, if any, in
+// __snapshot__/name.stderr.
+const InlinePrefix = "__snapshot__/"
+
+// InlineExt is the extension of the files InlineFile returns.
+const InlineExt = ".inline"
+
+// InlineFile returns the file that stands for the inline snapshot section
+// of the script file in its snapshot directory (see Dir), such as
+// testdata/__snapshots__/hello.txt#greeting.inline. The file itself is
+// never written: it names the candidate and diff files of a mismatching
+// inline snapshot (see Candidate), which "scripttest snapshots review"
+// writes back into the script when accepted (see AcceptCandidate).
+func InlineFile(script, section string) string {
+	return filepath.Join(Dir(script), filepath.Base(script)+"#"+section+InlineExt)
+}
+
+// ParseInlineFile returns the script file and section an InlineFile stands
+// for, and reports whether file is one.
+func ParseInlineFile(file string) (script, section string, ok bool) {
+	if filepath.Ext(file) != InlineExt {
+		return "", "", false
+	}
+	name, section, ok := strings.Cut(strings.TrimSuffix(filepath.Base(file), InlineExt), "#")
+	if !ok {
+		return "", "", false
+	}
+	return filepath.Join(filepath.Dir(filepath.Dir(file)), name), section, true
+}
+
+// StripInline returns a copy of a without its inline snapshot sections, so
+// that they are not extracted into the script's work directory.
+func StripInline(a *txtar.Archive) *txtar.Archive {
+	b := &txtar.Archive{Comment: a.Comment}
+	for _, f := range a.Files {
+		if !strings.HasPrefix(f.Name, InlinePrefix) {
+			b.Files = append(b.Files, f)
+		}
+	}
+	return b
+}
+
+// inlineSection returns the inline snapshot section for name in the script
+// archive a: the variant for the current GOOS (name.<goos>) if it exists
+// or goosOnly is set, and name otherwise. It also reports whether the
+// section exists.
+func inlineSection(a *txtar.Archive, name string, goosOnly bool) (string, bool) {
+	variant := name + "." + runtime.GOOS
+	if _, ok := findSection(a, InlinePrefix+variant); ok || goosOnly {
+		return variant, ok
+	}
+	_, ok := findSection(a, InlinePrefix+name)
+	return name, ok
+}
+
+// findSection returns the index of the named section of a.
+func findSection(a *txtar.Archive, name string) (int, bool) {
+	for i, f := range a.Files {
+		if f.Name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// readInline returns the inline snapshot stored in section of a.
+func readInline(a *txtar.Archive, section string) *Snapshot {
+	snap := new(Snapshot)
+	if i, ok := findSection(a, InlinePrefix+section); ok {
+		snap.Stdout = string(a.Files[i].Data)
+	}
+	if i, ok := findSection(a, InlinePrefix+section+".stderr"); ok {
+		snap.Stderr = string(a.Files[i].Data)
+	}
+	return snap
+}
+
+// writeInline stores snap in section of the script file's archive,
+// rewriting the script in place.
+func writeInline(script, section string, snap *Snapshot) error {
+	for _, out := range []struct{ name, data string }{{"stdout", snap.Stdout}, {"stderr", snap.Stderr}} {
+		if out.data == "" {
+			continue
+		}
+		if !strings.HasSuffix(out.data, "\n") {
+			return fmt.Errorf("%s cannot be stored inline: it does not end in a newline", out.name)
+		}
+		if !isText([]byte(out.data)) {
+			return fmt.Errorf("%s cannot be stored inline: it is binary or contains txtar section markers", out.name)
+		}
+	}
+
+	// Write through symbolic links into the script's source tree
+	if real, err := filepath.EvalSymlinks(script); err == nil {
+		script = real
+	}
+	info, err := os.Stat(script)
+	if err != nil {
+		return err
+	}
+	a, err := txtar.ParseFile(script)
+	if err != nil {
+		return err
+	}
+	setSection(a, InlinePrefix+section, snap.Stdout, true)
+	setSection(a, InlinePrefix+section+".stderr", snap.Stderr, snap.Stderr != "")
+	return os.WriteFile(script, txtar.Format(a), info.Mode().Perm())
+}
+
+// setSection sets the data of the named section of a, appending it if it
+// does not exist. If keep is false, the section is removed instead.
+func setSection(a *txtar.Archive, name, data string, keep bool) {
+	i, ok := findSection(a, name)
+	switch {
+	case ok && keep:
+		a.Files[i].Data = []byte(data)
+	case ok:
+		a.Files = append(a.Files[:i], a.Files[i+1:]...)
+	case keep:
+		a.Files = append(a.Files, txtar.File{Name: name, Data: []byte(data)})
+	}
+}
@@ -0,0 +1,208 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+	"rsc.io/script"
+)
+
+type scriptKey struct{}
+
+// WithScript returns a copy of ctx recording file as the path of the script
+// being run. Pass it to script.NewState so that the snapshot commands can
+// name snapshots after the script and store them next to it.
+func WithScript(ctx context.Context, file string) context.Context {
+	return context.WithValue(ctx, scriptKey{}, file)
+}
+
+// NewState returns the state to run the script file in, in the directory
+// workdir, with the environment env, set up the way scripttest.Test does:
+// $WORK is workdir, the temporary directory is $WORK/tmp, and the files in
+// the script's archive are extracted into $WORK, except for its inline
+// snapshots. The state records file (see WithScript). NewState also returns
+// the script's archive, whose comment is the script itself.
+func NewState(ctx context.Context, workdir, file string, env []string) (*script.State, *txtar.Archive, error) {
+	a, err := txtar.ParseFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := script.NewState(WithScript(ctx, file), workdir, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	work := s.Getwd()
+	if err := s.Setenv("WORK", work); err != nil {
+		return nil, nil, err
+	}
+	tmp := filepath.Join(work, "tmp")
+	if err := os.MkdirAll(tmp, 0777); err != nil {
+		return nil, nil, err
+	}
+	tmpEnv := "TMPDIR"
+	if runtime.GOOS == "windows" {
+		tmpEnv = "TMP"
+	}
+	if err := s.Setenv(tmpEnv, tmp); err != nil {
+		return nil, nil, err
+	}
+	if err := s.ExtractFiles(StripInline(a)); err != nil {
+		return nil, nil, err
+	}
+	return s, a, nil
+}
+
+// scriptFile returns the path of the running script, or "" if it is unknown.
+func scriptFile(s *script.State) string {
+	file, _ := s.Context().Value(scriptKey{}).(string)
+	return file
+}
+
+// DefaultName returns the name of the n'th (1-based) unnamed snapshot taken
+// by the script file: the script's base name without extension, followed by
+// "-n" for every snapshot after the first.
+func DefaultName(file string, n int) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	if n > 1 {
+		name = fmt.Sprintf("%s-%d", name, n)
+	}
+	return name
+}
+
+// Dir returns the default snapshot directory for the script file: a
+// __snapshots__ directory next to it. Symbolic links are resolved first so
+// that scripts linked into a temporary harness record into their source tree.
+func Dir(file string) string {
+	return filepath.Join(scriptDir(file), "__snapshots__")
+}
+
+// scriptDir returns the directory of the script file, after resolving
+// symbolic links.
+func scriptDir(file string) string {
+	if real, err := filepath.EvalSymlinks(file); err == nil {
+		file = real
+	}
+	return filepath.Dir(file)
+}
+
+// IsPath reports whether a snapshot name refers to a file path rather than
+// a name within the snapshot directory.
+func IsPath(name string) bool {
+	ext := filepath.Ext(name)
+	return filepath.IsAbs(name) || strings.ContainsAny(name, `/\`) || ext == ".json" || ext == ".txtar"
+}
+
+// File returns the snapshot file for name in dir. Names that are paths (see
+// IsPath) are returned unchanged; other names get a .json extension.
+func File(dir, name string) string {
+	return file(dir, name, ".json")
+}
+
+// TreeFile returns the tree snapshot file for name in dir, like File but
+// with the TreeExt extension.
+func TreeFile(dir, name string) string {
+	return file(dir, name, TreeExt)
+}
+
+func file(dir, name, ext string) string {
+	if IsPath(name) {
+		return name
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+// Variant returns the file for the goos-specific variant of a snapshot
+// file, such as testdata/__snapshots__/name.linux.json.
+func Variant(file, goos string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "." + goos + ext
+}
+
+// withExt returns file with its extension replaced by ext.
+func withExt(file, ext string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ext
+}
+
+// otherFormat returns the file a snapshot file would have in the other
+// snapshot format.
+func otherFormat(file string) string {
+	if FormatOf(file) == FormatTxtar {
+		return withExt(file, Ext(FormatJSON))
+	}
+	return withExt(file, Ext(FormatTxtar))
+}
+
+// locate returns the snapshot file to use for file. The variant for the
+// current GOOS is preferred if it exists, and always used if goosOnly is
+// set. If anyFormat is set, a snapshot stored in the other format is used
+// when none exists in file's format. If no snapshot exists, the file that
+// would be created is returned.
+func locate(file string, goosOnly, anyFormat bool) string {
+	files := []string{file}
+	if anyFormat {
+		files = append(files, otherFormat(file))
+	}
+	for _, f := range files {
+		if variant := Variant(f, runtime.GOOS); fileExists(variant) {
+			return variant
+		}
+	}
+	if goosOnly {
+		return Variant(file, runtime.GOOS)
+	}
+	for _, f := range files {
+		if fileExists(f) {
+			return f
+		}
+	}
+	return file
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// snapshotDir returns the directory the script's snapshots are stored in.
+func (c *commands) snapshotDir(s *script.State) string {
+	if c.cfg.Dir != "" {
+		return c.cfg.Dir
+	}
+	if file := scriptFile(s); file != "" {
+		return Dir(file)
+	}
+	return filepath.Join("testdata", "__snapshots__")
+}
+
+// defaultName returns the name of the next unnamed snapshot taken by the
+// command cmd in the script.
+func (c *commands) defaultName(s *script.State, cmd string) string {
+	file := scriptFile(s)
+	if file == "" {
+		// Without a known script, fall back to the work directory name
+		file = s.Getwd()
+	}
+	return DefaultName(file, c.nextUnnamed(s, cmd))
+}
+
+// snapshotFile resolves the file for the snapshot command cmd, which stores
+// snapshots with extension ext. An empty name selects the script's next
+// default name for cmd. Relative paths are resolved against the script's
+// directory, so that they survive the run like the snapshot directory does.
+func (c *commands) snapshotFile(s *script.State, cmd, name, ext string) string {
+	if name == "" {
+		name = c.defaultName(s, cmd)
+	}
+	if IsPath(name) {
+		if file := scriptFile(s); file != "" && !filepath.IsAbs(name) {
+			return filepath.Join(scriptDir(file), name)
+		}
+		return name
+	}
+	return file(c.snapshotDir(s), name, ext)
+}
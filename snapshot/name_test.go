@@ -0,0 +1,100 @@
+package snapshot
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rsc.io/script"
+)
+
+func TestDefaultName(t *testing.T) {
+	tests := []struct {
+		file string
+		n    int
+		want string
+	}{
+		{"testdata/hello.txt", 1, "hello"},
+		{"testdata/hello.txt", 2, "hello-2"},
+		{"hello", 3, "hello-3"},
+	}
+	for _, tt := range tests {
+		if got := DefaultName(tt.file, tt.n); got != tt.want {
+			t.Errorf("DefaultName(%q, %d) = %q, want %q", tt.file, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultNameState(t *testing.T) {
+	cmds := &commands{scripts: make(map[*script.State]*scriptState)}
+	file := filepath.Join("testdata", "hello.txt")
+	var states []*script.State
+	for i := 0; i < 2; i++ {
+		s, err := script.NewState(WithScript(context.Background(), file), t.TempDir(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, s)
+	}
+	// Each script counts its own unnamed snapshots
+	for _, want := range []string{"hello", "hello-2"} {
+		if got := cmds.defaultName(states[0], "snapshot"); got != want {
+			t.Errorf("defaultName = %q, want %q", got, want)
+		}
+	}
+	if got := cmds.defaultName(states[1], "snapshot"); got != "hello" {
+		t.Errorf("defaultName in another script = %q, want hello", got)
+	}
+
+	// The state of a script is dropped once it is done
+	for _, s := range states {
+		s.CloseAndWait(io.Discard)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cmds.mu.Lock()
+		n := len(cmds.scripts)
+		cmds.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d script states left after the scripts are done", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join("testdata", "__snapshots__")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"output", filepath.Join(dir, "output.json")},
+		{"v1.2", filepath.Join(dir, "v1.2.json")},
+		{"out.json", "out.json"},
+		{filepath.Join("sub", "out"), filepath.Join("sub", "out")},
+	}
+	for _, tt := range tests {
+		if got := File(dir, tt.name); got != tt.want {
+			t.Errorf("File(%q, %q) = %q, want %q", dir, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVariant(t *testing.T) {
+	tests := []struct {
+		file, goos, want string
+	}{
+		{"__snapshots__/out.json", "linux", "__snapshots__/out.linux.json"},
+		{"__snapshots__/out", "darwin", "__snapshots__/out.darwin"},
+	}
+	for _, tt := range tests {
+		if got := Variant(tt.file, tt.goos); got != tt.want {
+			t.Errorf("Variant(%q, %q) = %q, want %q", tt.file, tt.goos, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+)
+
+// A Ref is a snapshot referenced by a snapshot command in a script.
+type Ref struct {
+	Script string // script file
+	Line   int    // line of the snapshot command
+	File   string // snapshot file, before format and GOOS variant selection
+	GOOS   bool   // whether the command uses -goos
+
+	// Pattern reports whether File is a glob pattern: the name uses
+	// variables, which are only known at run time and may have any value.
+	Pattern bool
+}
+
+// ScriptRefs parses the script file and returns the snapshots its snapshot
+// commands refer to, stored in the default directory (see Dir) or at the
+// path they name, relative to the script's directory. Names using variables
+// are returned as patterns (see Ref.Pattern).
+//
+// The format is the format of new snapshots (see Config.Format), which
+// selects the extension of snapshot files. Inline snapshots are not
+// included: those with a section in the script, and, under the -inline
+// flag or FormatInline, those without a snapshot file to read instead.
+func ScriptRefs(file, format string) ([]Ref, error) {
+	snapshotExt := Ext(format)
+	if format == FormatInline {
+		snapshotExt = Ext(FormatJSON)
+	}
+	if snapshotExt == "" {
+		return nil, fmt.Errorf("unknown snapshot format %q", format)
+	}
+	a, err := txtar.ParseFile(file)
+	if err != nil {
+		return nil, err
+	}
+	dir := Dir(file)
+
+	var refs []Ref
+	unnamed := make(map[string]int)
+	for i, line := range strings.Split(string(a.Comment), "\n") {
+		args, err := splitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, i+1, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		cmd, args := args[0], args[1:]
+
+		ref := Ref{Script: file, Line: i + 1}
+		ext := snapshotExt
+		inline := format == FormatInline
+		switch cmd {
+		case "snapshot":
+			for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+				switch args[0] {
+				case "-goos":
+					ref.GOOS = true
+				case "-inline":
+					inline = true
+				}
+				args = args[1:]
+			}
+		case "snapshot-tree":
+			if len(args) == 0 {
+				continue
+			}
+			args = args[1:] // directory
+			ext = TreeExt
+		default:
+			continue
+		}
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		} else {
+			unnamed[cmd]++
+			name = DefaultName(file, unnamed[cmd])
+		}
+		if cmd == "snapshot" {
+			if _, found := inlineSection(a, name, ref.GOOS); found {
+				continue // stored in the script
+			}
+			// Without a section, an existing snapshot file is still used,
+			// which may be any file a name using variables matches
+			if inline && !IsPath(name) && !strings.Contains(name, "$") && !stored(filepath.Join(dir, name+ext)) {
+				continue
+			}
+		}
+		if strings.Contains(name, "$") {
+			name = os.Expand(name, func(string) string { return "*" })
+			ref.Pattern = true
+		}
+		switch {
+		case !IsPath(name):
+			ref.File = filepath.Join(dir, name+ext)
+		case filepath.IsAbs(name) || strings.HasPrefix(name, "*"):
+			// A leading variable can name any directory
+			ref.File = name
+		default:
+			ref.File = filepath.Join(scriptDir(file), name)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// splitArgs splits a script line into words the way rsc.io/script does,
+// dropping comments and command prefixes such as !, ? and [cond].
+func splitArgs(line string) ([]string, error) {
+	var (
+		args   []string
+		arg    strings.Builder
+		inArg  bool
+		quoted bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quoted && c == '\'':
+			if i+1 < len(line) && line[i+1] == '\'' {
+				arg.WriteByte('\'')
+				i++
+			} else {
+				quoted = false
+			}
+		case quoted:
+			arg.WriteByte(c)
+		case c == '\'':
+			quoted, inArg = true, true
+		case c == ' ' || c == '\t' || c == '#':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			if c == '#' {
+				i = len(line)
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted argument")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	// Drop command prefixes
+	for len(args) > 0 {
+		if a := args[0]; a == "!" || a == "?" || strings.HasPrefix(a, "[") && strings.HasSuffix(a, "]") {
+			args = args[1:]
+			continue
+		}
+		break
+	}
+	return args, nil
+}
+
+// Audit reports the state of the snapshots belonging to the given scripts.
+// The others are further scripts sharing their snapshot directories, such
+// as every script matching the configured pattern, whose references keep
+// snapshots from being reported as orphaned.
+//
+// Orphaned lists the snapshot and tree snapshot files in the scripts'
+// __snapshots__ directories that no snapshot command refers to. Missing lists
+// the snapshots the given scripts refer to that do not exist for goos. The
+// format is the format of new snapshots (see ScriptRefs).
+func Audit(scripts, others []string, goos, format string) (orphaned []string, missing []Ref, err error) {
+	seen := make(map[string]bool)
+	var all []string
+	for _, script := range append(append([]string(nil), scripts...), others...) {
+		if !seen[filepath.Clean(script)] {
+			seen[filepath.Clean(script)] = true
+			all = append(all, script)
+		}
+	}
+
+	referenced := make(map[string]bool) // referenced snapshot files
+	var patterns []string               // referenced snapshot file patterns
+	snapshotDirs := make(map[string]bool)
+	for _, script := range all {
+		refs, err := ScriptRefs(script, format)
+		if err != nil {
+			return nil, nil, err
+		}
+		snapshotDirs[Dir(script)] = true
+		for _, ref := range refs {
+			if ref.Pattern {
+				patterns = append(patterns, ref.File)
+				continue
+			}
+			referenced[ref.File] = true
+			if !exists(ref.File, ref.GOOS, goos) && listed(scripts, script) {
+				missing = append(missing, ref)
+			}
+		}
+	}
+
+	for dir := range snapshotDirs {
+		for _, ext := range []string{Ext(FormatJSON), Ext(FormatTxtar), TreeExt} {
+			files, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, file := range files {
+				if ext == Ext(FormatTxtar) && strings.HasSuffix(file, TreeExt) {
+					continue // globbed as a tree snapshot
+				}
+				if !isReferenced(referenced, patterns, file) {
+					orphaned = append(orphaned, file)
+				}
+			}
+		}
+	}
+	sort.Strings(orphaned)
+	return orphaned, missing, nil
+}
+
+// variantOf returns the snapshot file that file is a GOOS variant of, such
+// as name.json for name.linux.json, or "" if file is not a variant.
+func variantOf(file string) string {
+	ext := filepath.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+	goos := filepath.Ext(base)
+	if !knownGOOS[strings.TrimPrefix(goos, ".")] {
+		return ""
+	}
+	return strings.TrimSuffix(base, goos) + ext
+}
+
+// isReferenced reports whether file is one of the referenced snapshot
+// files or matches one of the patterns, possibly as a GOOS variant or in
+// the other format.
+func isReferenced(referenced map[string]bool, patterns []string, file string) bool {
+	for _, f := range []string{file, variantOf(file)} {
+		if f == "" {
+			continue
+		}
+		for _, f := range []string{f, otherFormat(f)} {
+			if referenced[f] {
+				return true
+			}
+			for _, pattern := range patterns {
+				if matchPattern(pattern, f) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether file matches the pattern of a Ref. A
+// wildcard in the directory matches any directory.
+func matchPattern(pattern, file string) bool {
+	dir, base := filepath.Split(pattern)
+	if ok, _ := filepath.Match(base, filepath.Base(file)); !ok {
+		return false
+	}
+	return strings.Contains(dir, "*") || filepath.Clean(dir) == filepath.Dir(file)
+}
+
+// exists reports whether the snapshot file, or its goos variant, exists in
+// either format. If variantOnly is set, only the variant is considered.
+func exists(file string, variantOnly bool, goos string) bool {
+	for _, f := range []string{file, otherFormat(file)} {
+		if fileExists(Variant(f, goos)) || !variantOnly && fileExists(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// stored reports whether the snapshot file exists in either format, as is
+// or as the variant for any GOOS.
+func stored(file string) bool {
+	for goos := range knownGOOS {
+		if exists(file, false, goos) {
+			return true
+		}
+	}
+	return false
+}
+
+// listed reports whether script is one of scripts.
+func listed(scripts []string, script string) bool {
+	for _, s := range scripts {
+		if filepath.Clean(s) == filepath.Clean(script) {
+			return true
+		}
+	}
+	return false
+}
+
+// knownGOOS lists the GOOS values recognized in snapshot variant names.
+var knownGOOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "illumos": true, "ios": true, "js": true,
+	"linux": true, "netbsd": true, "openbsd": true, "plan9": true,
+	"solaris": true, "wasip1": true, "windows": true,
+}
+
+// Candidates returns the candidate files (see Candidate) awaiting review in
+// the snapshot directories of the given scripts.
+func Candidates(scripts []string) ([]string, error) {
+	dirs := make(map[string]bool)
+	var candidates []string
+	for _, script := range scripts {
+		dir := Dir(script)
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		matches, err := filepath.Glob(Candidate(filepath.Join(dir, "*")))
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, matches...)
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
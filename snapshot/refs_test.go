@@ -0,0 +1,218 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"# comment", nil},
+		{"snapshot", []string{"snapshot"}},
+		{"snapshot name # trailing comment", []string{"snapshot", "name"}},
+		{"[linux] ! snapshot 'my name'", []string{"snapshot", "my name"}},
+		{"? snapshot 'it''s'", []string{"snapshot", "it's"}},
+		{"\tsnapshot -goos x", []string{"snapshot", "-goos", "x"}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+	if _, err := splitArgs("snapshot 'unterminated"); err == nil {
+		t.Error("splitArgs with unterminated quote: expected error")
+	}
+}
+
+func TestScriptRefs(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "hello.txt")
+	writeFile(t, script, `echo one
+snapshot
+echo two
+[linux] snapshot -timeout=1s
+snapshot named
+snapshot -goos platform
+snapshot $WORK/dynamic.json
+snapshot out/path.json
+snapshot-tree out
+snapshot-tree out generated
+snapshot inlined
+snapshot -inline flagged
+snapshot out-$GOOS
+-- data.txt --
+snapshot ignored
+-- __snapshot__/inlined --
+output
+`)
+
+	refs, err := ScriptRefs(script, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots := Dir(script)
+	want := []Ref{
+		{Script: script, Line: 2, File: filepath.Join(snapshots, "hello.json")},
+		{Script: script, Line: 4, File: filepath.Join(snapshots, "hello-2.json")},
+		{Script: script, Line: 5, File: filepath.Join(snapshots, "named.json")},
+		{Script: script, Line: 6, File: filepath.Join(snapshots, "platform.json"), GOOS: true},
+		{Script: script, Line: 7, File: "*/dynamic.json", Pattern: true},
+		{Script: script, Line: 8, File: filepath.Join(filepath.Dir(snapshots), "out", "path.json")},
+		{Script: script, Line: 9, File: filepath.Join(snapshots, "hello"+TreeExt)},
+		{Script: script, Line: 10, File: filepath.Join(snapshots, "generated"+TreeExt)},
+		{Script: script, Line: 13, File: filepath.Join(snapshots, "out-*.json"), Pattern: true},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("ScriptRefs() =\n%+v\nwant:\n%+v", refs, want)
+	}
+
+	// New snapshots are stored as txtar files
+	refs, err = ScriptRefs(script, FormatTxtar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := refs[0].File, filepath.Join(snapshots, "hello.txtar"); got != want {
+		t.Errorf("with the txtar format, first snapshot is %s, want %s", got, want)
+	}
+
+	// New snapshots are stored inline, but existing files are still used
+	writeFile(t, filepath.Join(snapshots, "named.linux.txtar"), "")
+	refs, err = ScriptRefs(script, FormatInline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Ref{
+		{Script: script, Line: 5, File: filepath.Join(snapshots, "named.json")},
+		{Script: script, Line: 7, File: "*/dynamic.json", Pattern: true},
+		{Script: script, Line: 8, File: filepath.Join(filepath.Dir(snapshots), "out", "path.json")},
+		{Script: script, Line: 9, File: filepath.Join(snapshots, "hello"+TreeExt)},
+		{Script: script, Line: 10, File: filepath.Join(snapshots, "generated"+TreeExt)},
+		{Script: script, Line: 13, File: filepath.Join(snapshots, "out-*.json"), Pattern: true},
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("ScriptRefs() with the inline format =\n%+v\nwant:\n%+v", refs, want)
+	}
+
+	if _, err := ScriptRefs(script, "yaml"); err == nil {
+		t.Error("ScriptRefs with an unknown format: expected error")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	writeFile(t, a, "echo a\nsnapshot\nsnapshot missing\nsnapshot -goos platform\nsnapshot-tree out\n")
+	writeFile(t, b, "echo b\nsnapshot\nsnapshot b-$GOARCH\nsnapshot __snapshots__/custom.json\n")
+	// Not a script: it is not matched by the pattern
+	writeFile(t, filepath.Join(dir, "data.txt"), "snapshot stale\n")
+
+	snapshots := Dir(a)
+	for _, name := range []string{
+		"a.json",
+		"b-amd64.json",
+		"b-arm64.linux.txtar",
+		"custom.json",
+		"b.json",
+		"platform.linux.json",
+		"platform.darwin.json",
+		"stale.json",
+		"stale.linux.json",
+		"a.extra.json",
+		"a.tree.txtar",
+		"a.tree.linux.txtar",
+		"stale.tree.txtar",
+	} {
+		writeFile(t, filepath.Join(snapshots, name), "{}")
+	}
+
+	// b.txt is not listed, but its snapshots are still in use.
+	orphaned, missing, err := Audit([]string{a}, []string{a, b}, "linux", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOrphaned := []string{
+		filepath.Join(snapshots, "a.extra.json"),
+		filepath.Join(snapshots, "stale.json"),
+		filepath.Join(snapshots, "stale.linux.json"),
+		filepath.Join(snapshots, "stale.tree.txtar"),
+	}
+	if !reflect.DeepEqual(orphaned, wantOrphaned) {
+		t.Errorf("orphaned = %q, want %q", orphaned, wantOrphaned)
+	}
+	if len(missing) != 1 || missing[0].File != filepath.Join(snapshots, "missing.json") {
+		t.Errorf("missing = %+v, want missing.json", missing)
+	}
+
+	// On windows the -goos snapshot has no variant.
+	_, missing, err = Audit([]string{a, b}, nil, "windows", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 2 || missing[1].File != filepath.Join(snapshots, "platform.json") {
+		t.Errorf("missing = %+v, want missing.json and platform.json", missing)
+	}
+
+	// Under the inline format, snapshots without a file are stored in the
+	// script, and named after the txtar format otherwise.
+	orphaned, missing, err = Audit([]string{a}, []string{a, b}, "linux", FormatInline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(orphaned, wantOrphaned) || len(missing) != 0 {
+		t.Errorf("with the inline format, orphaned = %q and missing = %+v, want %q and none", orphaned, missing, wantOrphaned)
+	}
+	_, missing, err = Audit([]string{a}, nil, "linux", FormatTxtar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].File != filepath.Join(snapshots, "missing.txtar") {
+		t.Errorf("with the txtar format, missing = %+v, want missing.txtar", missing)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	b := filepath.Join(dir, "b.txt")
+	writeFile(t, a, "echo a\nsnapshot\n")
+	writeFile(t, b, "echo b\nsnapshot\n")
+
+	snapshots := Dir(a)
+	for _, name := range []string{"a.json", "a.json.new", "b.json.new", "b.json.diff"} {
+		writeFile(t, filepath.Join(snapshots, name), "{}")
+	}
+
+	got, err := Candidates([]string{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(snapshots, "a.json.new"),
+		filepath.Join(snapshots, "b.json.new"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Candidates = %q, want %q", got, want)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
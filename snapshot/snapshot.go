@@ -0,0 +1,514 @@
+// Package snapshot provides the scripttest commands for recording command
+// output to snapshot files and verifying it on later runs.
+//
+// The package only depends on the standard library, rsc.io/script,
+// golang.org/x/tools/txtar, gopkg.in/yaml.v3 and internal/scriptexec so that
+// its source can be copied, with that of internal/scriptexec, into the
+// standalone test harness generated by the scripttest command (see Source).
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/txtar"
+	"rsc.io/script"
+)
+
+// Config configures the snapshot commands.
+type Config struct {
+	// Dir is the directory snapshot files are stored in. If empty, snapshots
+	// are stored in a __snapshots__ directory next to the running script
+	// (see WithScript), or in testdata/__snapshots__ if the script is unknown.
+	Dir string
+
+	// Update causes snapshots to be written instead of compared
+	Update bool
+
+	// Format is the format new snapshots are written in: FormatJSON (the
+	// default), FormatTxtar or FormatInline. Snapshots stored in another
+	// format are still read, and are converted when updated.
+	Format string
+
+	// Filters are applied to output after the built-in normalizers,
+	// both when recording and when comparing
+	Filters []Filter
+
+	// Timing records the chunks the output of exec commands is written in,
+	// with their times, and the terminal size in snapshots, for playback.
+	// It replaces the exec command with one that records its output as it
+	// is written.
+	Timing bool
+}
+
+// Commands returns a map of snapshot-related commands to add to a scripttest engine.
+func Commands(cfg Config) map[string]script.Cmd {
+	c := &commands{
+		cfg:     cfg,
+		scripts: make(map[*script.State]*scriptState),
+	}
+
+	cmds := make(map[string]script.Cmd)
+	cmds["snapshot"] = c.snapshotCmd()
+	cmds["snapshot-filter"] = c.filterCmd()
+	cmds["snapshot-tree"] = c.treeCmd()
+	if cfg.Timing {
+		cmds["exec"] = c.execCmd()
+	}
+	return cmds
+}
+
+// commands holds the configuration and per-script state shared by the
+// snapshot commands. A single engine may run several scripts concurrently,
+// so script state is keyed by the script's *script.State.
+type commands struct {
+	cfg Config
+
+	mu      sync.Mutex
+	scripts map[*script.State]*scriptState
+}
+
+// scriptState is the snapshot state of a single running script.
+type scriptState struct {
+	filters []Filter       // filters declared with snapshot-filter
+	unnamed map[string]int // number of unnamed snapshots taken so far, by command
+	timed   *timedOutput   // output of the last exec command, with Config.Timing
+}
+
+// state returns the state for s, which is dropped once the script is done.
+// c.mu must be held.
+func (c *commands) state(s *script.State) *scriptState {
+	st, ok := c.scripts[s]
+	if !ok {
+		st = new(scriptState)
+		c.scripts[s] = st
+		context.AfterFunc(s.Context(), func() {
+			c.mu.Lock()
+			delete(c.scripts, s)
+			c.mu.Unlock()
+		})
+	}
+	return st
+}
+
+// nextUnnamed counts an unnamed snapshot taken by the command cmd for s and
+// returns its 1-based index.
+func (c *commands) nextUnnamed(s *script.State, cmd string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.state(s)
+	if st.unnamed == nil {
+		st.unnamed = make(map[string]int)
+	}
+	st.unnamed[cmd]++
+	return st.unnamed[cmd]
+}
+
+// snapshotCmd creates the command that records or verifies command output
+func (c *commands) snapshotCmd() script.Cmd {
+	return script.Command(
+		script.CmdUsage{
+			Summary: "Record command output",
+			Args:    "[-timeout=duration] [-goos] [-inline] [-format=text|json|yaml] [-ignore=path]... [name]",
+			Detail: []string{
+				"snapshot compares the stdout and stderr of the previous command with",
+				"the stored snapshot. When updating snapshots, the output is written",
+				"to the snapshot file instead.",
+				"",
+				"The snapshot is stored as name.json, or name.txtar in the txtar format,",
+				"in the snapshot directory. Snapshots stored in either format are read.",
+				"If name is a path (it contains a separator or ends in .json or .txtar),",
+				"it is used as the snapshot file, relative to the directory holding",
+				"the script file, not to the script's working directory. If name is",
+				"omitted, the script's name is used, with a -2,",
+				"-3, ... suffix for further unnamed snapshots in the same script.",
+				"",
+				"If the script has a -- __snapshot__/name -- section, the snapshot is",
+				"stored inline instead: the section holds stdout, and a",
+				"__snapshot__/name.stderr section holds stderr, if any. Updating",
+				"snapshots rewrites the script in place. The -inline flag, or the",
+				"inline snapshot format, creates inline snapshots.",
+				"",
+				"If a variant for the current GOOS exists, such as name.linux.json, it",
+				"is used instead. The -goos flag always uses the variant, creating it",
+				"when updating snapshots.",
+				"",
+				"Paths under $WORK, $HOME and $TMPDIR are replaced with placeholders",
+				"and any filters declared with snapshot-filter are applied before",
+				"the output is recorded or compared.",
+				"",
+				"With -format=json, stdout must be a single JSON value. It is stored",
+				"with sorted keys and consistent indentation and compared structurally,",
+				"so key order and formatting don't matter. Each -ignore flag names a",
+				"path, such as .metadata.createdAt, .items[0].id, .items[].id or",
+				".labels.*, whose value is stored as \"<ignored>\" and not compared.",
+				"Mismatches are reported per path. -format=yaml does the same for a",
+				"single YAML document. Paths and filters are applied to the strings",
+				"and keys of the parsed value, so they can't break its syntax.",
+				"",
+				"When snapshots are recorded with timing, the chunks the output of",
+				"the previous exec command was written in are stored as well, with",
+				"their times and the terminal size, for playback. Timing is not",
+				"compared, and is kept when updating unchanged output. Inline,",
+				"-format=json and -format=yaml snapshots don't store timing.",
+				"",
+				"On mismatch, a unified diff of stdout and stderr is reported and",
+				"the full diff is written next to the snapshot file with a .diff",
+				"suffix. The new output is written with a .new suffix, so it can",
+				"be accepted with 'scripttest snapshots review'.",
+			},
+		},
+		func(s *script.State, args ...string) (script.WaitFunc, error) {
+			var (
+				timeout time.Duration
+				goos    bool
+				inline  bool
+				cmp     comparison
+			)
+			// Parse flags
+			for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+				switch arg := args[0]; {
+				case strings.HasPrefix(arg, "-timeout="):
+					var err error
+					timeout, err = time.ParseDuration(strings.TrimPrefix(arg, "-timeout="))
+					if err != nil {
+						return nil, fmt.Errorf("invalid timeout value: %v", err)
+					}
+				case arg == "-goos":
+					goos = true
+				case arg == "-inline":
+					inline = true
+				case arg == "-format=text":
+					cmp.format = ""
+				case arg == "-format=json":
+					cmp.format = formatJSON
+				case arg == "-format=yaml":
+					cmp.format = formatYAML
+				case strings.HasPrefix(arg, "-ignore="):
+					path, err := parseJSONPath(strings.TrimPrefix(arg, "-ignore="))
+					if err != nil {
+						return nil, err
+					}
+					cmp.ignore = append(cmp.ignore, path)
+				default:
+					return nil, script.ErrUsage
+				}
+				args = args[1:]
+			}
+			if len(cmp.ignore) > 0 && cmp.format == "" {
+				return nil, fmt.Errorf("-ignore requires -format=json or -format=yaml")
+			}
+			if len(args) > 1 {
+				return nil, script.ErrUsage
+			}
+			var name string
+			if len(args) == 1 {
+				name = args[0]
+			}
+
+			ext := Ext(c.cfg.Format)
+			if c.cfg.Format == FormatInline {
+				inline, ext = true, Ext(FormatJSON)
+			}
+			if ext == "" {
+				return nil, fmt.Errorf("unknown snapshot format %q", c.cfg.Format)
+			}
+			explicit := IsPath(name) // explicit paths select their file and format
+			if name == "" {
+				name = c.defaultName(s, "snapshot")
+			}
+
+			// If timeout is specified, wait for the specified duration
+			if timeout > 0 {
+				time.Sleep(timeout)
+			}
+
+			// Get the normalized command output
+			got := &Snapshot{Stderr: c.normalize(s, s.Stderr())}
+			if cmp.format != "" {
+				v, err := cmp.parse(s.Stdout(), func(text string) string { return c.normalize(s, text) })
+				if err != nil {
+					return nil, fmt.Errorf("stdout is not valid %s: %v", strings.ToUpper(cmp.format), err)
+				}
+				got.Stdout = cmp.canonical(v)
+			} else {
+				got.Stdout = c.normalize(s, s.Stdout())
+				if c.cfg.Timing {
+					got.Terminal, got.Chunks = c.timing(s)
+				}
+			}
+
+			filename := locate(c.snapshotFile(s, "snapshot", name, ext), goos, !explicit)
+
+			// Use an inline snapshot if the script has one, or if inline
+			// snapshots are requested and there is no snapshot file to use
+			if script := scriptFile(s); script != "" && !explicit && (inline || fileExists(script)) {
+				a, err := txtar.ParseFile(script)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read script: %v", err)
+				}
+				section, found := inlineSection(a, name, goos)
+				if found || inline && (c.cfg.Update || !fileExists(filename)) {
+					return nil, c.inlineSnapshot(s, script, a, section, filename, got, cmp)
+				}
+			} else if inline {
+				return nil, fmt.Errorf("inline snapshots require a snapshot name and a known script")
+			}
+
+			// Create snapshot directory if it doesn't exist
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+			}
+
+			// Check if we're updating snapshots
+			if c.cfg.Update {
+				target := filename
+				if !explicit {
+					target = withExt(filename, ext)
+				}
+				if got.Chunks != nil && target == filename && sameOutput(filename, got) {
+					// Keep the recorded timing of unchanged output
+					return nil, RemoveArtifacts(target)
+				}
+				if err := WriteFile(target, got); err != nil {
+					return nil, fmt.Errorf("failed to write snapshot: %v", err)
+				}
+				if target != filename {
+					// Converted from the other format
+					if err := removeSnapshot(filename); err != nil {
+						return nil, err
+					}
+				}
+				return nil, RemoveArtifacts(target)
+			}
+
+			// Candidates are written in the stored snapshot's format
+			data, err := Marshal(got, FormatOf(filename))
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal snapshot: %v", err)
+			}
+
+			// Read existing snapshot
+			want, err := ReadFile(filename)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil, missingError(filename, data)
+				}
+				return nil, fmt.Errorf("failed to read snapshot: %v", err)
+			}
+
+			diff, err := c.diffSnapshot(s, want, got, cmp)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot %s: %v", filename, err)
+			}
+			if diff != "" {
+				return nil, mismatchError(filename, diff, data)
+			}
+
+			// Remove any artifacts left over from an earlier failure
+			return nil, RemoveArtifacts(filename)
+		},
+	)
+}
+
+// sameOutput reports whether the snapshot file exists, has timing and
+// holds the same output as snap.
+func sameOutput(file string, snap *Snapshot) bool {
+	old, err := ReadFile(file)
+	return err == nil && old.Chunks != nil && old.Stdout == snap.Stdout && old.Stderr == snap.Stderr
+}
+
+// comparison holds the settings of a snapshot command that affect how
+// output is compared with a snapshot.
+type comparison struct {
+	format string     // "" for text, or the structured format of stdout
+	ignore []jsonPath // paths to ignore in structured stdout
+}
+
+// diffSnapshot reports the differences between the stored snapshot want and
+// the normalized output got, or "" if they match. The stored output is
+// normalized as well, so that newly declared filters don't require
+// re-recording.
+func (c *commands) diffSnapshot(s *script.State, want, got *Snapshot, cmp comparison) (string, error) {
+	var diff string
+	if cmp.format != "" {
+		wantValue, err := cmp.parse(want.Stdout, func(text string) string { return c.normalize(s, text) })
+		if err != nil {
+			return "", fmt.Errorf("stored stdout is not valid %s: %v", strings.ToUpper(cmp.format), err)
+		}
+		gotValue, err := cmp.parse(got.Stdout, nil)
+		if err != nil {
+			return "", err
+		}
+		if d := diffJSON(wantValue, gotValue); d != "" {
+			diff = "--- stdout (snapshot)\n+++ stdout (actual)\n" + d
+		}
+	} else {
+		diff = Diff("stdout (snapshot)", c.normalize(s, want.Stdout), "stdout (actual)", got.Stdout)
+	}
+	diff += Diff("stderr (snapshot)", c.normalize(s, want.Stderr), "stderr (actual)", got.Stderr)
+	return diff, nil
+}
+
+// inlineSnapshot records or verifies got against the inline snapshot in
+// section of the script file, whose archive is a. When updating, a snapshot
+// previously stored in file is moved into the script. Like file snapshots,
+// a missing or mismatching inline snapshot leaves a candidate for review,
+// next to the section's InlineFile.
+func (c *commands) inlineSnapshot(s *script.State, script string, a *txtar.Archive, section, file string, got *Snapshot, cmp comparison) error {
+	inlineFile := InlineFile(script, section)
+	if c.cfg.Update {
+		if err := writeInline(script, section, got); err != nil {
+			return fmt.Errorf("failed to write inline snapshot %s: %v", section, err)
+		}
+		if err := RemoveArtifacts(inlineFile); err != nil {
+			return err
+		}
+		return removeSnapshot(file)
+	}
+
+	data, err := Marshal(got, FormatJSON)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	if _, ok := findSection(a, InlinePrefix+section); !ok {
+		if err := writeArtifacts(inlineFile, "", data); err != nil {
+			return fmt.Errorf("inline snapshot %s does not exist in %s (%v). Run with UPDATE_SNAPSHOTS=1 to create", section, script, err)
+		}
+		return fmt.Errorf("inline snapshot %s does not exist in %s. Run with UPDATE_SNAPSHOTS=1 to create, or review %s", section, script, Candidate(inlineFile))
+	}
+	diff, err := c.diffSnapshot(s, readInline(a, section), got, cmp)
+	if err != nil {
+		return fmt.Errorf("inline snapshot %s: %v", section, err)
+	}
+	if diff != "" {
+		if err := writeArtifacts(inlineFile, diff, data); err != nil {
+			return fmt.Errorf("output does not match inline snapshot %s in %s (%v):\n%s",
+				section, script, err, truncateDiff(diff, maxDiffLines))
+		}
+		return fmt.Errorf("output does not match inline snapshot %s in %s (full diff in %s):\n%s",
+			section, script, inlineFile+".diff", truncateDiff(diff, maxDiffLines))
+	}
+	return RemoveArtifacts(inlineFile)
+}
+
+// removeSnapshot removes a snapshot file that has been replaced, along with
+// its artifacts.
+func removeSnapshot(file string) error {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove replaced snapshot: %v", err)
+	}
+	return RemoveArtifacts(file)
+}
+
+// maxDiffLines limits the diff included in a mismatch error. The full diff
+// is written next to the snapshot file.
+const maxDiffLines = 40
+
+// Candidate returns the file a mismatching snapshot's new output is written
+// to, for review with "scripttest snapshots review".
+func Candidate(file string) string {
+	return file + ".new"
+}
+
+// missingError writes the candidate snapshot data next to the missing
+// snapshot file and returns an error describing how to create it.
+func missingError(filename string, data []byte) error {
+	if err := writeArtifacts(filename, "", data); err != nil {
+		return fmt.Errorf("snapshot %s does not exist (%v). Run with UPDATE_SNAPSHOTS=1 to create", filename, err)
+	}
+	return fmt.Errorf("snapshot %s does not exist. Run with UPDATE_SNAPSHOTS=1 to create, or review %s", filename, Candidate(filename))
+}
+
+// mismatchError writes diff and the candidate snapshot data next to the
+// snapshot file and returns an error describing the mismatch, with the diff
+// truncated to maxDiffLines.
+func mismatchError(filename, diff string, data []byte) error {
+	if err := writeArtifacts(filename, diff, data); err != nil {
+		return fmt.Errorf("output does not match snapshot %s (%v):\n%s",
+			filename, err, truncateDiff(diff, maxDiffLines))
+	}
+	return fmt.Errorf("output does not match snapshot %s (full diff in %s):\n%s",
+		filename, filename+".diff", truncateDiff(diff, maxDiffLines))
+}
+
+// writeArtifacts writes the candidate snapshot data next to the snapshot
+// file, and diff, unless it is empty.
+func writeArtifacts(filename, diff string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to write candidate: %v", err)
+	}
+	if err := os.WriteFile(Candidate(filename), data, 0644); err != nil {
+		return fmt.Errorf("failed to write candidate: %v", err)
+	}
+	if diff == "" {
+		return nil
+	}
+	if err := os.WriteFile(filename+".diff", []byte(diff), 0644); err != nil {
+		return fmt.Errorf("failed to write diff: %v", err)
+	}
+	return nil
+}
+
+// RemoveArtifacts removes the diff and candidate files left next to a
+// snapshot file by an earlier mismatch.
+func RemoveArtifacts(filename string) error {
+	for _, file := range []string{filename + ".diff", Candidate(filename)} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale %s: %v", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
+// filterCmd creates the command that declares a replacement applied to snapshot output
+func (c *commands) filterCmd() script.Cmd {
+	return script.Command(
+		script.CmdUsage{
+			Summary: "Declare a replacement applied to snapshot output",
+			Args:    "pattern replacement",
+			Detail: []string{
+				"snapshot-filter replaces every match of the regular expression pattern",
+				"with replacement before output is recorded to or compared with a",
+				"snapshot. The replacement may refer to submatches using $1 or ${name},",
+				"in which case it must be single-quoted to prevent variable expansion.",
+				"Filters apply to the remainder of the script, in declaration order.",
+				"",
+				"Example:",
+				"  snapshot-filter '[0-9]+ms' '<DURATION>'",
+				"  snapshot-filter 'pid=[0-9]+' 'pid=<PID>'",
+			},
+			RegexpArgs: func(rawArgs ...string) []int { return []int{0} },
+		},
+		func(s *script.State, args ...string) (script.WaitFunc, error) {
+			if len(args) != 2 {
+				return nil, script.ErrUsage
+			}
+			f, err := NewFilter(args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+
+			c.mu.Lock()
+			st := c.state(s)
+			st.filters = append(st.filters, f)
+			c.mu.Unlock()
+			return nil, nil
+		},
+	)
+}
+
+// normalize applies the built-in normalizers, the configured filters and the
+// filters declared by the script to output.
+func (c *commands) normalize(s *script.State, output string) string {
+	output = normalizePaths(s, output)
+	c.mu.Lock()
+	filters := append(append([]Filter(nil), c.cfg.Filters...), c.state(s).filters...)
+	c.mu.Unlock()
+	return applyFilters(filters, output)
+}
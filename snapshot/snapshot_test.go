@@ -0,0 +1,742 @@
+package snapshot_test
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/tmc/scripttestutil/snapshot"
+	"rsc.io/script"
+)
+
+// runScript runs src with the snapshot commands configured by cfg in a fresh
+// work directory and returns the script log and error.
+func runScript(t *testing.T, cfg snapshot.Config, src string) (string, error) {
+	t.Helper()
+	return runScriptAs(t, cfg, filepath.Join("testdata", "script.txt"), src)
+}
+
+// runScriptAs is like runScript, but records file as the running script.
+func runScriptAs(t *testing.T, cfg snapshot.Config, file, src string) (string, error) {
+	t.Helper()
+	cmds := script.DefaultCmds()
+	for name, cmd := range snapshot.Commands(cfg) {
+		cmds[name] = cmd
+	}
+	engine := &script.Engine{Cmds: cmds, Conds: script.DefaultConds()}
+
+	work := t.TempDir()
+	ctx := snapshot.WithScript(context.Background(), file)
+	s, err := script.NewState(ctx, work, []string{
+		"WORK=" + work,
+		"HOME=/home/gopher",
+		"TMPDIR=" + filepath.Join(work, "tmp"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var log strings.Builder
+	err = engine.Execute(s, "test.txt", bufio.NewReader(strings.NewReader(src)), &log)
+	if closeErr := s.CloseAndWait(&log); err == nil {
+		err = closeErr
+	}
+	return log.String(), err
+}
+
+func readSnapshot(t *testing.T, dir string) string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one snapshot file in %s, found %v (%v)", dir, files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSnapshotRecordAndCompare(t *testing.T) {
+	dir := t.TempDir()
+	src := "echo hello world\nsnapshot\n"
+
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, src); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+	if _, err := runScript(t, snapshot.Config{Dir: dir}, "echo goodbye\nsnapshot\n"); err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+}
+
+func TestSnapshotNormalizesPaths(t *testing.T) {
+	dir := t.TempDir()
+	src := "echo $WORK/out $TMPDIR/x $HOME/.config\nsnapshot\n"
+
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	got := readSnapshot(t, dir)
+	want := `$WORK/out $TMPDIR/x $HOME/.config`
+	if !strings.Contains(got, want) {
+		t.Errorf("snapshot = %s, want it to contain %q", got, want)
+	}
+
+	// A different work directory must still match.
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, src); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+}
+
+func TestSnapshotFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     snapshot.Config
+		record  string
+		compare string
+		want    string
+	}{
+		{
+			name:    "script filter",
+			record:  "snapshot-filter '[0-9]+ms' '<DURATION>'\necho took 12ms\nsnapshot\n",
+			compare: "snapshot-filter '[0-9]+ms' '<DURATION>'\necho took 345ms\nsnapshot\n",
+			want:    "took <DURATION>",
+		},
+		{
+			name: "config filter",
+			cfg: snapshot.Config{Filters: []snapshot.Filter{
+				{Pattern: regexp.MustCompile(`pid=\d+`), Replacement: "pid=<PID>"},
+			}},
+			record:  "echo started pid=123\nsnapshot\n",
+			compare: "echo started pid=98765\nsnapshot\n",
+			want:    "started pid=<PID>",
+		},
+		{
+			name:    "submatch",
+			record:  "snapshot-filter 'v([0-9]+)\\.[0-9]+' 'v$1.x'\necho version v1.2\nsnapshot\n",
+			compare: "snapshot-filter 'v([0-9]+)\\.[0-9]+' 'v$1.x'\necho version v1.7\nsnapshot\n",
+			want:    "version v1.x",
+		},
+		{
+			name:    "filter between command and snapshot",
+			record:  "echo took 12ms\nsnapshot-filter '[0-9]+ms' '<DURATION>'\nsnapshot\nstdout 'took 12ms'\n",
+			compare: "echo took 3ms\nsnapshot-filter '[0-9]+ms' '<DURATION>'\nsnapshot\n",
+			want:    "took <DURATION>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Dir = t.TempDir()
+
+			cfg.Update = true
+			if log, err := runScript(t, cfg, tt.record); err != nil {
+				t.Fatalf("recording snapshot: %v\n%s", err, log)
+			}
+			if got := readSnapshot(t, cfg.Dir); !strings.Contains(got, tt.want) {
+				t.Errorf("snapshot = %s, want it to contain %q", got, tt.want)
+			}
+
+			cfg.Update = false
+			if log, err := runScript(t, cfg, tt.compare); err != nil {
+				t.Fatalf("comparing snapshot: %v\n%s", err, log)
+			}
+		})
+	}
+}
+
+func TestSnapshotFilterScopedToScript(t *testing.T) {
+	cfg := snapshot.Config{Dir: t.TempDir(), Update: true}
+	cmds := snapshot.Commands(cfg)
+	engine := &script.Engine{Cmds: cmds, Conds: script.DefaultConds()}
+	cmds["echo"] = script.Echo()
+
+	run := func(src string) {
+		t.Helper()
+		work := t.TempDir()
+		s, err := script.NewState(context.Background(), work, []string{"WORK=" + work})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var log strings.Builder
+		if err := engine.Execute(s, "test.txt", bufio.NewReader(strings.NewReader(src)), &log); err != nil {
+			t.Fatalf("%v\n%s", err, log.String())
+		}
+	}
+
+	run("snapshot-filter secret '<REDACTED>'\n")
+	run("echo secret\nsnapshot\n")
+	if got := readSnapshot(t, cfg.Dir); !strings.Contains(got, "secret") {
+		t.Errorf("filter from another script was applied: %s", got)
+	}
+}
+
+func TestSnapshotFilterUsage(t *testing.T) {
+	if _, err := runScript(t, snapshot.Config{Dir: t.TempDir()}, "snapshot-filter onlyone\n"); err == nil {
+		t.Error("expected usage error, got nil")
+	}
+	if _, err := runScript(t, snapshot.Config{Dir: t.TempDir()}, "snapshot-filter '(' x\n"); err == nil {
+		t.Error("expected invalid pattern error, got nil")
+	}
+}
+
+func TestSnapshotMismatchDiff(t *testing.T) {
+	dir := t.TempDir()
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, "echo one\nsnapshot\n"); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+
+	_, err := runScript(t, snapshot.Config{Dir: dir}, "echo two\nsnapshot\n")
+	if err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+	want := "--- stdout (snapshot)\n+++ stdout (actual)\n@@ -1 +1 @@\n-one\n+two\n"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain:\n%s", err, want)
+	}
+	if strings.Contains(err.Error(), "stderr (snapshot)") {
+		t.Errorf("error includes a diff for unchanged stderr: %v", err)
+	}
+
+	diffFile := filepath.Join(dir, "script.json.diff")
+	data, err := os.ReadFile(diffFile)
+	if err != nil {
+		t.Fatalf("reading diff artifact: %v", err)
+	}
+	if string(data) != want {
+		t.Errorf("diff artifact =\n%s\nwant:\n%s", data, want)
+	}
+
+	// A passing run removes the stale artifact.
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, "echo one\nsnapshot\n"); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+	if _, err := os.Stat(diffFile); !os.IsNotExist(err) {
+		t.Errorf("diff artifact still exists after a passing run: %v", err)
+	}
+}
+
+func TestSnapshotMismatchDiffTruncated(t *testing.T) {
+	dir := t.TempDir()
+	stored := `{"stdout": "` + strings.Repeat(`line\n`, 100) + `", "stderr": ""}`
+	if err := os.WriteFile(filepath.Join(dir, "script.json"), []byte(stored), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := runScript(t, snapshot.Config{Dir: dir}, "echo other\nsnapshot\n")
+	if err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+	if !strings.Contains(err.Error(), "more lines)") {
+		t.Errorf("error does not note truncation: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "script.json.diff"))
+	if err != nil {
+		t.Fatalf("reading diff artifact: %v", err)
+	}
+	if n := strings.Count(string(data), "-line\n"); n != 100 {
+		t.Errorf("diff artifact has %d removed lines, want 100", n)
+	}
+}
+
+func TestSnapshotCandidate(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "script.json")
+	candidate := snapshot.Candidate(file)
+
+	// A missing snapshot leaves a candidate to review.
+	if _, err := runScript(t, snapshot.Config{Dir: dir}, "echo one\nsnapshot\n"); err == nil {
+		t.Fatal("expected missing snapshot error, got nil")
+	}
+	got, err := snapshot.ReadFile(candidate)
+	if err != nil {
+		t.Fatalf("reading candidate: %v", err)
+	}
+	if got.Stdout != "one\n" {
+		t.Errorf("candidate stdout = %q, want %q", got.Stdout, "one\n")
+	}
+
+	// Recording removes it.
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, "echo one\nsnapshot\n"); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if _, err := os.Stat(candidate); !os.IsNotExist(err) {
+		t.Errorf("candidate still exists after recording: %v", err)
+	}
+
+	// A mismatch writes the new output as the candidate.
+	if _, err := runScript(t, snapshot.Config{Dir: dir}, "echo two\nsnapshot\n"); err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+	got, err = snapshot.ReadFile(candidate)
+	if err != nil {
+		t.Fatalf("reading candidate: %v", err)
+	}
+	if got.Stdout != "two\n" {
+		t.Errorf("candidate stdout = %q, want %q", got.Stdout, "two\n")
+	}
+
+	// A passing run removes it.
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, "echo one\nsnapshot\n"); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+	if _, err := os.Stat(candidate); !os.IsNotExist(err) {
+		t.Errorf("candidate still exists after a passing run: %v", err)
+	}
+}
+
+func TestSnapshotTxtarFormat(t *testing.T) {
+	dir := t.TempDir()
+	cfg := snapshot.Config{Dir: dir, Format: snapshot.FormatTxtar}
+	src := "echo hello\nsnapshot\n"
+
+	// Snapshots recorded as JSON are still read.
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("comparing JSON snapshot: %v\n%s", err, log)
+	}
+
+	// Updating converts them.
+	cfg.Update = true
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("updating snapshot: %v\n%s", err, log)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "script.json")); !os.IsNotExist(err) {
+		t.Errorf("JSON snapshot still exists after conversion: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "script.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "-- stdout --\nhello\n-- stderr --\n"; string(data) != want {
+		t.Errorf("txtar snapshot =\n%s\nwant:\n%s", data, want)
+	}
+
+	cfg.Update = false
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("comparing txtar snapshot: %v\n%s", err, log)
+	}
+	if _, err := runScript(t, cfg, "echo goodbye\nsnapshot\n"); err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+}
+
+func TestSnapshotStructuredJSON(t *testing.T) {
+	dir := t.TempDir()
+	cmd := "snapshot -format=json -ignore=.createdAt\n"
+	src := `echo '{"name": "x", "tags": ["a"], "createdAt": "monday"}'` + "\n" + cmd
+
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	snap, err := snapshot.ReadFile(filepath.Join(dir, "script.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  \"createdAt\": \"<ignored>\",\n  \"name\": \"x\",\n  \"tags\": [\n    \"a\"\n  ]\n}\n"
+	if snap.Stdout != want {
+		t.Errorf("stored stdout =\n%s\nwant:\n%s", snap.Stdout, want)
+	}
+
+	// Key order, formatting and ignored values don't matter.
+	reordered := `echo '{"createdAt":"tuesday","tags":["a"],"name":"x"}'` + "\n" + cmd
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, reordered); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+
+	changed := `echo '{"name": "y", "tags": ["a", "b"]}'` + "\n" + cmd
+	_, err = runScript(t, snapshot.Config{Dir: dir}, changed)
+	if err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+	for _, want := range []string{
+		`.createdAt: removed "<ignored>"`,
+		`.name: "x" -> "y"`,
+		`.tags[1]: added "b"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+
+	if _, err := runScript(t, snapshot.Config{Dir: dir}, "echo not json\n"+cmd); err == nil || !strings.Contains(err.Error(), "not valid JSON") {
+		t.Errorf("snapshot of invalid JSON: err = %v, want invalid JSON error", err)
+	}
+	if _, err := runScript(t, snapshot.Config{Dir: dir}, "echo x\nsnapshot -ignore=.a\n"); err == nil {
+		t.Error("-ignore without -format=json: expected error")
+	}
+}
+
+func TestSnapshotStructuredYAML(t *testing.T) {
+	dir := t.TempDir()
+	cmd := "snapshot -format=yaml -ignore=.id\n"
+	src := "echo '{name: x, id: 7, work: '$WORK'}'\n" + cmd
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if data := readSnapshot(t, dir); !strings.Contains(data, `id: <ignored>\nname: x\nwork: $WORK\n`) {
+		t.Errorf("stored snapshot:\n%s\nwant canonical YAML", data)
+	}
+	reordered := "echo '{work: '$WORK', name: x, id: 8}'\n" + cmd
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, reordered); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+	_, err := runScript(t, snapshot.Config{Dir: dir}, "echo '{name: y, work: '$WORK'}'\n"+cmd)
+	if err == nil || !strings.Contains(err.Error(), `.name: "x" -> "y"`) {
+		t.Errorf("mismatch error = %v, want path-level difference", err)
+	}
+	if _, err := runScript(t, snapshot.Config{Dir: dir}, "echo 'a: [1'\n"+cmd); err == nil || !strings.Contains(err.Error(), "not valid YAML") {
+		t.Errorf("snapshot of invalid YAML: err = %v, want invalid YAML error", err)
+	}
+}
+
+func TestSnapshotStructuredFilters(t *testing.T) {
+	// Filters see the parsed strings, not their escaped JSON text
+	dir := t.TempDir()
+	src := "snapshot-filter 'café took [0-9]+ms' 'café took <DUR>'\n" +
+		`echo '{"msg": "caf\u00e9 took 12ms"}'` + "\nsnapshot -format=json\n"
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if data := readSnapshot(t, dir); !strings.Contains(data, `café took <DUR>`) {
+		t.Errorf("stored snapshot:\n%s\nwant the filter applied", data)
+	}
+}
+
+func TestSnapshotTiming(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	cfg := snapshot.Config{Dir: dir, Update: true, Timing: true}
+	src := "env COLUMNS=100\nexec " + sh + " -c 'echo one; sleep 0.2; echo two >&2; sleep 0.1; echo $WORK'\nsnapshot\n"
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	file := filepath.Join(dir, "script.json")
+	snap, err := snapshot.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (snapshot.Terminal{Width: 100, Height: 24}); snap.Terminal == nil || *snap.Terminal != want {
+		t.Errorf("terminal = %v, want %v", snap.Terminal, want)
+	}
+	var streams []string
+	var stdout string
+	for _, chunk := range snap.Chunks {
+		if len(streams) == 0 || streams[len(streams)-1] != chunk.Stream {
+			streams = append(streams, chunk.Stream)
+		}
+		if chunk.Stream == "stdout" {
+			stdout += chunk.Data
+		}
+		if chunk.Stream == "stderr" && chunk.Time < 0.2 {
+			t.Errorf("stderr chunk at %vs, want after the 0.2s sleep", chunk.Time)
+		}
+	}
+	if want := []string{"stdout", "stderr", "stdout"}; !reflect.DeepEqual(streams, want) {
+		t.Errorf("chunk streams = %v, want %v", streams, want)
+	}
+	if want := "one\n$WORK\n"; stdout != want || snap.Stdout != want {
+		t.Errorf("stdout chunks = %q, stdout = %q, want %q", stdout, snap.Stdout, want)
+	}
+
+	// Timing is not compared, and updating unchanged output keeps it.
+	before, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("updating snapshot: %v\n%s", err, log)
+	}
+	if after, err := os.ReadFile(file); err != nil || string(after) != string(before) {
+		t.Errorf("updating unchanged output rewrote the snapshot:\n%s", after)
+	}
+	cfg.Update = false
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+
+	// Chunks are normalized a line at a time, so a path written in two
+	// pieces is still replaced.
+	dir = t.TempDir()
+	cfg = snapshot.Config{Dir: dir, Update: true, Timing: true}
+	src = "exec " + sh + " -c 'printf %s \"${WORK%/*}\"; sleep 0.1; echo \"/${WORK##*/}\"; printf end'\nsnapshot\n"
+	if log, err := runScript(t, cfg, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if snap, err = snapshot.ReadFile(filepath.Join(dir, "script.json")); err != nil {
+		t.Fatal(err)
+	}
+	want := []snapshot.Chunk{{Stream: "stdout", Data: "$WORK\n"}, {Stream: "stdout", Data: "end"}}
+	if len(snap.Chunks) != len(want) {
+		t.Fatalf("chunks = %+v, want %+v", snap.Chunks, want)
+	}
+	for i, chunk := range snap.Chunks {
+		if chunk.Stream != want[i].Stream || chunk.Data != want[i].Data {
+			t.Errorf("chunk %d = %+v, want %+v", i, chunk, want[i])
+		}
+	}
+	if snap.Chunks[0].Time < 0.1 {
+		t.Errorf("joined chunk at %vs, want the time of its end, after the 0.1s sleep", snap.Chunks[0].Time)
+	}
+
+	// Only exec commands are timed.
+	dir = t.TempDir()
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true, Timing: true}, "echo hi\nsnapshot\n"); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if data := readSnapshot(t, dir); strings.Contains(data, "chunks") {
+		t.Errorf("snapshot of echo has timing:\n%s", data)
+	}
+}
+
+func TestSnapshotInline(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "inline.txt")
+	src := "echo hello\nsnapshot greeting\necho again\nsnapshot -inline\n"
+	if err := os.WriteFile(file, []byte(src+"-- data.txt --\nkept\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := snapshot.Config{Dir: filepath.Join(dir, "__snapshots__"), Format: snapshot.FormatInline}
+
+	// Missing inline snapshots are not created without updating, but left
+	// as candidates for review.
+	if _, err := runScriptAs(t, cfg, file, src); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected missing inline snapshot error, got %v", err)
+	}
+	candidate := snapshot.Candidate(snapshot.InlineFile(file, "greeting"))
+	if snap, err := snapshot.ReadFile(candidate); err != nil || snap.Stdout != "hello\n" {
+		t.Errorf("candidate for missing inline snapshot = %+v, %v", snap, err)
+	}
+
+	cfg.Update = true
+	if log, err := runScriptAs(t, cfg, file, src); err != nil {
+		t.Fatalf("recording snapshots: %v\n%s", err, log)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := src + "-- data.txt --\nkept\n-- __snapshot__/greeting --\nhello\n-- __snapshot__/inline --\nagain\n"
+	if string(data) != want {
+		t.Errorf("script after update =\n%s\nwant:\n%s", data, want)
+	}
+	if entries, err := os.ReadDir(cfg.Dir); err != nil || len(entries) > 0 {
+		t.Errorf("snapshot files left after updating inline snapshots: %v, %v", entries, err)
+	}
+
+	// Sections are used without the inline format too.
+	cfg = snapshot.Config{Dir: cfg.Dir}
+	if log, err := runScriptAs(t, cfg, file, src); err != nil {
+		t.Fatalf("comparing inline snapshot: %v\n%s", err, log)
+	}
+	_, err = runScriptAs(t, cfg, file, strings.Replace(src, "hello", "goodbye", 1))
+	if err == nil || !strings.Contains(err.Error(), "-hello\n+goodbye\n") {
+		t.Errorf("expected inline mismatch with diff, got %v", err)
+	}
+
+	// A mismatch is left for review, which accepts it into the script.
+	inline := snapshot.InlineFile(file, "greeting")
+	if _, err := os.Stat(inline + ".diff"); err != nil {
+		t.Errorf("no diff written for inline mismatch: %v", err)
+	}
+	diff, err := snapshot.DiffCandidate(inline)
+	if err != nil || diff != snapshot.Diff("stdout (snapshot)", "hello\n", "stdout (new)", "goodbye\n") {
+		t.Errorf("DiffCandidate() = %q, %v", diff, err)
+	}
+	if err := snapshot.AcceptCandidate(inline); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Replace(want, "-- __snapshot__/greeting --\nhello\n", "-- __snapshot__/greeting --\ngoodbye\n", 1); string(data) != want {
+		t.Errorf("script after accepting =\n%s\nwant:\n%s", data, want)
+	}
+	if entries, err := os.ReadDir(cfg.Dir); err != nil || len(entries) > 0 {
+		t.Errorf("snapshot files left after accepting: %v, %v", entries, err)
+	}
+}
+
+func TestSnapshotInlineConverts(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "script.txt")
+	src := "echo hello\nsnapshot\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshots := filepath.Join(dir, "__snapshots__")
+	if log, err := runScriptAs(t, snapshot.Config{Dir: snapshots, Update: true}, file, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+
+	// An existing file is still used in the inline format...
+	cfg := snapshot.Config{Dir: snapshots, Format: snapshot.FormatInline}
+	if log, err := runScriptAs(t, cfg, file, src); err != nil {
+		t.Fatalf("comparing snapshot file: %v\n%s", err, log)
+	}
+
+	// ...until updating moves it into the script.
+	cfg.Update = true
+	if log, err := runScriptAs(t, cfg, file, src); err != nil {
+		t.Fatalf("updating snapshot: %v\n%s", err, log)
+	}
+	if _, err := os.Stat(filepath.Join(snapshots, "script.json")); !os.IsNotExist(err) {
+		t.Errorf("snapshot file still exists after moving inline: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := src + "-- __snapshot__/script --\nhello\n"; string(data) != want {
+		t.Errorf("script =\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestSnapshotTree(t *testing.T) {
+	dir := t.TempDir()
+	gen := "mkdir out/sub\ncp stdout out/a.txt\ncp stdout out/sub/b.txt\n"
+	src := "echo $WORK\n" + gen + "snapshot-tree out\n"
+
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, src); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "script"+snapshot.TreeExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "0644 a.txt\n0644 sub/b.txt\n-- a.txt --\n$WORK\n-- sub/b.txt --\n$WORK\n"
+	if runtime.GOOS == "windows" {
+		want = strings.ReplaceAll(want, "0644", "0666")
+	}
+	if string(data) != want {
+		t.Errorf("tree snapshot =\n%s\nwant:\n%s", data, want)
+	}
+
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, src); err != nil {
+		t.Fatalf("comparing snapshot: %v\n%s", err, log)
+	}
+
+	// Tree snapshots are named independently of output snapshots.
+	changed := "echo $WORK\n? snapshot\necho other\n" + gen + "rm out/sub/b.txt\nsnapshot-tree out\n"
+	_, err = runScript(t, snapshot.Config{Dir: dir}, changed)
+	if err == nil {
+		t.Fatal("expected mismatch error, got nil")
+	}
+	for _, want := range []string{"changed: a.txt\n", "-$WORK\n+other\n", "removed: sub/b.txt\n"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+	if _, err := os.Stat(snapshot.Candidate(filepath.Join(dir, "script"+snapshot.TreeExt))); err != nil {
+		t.Errorf("no candidate written on mismatch: %v", err)
+	}
+}
+
+func TestSnapshotNames(t *testing.T) {
+	dir := t.TempDir()
+	src := `echo first
+snapshot
+echo second
+snapshot
+echo named
+snapshot named
+echo path
+snapshot sub/path.json
+`
+	file := filepath.Join(dir, "testdata", "script.txt")
+	if log, err := runScriptAs(t, snapshot.Config{Dir: dir, Update: true}, file, src); err != nil {
+		t.Fatalf("recording snapshots: %v\n%s", err, log)
+	}
+	for file, want := range map[string]string{
+		"script.json":   "first",
+		"script-2.json": "second",
+		"named.json":    "named",
+		filepath.Join("testdata", "sub", "path.json"): "path",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Errorf("reading %s: %v", file, err)
+			continue
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s = %s, want it to contain %q", file, data, want)
+		}
+	}
+
+	// Explicit paths are relative to the script, not the work directory,
+	// which is new for every run
+	if log, err := runScriptAs(t, snapshot.Config{Dir: dir}, file, src); err != nil {
+		t.Errorf("comparing snapshots: %v\n%s", err, log)
+	}
+}
+
+func TestSnapshotDefaultDir(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "testdata", "hello.txt")
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(script, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Scripts linked into a harness record next to the link target.
+	link := filepath.Join(dir, "harness", "hello.txt")
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(script, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	real, err := filepath.EvalSymlinks(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(filepath.Dir(real), "__snapshots__")
+	if got := snapshot.Dir(link); got != want {
+		t.Errorf("Dir(%q) = %q, want %q", link, got, want)
+	}
+}
+
+func TestSnapshotGOOSVariant(t *testing.T) {
+	dir := t.TempDir()
+	variant := filepath.Join(dir, "script."+runtime.GOOS+".json")
+
+	// -goos records the variant for the current platform.
+	if log, err := runScript(t, snapshot.Config{Dir: dir, Update: true}, "echo platform\nsnapshot -goos\n"); err != nil {
+		t.Fatalf("recording snapshot: %v\n%s", err, log)
+	}
+	if _, err := os.Stat(variant); err != nil {
+		t.Fatalf("variant not written: %v", err)
+	}
+
+	// Without -goos, an existing variant is preferred over the generic snapshot.
+	generic := `{"stdout": "generic\n", "stderr": ""}`
+	if err := os.WriteFile(filepath.Join(dir, "script.json"), []byte(generic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, "echo platform\nsnapshot\n"); err != nil {
+		t.Fatalf("comparing against variant: %v\n%s", err, log)
+	}
+	if err := os.Remove(variant); err != nil {
+		t.Fatal(err)
+	}
+	if log, err := runScript(t, snapshot.Config{Dir: dir}, "echo generic\nsnapshot\n"); err != nil {
+		t.Fatalf("comparing against generic snapshot: %v\n%s", err, log)
+	}
+}
@@ -0,0 +1,9 @@
+package snapshot
+
+import "embed"
+
+// Source holds the Go source of this package. The scripttest command writes
+// it into the test harness it generates, which cannot import this module.
+//
+//go:embed diff.go file.go filter.go inline.go name.go refs.go snapshot.go structured.go timing.go tree.go
+var Source embed.FS
@@ -0,0 +1,456 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ignoredValue replaces the values at ignored JSON paths in stored snapshots.
+const ignoredValue = "<ignored>"
+
+// A jsonPath selects values in a JSON document. Each element is an object
+// key, an array index, or "*" or "[]" for every member or element.
+type jsonPath []pathElem
+
+type pathElem struct {
+	key   string // object key, or "*" for every member
+	index int    // array index, or -1 for every element
+	array bool   // whether the element selects array elements
+}
+
+// parseJSONPath parses a path such as .metadata.createdAt, .items[0].id,
+// .items[].id or .labels.* in the style of jq. Keys that are not plain
+// identifiers can be quoted: .annotations["example.com/name"].
+func parseJSONPath(s string) (jsonPath, error) {
+	if !strings.HasPrefix(s, ".") && !strings.HasPrefix(s, "[") {
+		return nil, fmt.Errorf("invalid JSON path %q: must start with . or [", s)
+	}
+	var path jsonPath
+	rest := s
+	for rest != "" {
+		switch {
+		case rest == ".":
+			// The document itself
+			rest = ""
+		case strings.HasPrefix(rest, "[\""):
+			end := strings.Index(rest, "\"]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: unterminated key", s)
+			}
+			key, err := strconv.Unquote(rest[1 : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON path %q: %v", s, err)
+			}
+			path = append(path, pathElem{key: key})
+			rest = rest[end+2:]
+		case strings.HasPrefix(rest, "["):
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: unterminated index", s)
+			}
+			index := -1
+			if end > 1 {
+				n, err := strconv.Atoi(rest[1:end])
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid JSON path %q: bad index %q", s, rest[1:end])
+				}
+				index = n
+			}
+			path = append(path, pathElem{index: index, array: true})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				if strings.HasPrefix(rest, "[") {
+					continue // .["key"] or .[0]
+				}
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", s)
+			}
+			path = append(path, pathElem{key: rest[:end]})
+			rest = rest[end:]
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q", s)
+		}
+	}
+	return path, nil
+}
+
+// ignore replaces the values selected by path in v with ignoredValue.
+// Paths that select nothing are not an error.
+func (path jsonPath) ignore(v any) any {
+	if len(path) == 0 {
+		return ignoredValue
+	}
+	elem, rest := path[0], path[1:]
+	switch v := v.(type) {
+	case map[string]any:
+		if elem.array {
+			return v
+		}
+		for key, val := range v {
+			if elem.key == "*" || elem.key == key {
+				v[key] = rest.ignore(val)
+			}
+		}
+	case []any:
+		if !elem.array {
+			return v
+		}
+		for i, val := range v {
+			if elem.index < 0 || elem.index == i {
+				v[i] = rest.ignore(val)
+			}
+		}
+	}
+	return v
+}
+
+// Structured formats that output can be compared in (see comparison).
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+)
+
+// parse parses output as a single value in the comparison's format, applies
+// normalize to its strings and object keys, and replaces the values at the
+// ignored paths with ignoredValue. Normalizing after parsing means filters
+// cannot break the syntax, and match keys and values without quoting.
+func (cmp comparison) parse(output string, normalize func(string) string) (any, error) {
+	var v any
+	var err error
+	if cmp.format == formatYAML {
+		v, err = parseYAML(output)
+	} else {
+		v, err = parseJSON(output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if normalize != nil {
+		v = normalizeValue(v, normalize)
+	}
+	for _, path := range cmp.ignore {
+		v = path.ignore(v)
+	}
+	return v, nil
+}
+
+// canonical encodes v, as returned by parse, canonically in the
+// comparison's format.
+func (cmp comparison) canonical(v any) string {
+	if cmp.format == formatYAML {
+		return canonicalYAML(v)
+	}
+	return canonicalJSON(v)
+}
+
+// normalizeValue applies normalize to the strings and object keys in v.
+// Numbers changed by normalize become strings.
+func normalizeValue(v any, normalize func(string) string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, val := range v {
+			out[normalize(key)] = normalizeValue(val, normalize)
+		}
+		return out
+	case []any:
+		for i, val := range v {
+			v[i] = normalizeValue(val, normalize)
+		}
+		return v
+	case string:
+		return normalize(v)
+	case json.Number:
+		if n := normalize(string(v)); n != string(v) {
+			return n
+		}
+	}
+	return v
+}
+
+// parseJSON parses output as a single JSON value.
+func parseJSON(output string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(output))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+// parseYAML parses output as a single YAML document. The value has the
+// types parseJSON returns, so that YAML is compared like JSON.
+func parseYAML(output string) (any, error) {
+	dec := yaml.NewDecoder(strings.NewReader(output))
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("no YAML document")
+		}
+		return nil, err
+	}
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after YAML document")
+	}
+	return fromYAML(&doc)
+}
+
+// fromYAML converts a YAML node to the types parseJSON returns: keys become
+// strings, numbers become json.Number and timestamps are kept as written.
+// Numbers JSON can't represent, such as .inf, become strings.
+func fromYAML(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return fromYAML(n.Content[0])
+	case yaml.AliasNode:
+		return fromYAML(n.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := fromYAML(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[n.Content[i].Value] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]any, len(n.Content))
+		for i, elem := range n.Content {
+			v, err := fromYAML(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	}
+	switch n.ShortTag() {
+	case "!!timestamp":
+		return n.Value, nil
+	case "!!int", "!!float":
+		if isJSONNumber(n.Value) {
+			return json.Number(n.Value), nil
+		}
+	}
+	var v any
+	if err := n.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case int:
+		return json.Number(strconv.Itoa(v)), nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return fmt.Sprint(v), nil
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	}
+	return v, nil
+}
+
+// isJSONNumber reports whether s is a number in JSON syntax.
+func isJSONNumber(s string) bool {
+	var n json.Number
+	return json.Unmarshal([]byte(s), &n) == nil && s != "" && s[0] != '"'
+}
+
+// toYAML converts a value returned by parseYAML back to types that encode
+// as YAML numbers.
+func toYAML(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, val := range v {
+			out[key] = toYAML(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, val := range v {
+			out[i] = toYAML(val)
+		}
+		return out
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return string(v)
+	}
+	return v
+}
+
+// canonicalYAML encodes v with sorted keys and consistent indentation.
+func canonicalYAML(v any) string {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(toYAML(v)); err != nil {
+		// v was decoded from YAML, so it can always be encoded
+		panic(err)
+	}
+	enc.Close()
+	return buf.String()
+}
+
+// canonicalJSON encodes v with sorted keys and consistent indentation.
+func canonicalJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		// v was decoded from JSON, so it can always be encoded
+		panic(err)
+	}
+	return buf.String()
+}
+
+// compactJSON encodes v on a single line, for difference reports.
+func compactJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		panic(err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// diffJSON reports the differences between two JSON values, one line per
+// path: "path: old -> new" for changed values and "path: added new" or
+// "path: removed old" for object members and array elements present on
+// one side only. It returns "" if the values are equal.
+func diffJSON(old, new any) string {
+	var b strings.Builder
+	diffValues(&b, "", old, new)
+	return b.String()
+}
+
+func diffValues(b *strings.Builder, path string, old, new any) {
+	switch o := old.(type) {
+	case map[string]any:
+		n, ok := new.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for key := range o {
+			keys = append(keys, key)
+		}
+		for key := range n {
+			if _, ok := o[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ov, inOld := o[key]
+			nv, inNew := n[key]
+			p := path + formatKey(key)
+			switch {
+			case !inOld:
+				fmt.Fprintf(b, "%s: added %s\n", p, compactJSON(nv))
+			case !inNew:
+				fmt.Fprintf(b, "%s: removed %s\n", p, compactJSON(ov))
+			default:
+				diffValues(b, p, ov, nv)
+			}
+		}
+		return
+
+	case []any:
+		n, ok := new.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(o):
+				fmt.Fprintf(b, "%s: added %s\n", p, compactJSON(n[i]))
+			case i >= len(n):
+				fmt.Fprintf(b, "%s: removed %s\n", p, compactJSON(o[i]))
+			default:
+				diffValues(b, p, o[i], n[i])
+			}
+		}
+		return
+
+	default:
+		if equalScalars(old, new) {
+			return
+		}
+	}
+	if path == "" {
+		path = "."
+	}
+	fmt.Fprintf(b, "%s: %s -> %s\n", path, compactJSON(old), compactJSON(new))
+}
+
+// equalScalars reports whether two JSON scalars are equal. Numbers are
+// compared by value, so that 1.0 equals 1.
+func equalScalars(a, b any) bool {
+	if an, ok := a.(json.Number); ok {
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if an == bn {
+			return true
+		}
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+	switch a.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	switch b.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return a == b
+}
+
+// formatKey formats an object key as a path element.
+func formatKey(key string) string {
+	for i, r := range key {
+		if !(r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || i > 0 && '0' <= r && r <= '9') {
+			return "[" + strconv.Quote(key) + "]"
+		}
+	}
+	if key == "" {
+		return `[""]`
+	}
+	return "." + key
+}
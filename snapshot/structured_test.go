@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want jsonPath
+	}{
+		{".", nil},
+		{".metadata.createdAt", jsonPath{{key: "metadata"}, {key: "createdAt"}}},
+		{".items[0].id", jsonPath{{key: "items"}, {index: 0, array: true}, {key: "id"}}},
+		{".items[].id", jsonPath{{key: "items"}, {index: -1, array: true}, {key: "id"}}},
+		{".labels.*", jsonPath{{key: "labels"}, {key: "*"}}},
+		{`.annotations["example.com/name"]`, jsonPath{{key: "annotations"}, {key: "example.com/name"}}},
+		{"[1]", jsonPath{{index: 1, array: true}}},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONPath(tt.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q): %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseJSONPath(%q) = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+	for _, bad := range []string{"metadata", ".a[x]", `.a["b`, ".a..b", ".a[-1]"} {
+		if _, err := parseJSONPath(bad); err == nil {
+			t.Errorf("parseJSONPath(%q): expected error", bad)
+		}
+	}
+}
+
+func TestParseJSONIgnore(t *testing.T) {
+	var ignore []jsonPath
+	for _, p := range []string{".metadata.createdAt", ".items[].id", ".missing.path"} {
+		path, err := parseJSONPath(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ignore = append(ignore, path)
+	}
+	cmp := comparison{format: formatJSON, ignore: ignore}
+	v, err := cmp.parse(`{"metadata": {"name": "x", "createdAt": "2024-01-01"}, "items": [{"id": 1}, {"id": 2, "n": 1.5}]}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+  "items": [
+    {
+      "id": "<ignored>"
+    },
+    {
+      "id": "<ignored>",
+      "n": 1.5
+    }
+  ],
+  "metadata": {
+    "createdAt": "<ignored>",
+    "name": "x"
+  }
+}
+`
+	if got := canonicalJSON(v); got != want {
+		t.Errorf("canonicalJSON =\n%s\nwant:\n%s", got, want)
+	}
+
+	if _, err := parseJSON(`{"a": 1} {"b": 2}`); err == nil {
+		t.Error("parseJSON with two values: expected error")
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	old, err := parseJSON(`{"a": 1, "b": {"c": "x", "gone": true}, "list": [1, 2, 3], "n": 1.0, "key.with.dots": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	new, err := parseJSON(`{"n": 1, "list": [1, 5], "b": {"c": "y", "new": null}, "a": [1], "key.with.dots": 2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `.a: 1 -> [1]
+.b.c: "x" -> "y"
+.b.gone: removed true
+.b.new: added null
+["key.with.dots"]: 1 -> 2
+.list[1]: 2 -> 5
+.list[2]: removed 3
+`
+	if got := diffJSON(old, new); got != want {
+		t.Errorf("diffJSON =\n%s\nwant:\n%s", got, want)
+	}
+	if got := diffJSON(old, old); got != "" {
+		t.Errorf("diffJSON of equal values = %q, want empty", got)
+	}
+	if got := diffJSON("a", "b"); got != `.: "a" -> "b"`+"\n" {
+		t.Errorf("diffJSON of root scalars = %q", got)
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	cmp := comparison{format: formatYAML, ignore: []jsonPath{{{key: "items"}, {index: -1, array: true}, {key: "id"}}}}
+	v, err := cmp.parse(`# comment
+name: /tmp/x
+items:
+  - {id: 1, size: 1.5}
+  - id: 2
+    when: 2024-01-01
+1: one
+big: 18446744073709551615
+inf: .inf
+`, func(s string) string { return strings.ReplaceAll(s, "/tmp", "$TMPDIR") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"1": one
+big: 18446744073709551615
+inf: +Inf
+items:
+  - id: <ignored>
+    size: 1.5
+  - id: <ignored>
+    when: "2024-01-01"
+name: $TMPDIR/x
+`
+	if got := cmp.canonical(v); got != want {
+		t.Errorf("canonical YAML =\n%s\nwant:\n%s", got, want)
+	}
+
+	// YAML values compare like JSON ones
+	j, err := parseJSON(`{"items": [{"id": "<ignored>", "size": 1.50}, {"id": "<ignored>", "when": "2024-01-01"}], "name": "$TMPDIR/x", "1": "one", "big": 18446744073709551615, "inf": "+Inf"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := diffJSON(v, j); d != "" {
+		t.Errorf("YAML and equal JSON differ:\n%s", d)
+	}
+
+	for _, bad := range []string{"", "a: 1\n---\nb: 2\n", "a: [1\n"} {
+		if _, err := parseYAML(bad); err == nil {
+			t.Errorf("parseYAML(%q): expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/scripttestutil/internal/scriptexec"
+	"rsc.io/script"
+)
+
+// A Terminal is the size of the terminal output was recorded for.
+type Terminal struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// A Chunk is a piece of output written by a command, as it was written.
+type Chunk struct {
+	Time   float64 `json:"time"`   // seconds since the command started
+	Stream string  `json:"stream"` // "stdout" or "stderr"
+	Data   string  `json:"data"`
+}
+
+// timedOutput is the output of the last exec command of a script, with
+// the chunks it was written in.
+type timedOutput struct {
+	stdout, stderr string
+	chunks         []Chunk
+}
+
+// timing returns the terminal size and output chunks of the previous
+// command of s, if it was an exec command run with timing enabled. The
+// chunks are normalized like the output, a line at a time.
+func (c *commands) timing(s *script.State) (*Terminal, []Chunk) {
+	c.mu.Lock()
+	out := c.state(s).timed
+	c.mu.Unlock()
+	if out == nil || out.stdout != s.Stdout() || out.stderr != s.Stderr() || len(out.chunks) == 0 {
+		return nil, nil
+	}
+	chunks := lineChunks(out.chunks)
+	for i := range chunks {
+		chunks[i].Data = c.normalize(s, chunks[i].Data)
+	}
+	return terminalSize(s), chunks
+}
+
+// lineChunks returns chunks with each chunk that ends within a line joined
+// with those that complete the line on the same stream, so that filters
+// and paths are matched against whole lines. A joined chunk has the time
+// of the chunk that completes it.
+func lineChunks(chunks []Chunk) []Chunk {
+	var lines []Chunk
+	partial := make(map[string]string) // by stream
+	for _, chunk := range chunks {
+		data := partial[chunk.Stream] + chunk.Data
+		i := strings.LastIndexByte(data, '\n') + 1
+		if i > 0 {
+			lines = append(lines, Chunk{Time: chunk.Time, Stream: chunk.Stream, Data: data[:i]})
+		}
+		partial[chunk.Stream] = data[i:]
+	}
+	last := chunks[len(chunks)-1].Time
+	for _, stream := range []string{"stdout", "stderr"} {
+		if partial[stream] != "" {
+			lines = append(lines, Chunk{Time: last, Stream: stream, Data: partial[stream]})
+		}
+	}
+	return lines
+}
+
+// terminalSize returns the terminal size given by the script's COLUMNS
+// and LINES variables, or 80x24.
+func terminalSize(s *script.State) *Terminal {
+	term := &Terminal{Width: 80, Height: 24}
+	if v, ok := s.LookupEnv("COLUMNS"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			term.Width = n
+		}
+	}
+	if v, ok := s.LookupEnv("LINES"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			term.Height = n
+		}
+	}
+	return term
+}
+
+// execCmd creates an exec command that behaves like the script package's,
+// but also records the chunks its output is written in for the snapshot
+// command.
+func (c *commands) execCmd() script.Cmd {
+	return script.Command(
+		scriptexec.Usage(),
+		func(s *script.State, args ...string) (script.WaitFunc, error) {
+			cmd, err := scriptexec.Command(s, args...)
+			if err != nil {
+				return nil, err
+			}
+			rec := &chunkRecorder{start: time.Now()}
+			var stdout, stderr strings.Builder
+			recStdout, recStderr := rec.writer("stdout"), rec.writer("stderr")
+			cmd.Stdout = io.MultiWriter(&stdout, recStdout)
+			cmd.Stderr = io.MultiWriter(&stderr, recStderr)
+			if err := cmd.Start(); err != nil {
+				return nil, err
+			}
+			return func(s *script.State) (string, string, error) {
+				err := cmd.Wait()
+				recStdout.Flush()
+				recStderr.Flush()
+				out := &timedOutput{stdout: stdout.String(), stderr: stderr.String(), chunks: rec.chunks}
+				c.mu.Lock()
+				c.state(s).timed = out
+				c.mu.Unlock()
+				return out.stdout, out.stderr, err
+			}, nil
+		},
+	)
+}
+
+// A chunkRecorder records the chunks written to a command's stdout and
+// stderr.
+type chunkRecorder struct {
+	start time.Time
+
+	mu     sync.Mutex
+	chunks []Chunk
+}
+
+// writer returns a writer that records what is written to stream as
+// chunks of valid text (see scriptexec.TextWriter). It must be flushed
+// once the command exits.
+func (r *chunkRecorder) writer(stream string) *scriptexec.TextWriter {
+	return scriptexec.NewTextWriter(func(text string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		t := time.Since(r.start).Seconds()
+		r.chunks = append(r.chunks, Chunk{Time: float64(int64(t*1e6)) / 1e6, Stream: stream, Data: text})
+	})
+}
+
+// encodeTiming encodes the terminal size and chunks of a snapshot as the
+// timing section of a txtar snapshot: a "terminal WxH" line followed by a
+// "time stream data" line per chunk, with the data quoted.
+func encodeTiming(term *Terminal, chunks []Chunk) []byte {
+	var b strings.Builder
+	if term != nil {
+		fmt.Fprintf(&b, "terminal %dx%d\n", term.Width, term.Height)
+	}
+	for _, chunk := range chunks {
+		fmt.Fprintf(&b, "%s %s %s\n", strconv.FormatFloat(chunk.Time, 'f', -1, 64), chunk.Stream, strconv.Quote(chunk.Data))
+	}
+	return []byte(b.String())
+}
+
+// decodeTiming decodes a timing section written by encodeTiming.
+func decodeTiming(data []byte) (*Terminal, []Chunk, error) {
+	var term *Terminal
+	var chunks []Chunk
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if size, ok := strings.CutPrefix(line, "terminal "); ok {
+			term = new(Terminal)
+			if _, err := fmt.Sscanf(size, "%dx%d", &term.Width, &term.Height); err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid terminal size %q", i+1, size)
+			}
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, nil, fmt.Errorf("line %d: want time, stream and data", i+1)
+		}
+		t, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid time %q", i+1, fields[0])
+		}
+		data, err := strconv.Unquote(fields[2])
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: invalid data: %v", i+1, err)
+		}
+		chunks = append(chunks, Chunk{Time: t, Stream: fields[1], Data: data})
+	}
+	return term, chunks, nil
+}
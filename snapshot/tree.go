@@ -0,0 +1,292 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/txtar"
+	"rsc.io/script"
+)
+
+// TreeExt is the extension of directory tree snapshot files.
+const TreeExt = ".tree.txtar"
+
+// A Tree is a snapshot of the files in a directory.
+//
+// Trees are stored in txtar format. The archive comment lists every file
+// as "mode path", where mode is the octal permission bits, or "link" for a
+// symbolic link, optionally followed by ",noeol" when the file does not end
+// in a newline or ",base64" when its content is binary. The content of each
+// file follows in a section named after its slash-separated relative path;
+// the section of a link holds its target on a single line.
+type Tree struct {
+	Files []TreeEntry // sorted by Path
+}
+
+// A TreeEntry is a file in a Tree.
+type TreeEntry struct {
+	Path string      // slash-separated path relative to the tree root
+	Mode fs.FileMode // permission bits, plus fs.ModeSymlink for links
+	Data []byte      // file content, or link target
+}
+
+// ReadTree reads the regular files and symbolic links under dir.
+// Directories are implied by the files they contain.
+func ReadTree(dir string) (*Tree, error) {
+	tree := new(Tree)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := TreeEntry{Path: filepath.ToSlash(rel), Mode: info.Mode() & (fs.ModePerm | fs.ModeSymlink)}
+		switch {
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			f.Mode = fs.ModeSymlink | 0777 // link permissions vary by platform
+			f.Data = []byte(filepath.ToSlash(target))
+		case d.Type().IsRegular():
+			if f.Data, err = os.ReadFile(path); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		tree.Files = append(tree.Files, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// MarshalTree encodes tree in the tree snapshot format.
+func MarshalTree(tree *Tree) []byte {
+	a := new(txtar.Archive)
+	var comment bytes.Buffer
+	for _, f := range tree.Files {
+		mode := modeString(f.Mode)
+		var data []byte
+		if f.Mode&fs.ModeSymlink != 0 {
+			data = append(f.Data[:len(f.Data):len(f.Data)], '\n')
+		} else {
+			var flags []string
+			data, flags = encodeSection(f.Data)
+			for _, flag := range flags {
+				mode += "," + flag
+			}
+		}
+		fmt.Fprintf(&comment, "%s %s\n", mode, f.Path)
+		a.Files = append(a.Files, txtar.File{Name: f.Path, Data: data})
+	}
+	a.Comment = comment.Bytes()
+	return txtar.Format(a)
+}
+
+// UnmarshalTree decodes a tree snapshot.
+func UnmarshalTree(data []byte) (*Tree, error) {
+	a := txtar.Parse(data)
+	contents := make(map[string][]byte)
+	for _, f := range a.Files {
+		contents[f.Name] = f.Data
+	}
+
+	tree := new(Tree)
+	for i, line := range strings.Split(strings.TrimSuffix(string(a.Comment), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		flags, path, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing path", i+1)
+		}
+		data, ok := contents[path]
+		if !ok {
+			return nil, fmt.Errorf("line %d: no content for %s", i+1, path)
+		}
+		f := TreeEntry{Path: path}
+		for j, flag := range strings.Split(flags, ",") {
+			switch {
+			case j == 0 && flag == "link":
+				f.Mode = fs.ModeSymlink | 0777
+				data = bytes.TrimSuffix(data, []byte("\n"))
+			case j == 0:
+				perm, err := strconv.ParseUint(flag, 8, 32)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid mode %q", i+1, flag)
+				}
+				f.Mode = fs.FileMode(perm).Perm()
+			default:
+				var err error
+				if data, err = decodeSection(data, flag); err != nil {
+					return nil, fmt.Errorf("line %d: %v", i+1, err)
+				}
+			}
+		}
+		f.Data = data
+		tree.Files = append(tree.Files, f)
+	}
+	sort.Slice(tree.Files, func(i, j int) bool { return tree.Files[i].Path < tree.Files[j].Path })
+	return tree, nil
+}
+
+// ReadTreeFile reads the tree snapshot file name.
+func ReadTreeFile(name string) (*Tree, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := UnmarshalTree(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tree snapshot format in %s: %v", name, err)
+	}
+	return tree, nil
+}
+
+// modeString formats a file mode as it appears in tree snapshots.
+func modeString(mode fs.FileMode) string {
+	if mode&fs.ModeSymlink != 0 {
+		return "link"
+	}
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// DiffTree reports the differences between two trees: files that were
+// added, removed or changed, each followed by a unified diff of its
+// content, and changes of mode. It returns "" if the trees are equal.
+func DiffTree(oldName string, old *Tree, newName string, new *Tree) string {
+	oldFiles := make(map[string]TreeEntry)
+	newFiles := make(map[string]TreeEntry)
+	var paths []string
+	for _, f := range old.Files {
+		oldFiles[f.Path] = f
+		paths = append(paths, f.Path)
+	}
+	for _, f := range new.Files {
+		newFiles[f.Path] = f
+		if _, ok := oldFiles[f.Path]; !ok {
+			paths = append(paths, f.Path)
+		}
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		o, inOld := oldFiles[path]
+		n, inNew := newFiles[path]
+		switch {
+		case !inOld:
+			fmt.Fprintf(&b, "added: %s\n", path)
+		case !inNew:
+			fmt.Fprintf(&b, "removed: %s\n", path)
+		case o.Mode == n.Mode && bytes.Equal(o.Data, n.Data):
+			continue
+		default:
+			fmt.Fprintf(&b, "changed: %s\n", path)
+			if o.Mode != n.Mode {
+				fmt.Fprintf(&b, "mode: %s -> %s\n", modeString(o.Mode), modeString(n.Mode))
+			}
+		}
+		if bytes.Equal(o.Data, n.Data) {
+			continue
+		}
+		if !isText(o.Data) || !isText(n.Data) {
+			fmt.Fprintf(&b, "binary content differs\n")
+			continue
+		}
+		b.WriteString(Diff(path+" ("+oldName+")", string(o.Data), path+" ("+newName+")", string(n.Data)))
+	}
+	return b.String()
+}
+
+// normalizeTree applies the script's normalizers to the content of the
+// text files in tree.
+func (c *commands) normalizeTree(s *script.State, tree *Tree) *Tree {
+	out := &Tree{Files: make([]TreeEntry, len(tree.Files))}
+	for i, f := range tree.Files {
+		if f.Mode&fs.ModeSymlink == 0 && isText(f.Data) {
+			f.Data = []byte(c.normalize(s, string(f.Data)))
+		}
+		out.Files[i] = f
+	}
+	return out
+}
+
+// treeCmd creates the command that records or verifies a directory tree
+func (c *commands) treeCmd() script.Cmd {
+	return script.Command(
+		script.CmdUsage{
+			Summary: "Record the files in a directory",
+			Args:    "dir [name]",
+			Detail: []string{
+				"snapshot-tree compares the files under dir, with their relative",
+				"paths, modes and contents, with the stored tree snapshot. When",
+				"updating snapshots, the tree is written to the snapshot instead.",
+				"",
+				"The snapshot is stored in txtar format as name" + TreeExt + " in the",
+				"snapshot directory, and is named like the snapshots of the snapshot",
+				"command. File contents are normalized the same way as command output.",
+				"",
+				"On mismatch, the added, removed and changed files are reported, each",
+				"with a unified diff of its content.",
+			},
+		},
+		func(s *script.State, args ...string) (script.WaitFunc, error) {
+			if len(args) < 1 || len(args) > 2 {
+				return nil, script.ErrUsage
+			}
+			var name string
+			if len(args) == 2 {
+				name = args[1]
+			}
+
+			filename := locate(c.snapshotFile(s, "snapshot-tree", name, TreeExt), false, false)
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+			}
+
+			tree, err := ReadTree(s.Path(args[0]))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tree: %v", err)
+			}
+			got := c.normalizeTree(s, tree)
+			data := MarshalTree(got)
+
+			if c.cfg.Update {
+				if err := os.WriteFile(filename, data, 0644); err != nil {
+					return nil, fmt.Errorf("failed to write snapshot: %v", err)
+				}
+				return nil, RemoveArtifacts(filename)
+			}
+
+			want, err := ReadTreeFile(filename)
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil, missingError(filename, data)
+				}
+				return nil, fmt.Errorf("failed to read snapshot: %v", err)
+			}
+			if diff := DiffTree("snapshot", c.normalizeTree(s, want), "actual", got); diff != "" {
+				return nil, mismatchError(filename, diff, data)
+			}
+			return nil, RemoveArtifacts(filename)
+		},
+	)
+}
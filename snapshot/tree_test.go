@@ -0,0 +1,114 @@
+package snapshot
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTreeRoundTrip(t *testing.T) {
+	tree := &Tree{Files: []TreeEntry{
+		{Path: "bin/run.sh", Mode: 0755, Data: []byte("#!/bin/sh\necho hi\n")},
+		{Path: "data.bin", Mode: 0644, Data: []byte{0, 1, 2, 0xff}},
+		{Path: "empty", Mode: 0600, Data: []byte{}},
+		{Path: "link", Mode: fs.ModeSymlink | 0777, Data: []byte("bin/run.sh")},
+		{Path: "marker.txt", Mode: 0644, Data: []byte("-- not a file --\n")},
+		{Path: "noeol.txt", Mode: 0644, Data: []byte("no newline")},
+	}}
+	data := MarshalTree(tree)
+	got, err := UnmarshalTree(data)
+	if err != nil {
+		t.Fatalf("UnmarshalTree: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(got, tree) {
+		t.Errorf("round trip =\n%+v\nwant\n%+v\nencoded:\n%s", got, tree, data)
+	}
+
+	want := "0755 bin/run.sh\n0644,base64 data.bin\n0600 empty\nlink link\n0644,base64 marker.txt\n0644,noeol noeol.txt\n"
+	if !strings.HasPrefix(string(data), want) {
+		t.Errorf("encoded tree does not start with file list:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestReadTree(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "b", "c.txt"), "c\n")
+	writeFile(t, filepath.Join(dir, "a.txt"), "a\n")
+	if err := os.MkdirAll(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := ReadTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range tree.Files {
+		paths = append(paths, f.Path)
+	}
+	if want := []string{"a.txt", "b/c.txt"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+}
+
+func TestDiffTree(t *testing.T) {
+	old := &Tree{Files: []TreeEntry{
+		{Path: "changed.txt", Mode: 0644, Data: []byte("one\n")},
+		{Path: "mode.sh", Mode: 0644, Data: []byte("x\n")},
+		{Path: "removed.txt", Mode: 0644, Data: []byte("gone\n")},
+		{Path: "same.txt", Mode: 0644, Data: []byte("same\n")},
+	}}
+	new := &Tree{Files: []TreeEntry{
+		{Path: "added.txt", Mode: 0644, Data: []byte("new\n")},
+		{Path: "changed.txt", Mode: 0644, Data: []byte("two\n")},
+		{Path: "mode.sh", Mode: 0755, Data: []byte("x\n")},
+		{Path: "same.txt", Mode: 0644, Data: []byte("same\n")},
+	}}
+	want := `added: added.txt
+--- added.txt (snapshot)
++++ added.txt (actual)
+@@ -0,0 +1 @@
++new
+changed: changed.txt
+--- changed.txt (snapshot)
++++ changed.txt (actual)
+@@ -1 +1 @@
+-one
++two
+changed: mode.sh
+mode: 0644 -> 0755
+removed: removed.txt
+--- removed.txt (snapshot)
++++ removed.txt (actual)
+@@ -1 +0,0 @@
+-gone
+`
+	if got := DiffTree("snapshot", old, "actual", new); got != want {
+		t.Errorf("DiffTree =\n%s\nwant:\n%s", got, want)
+	}
+	if got := DiffTree("snapshot", old, "actual", old); got != "" {
+		t.Errorf("DiffTree of equal trees = %q, want empty", got)
+	}
+}
+
+func TestReadTreeSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require privileges on windows")
+	}
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "target.txt"), "t\n")
+	if err := os.Symlink("target.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := ReadTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := tree.Files[0]; f.Path != "link" || f.Mode != fs.ModeSymlink|0777 || string(f.Data) != "target.txt" {
+		t.Errorf("link entry = %+v", f)
+	}
+}
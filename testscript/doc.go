@@ -57,6 +57,9 @@ Configure test behavior with Options:
 		"DEBUG": "true",
 		"API_URL": "http://localhost:8080",
 	}
+	opts.SnapshotFilters = []snapshot.Filter{ // Redact volatile snapshot output
+		{Pattern: regexp.MustCompile(`[0-9]+ms`), Replacement: "<DURATION>"},
+	}
 
 # Example with Docker
 
@@ -3,12 +3,17 @@
 package testscript
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/tmc/scripttestutil/commands/docker"
+	"github.com/tmc/scripttestutil/services"
+	"github.com/tmc/scripttestutil/snapshot"
 	"rsc.io/script"
 	"rsc.io/script/scripttest"
 )
@@ -35,7 +40,23 @@ type Options struct {
 
 	// SnapshotDir specifies the directory for snapshots (default: testdata/__snapshots__)
 	SnapshotDir string
-	
+
+	// SnapshotFilters are regexp replacements applied to output before it is
+	// recorded to or compared with a snapshot, in addition to the built-in
+	// $WORK, $HOME and $TMPDIR placeholders
+	SnapshotFilters []snapshot.Filter
+
+	// SnapshotFormat is the format new snapshots are written in:
+	// snapshot.FormatJSON (the default), snapshot.FormatTxtar, or
+	// snapshot.FormatInline to store them in the test scripts themselves.
+	// Snapshots in any format are read.
+	SnapshotFormat string
+
+	// SnapshotTiming records the chunks exec output is written in, with
+	// their times, and the terminal size in new snapshots, so that
+	// "scripttest convert-cast" can reproduce the original pacing
+	SnapshotTiming bool
+
 	// SetupHook is a function called to set up additional commands or conditions
 	// It receives the engine's command map which can be extended with custom commands
 	SetupHook func(cmds map[string]script.Cmd)
@@ -160,9 +181,21 @@ func (r *Runner) runTest(t *testing.T, testFile, testDir string) error {
 	// Start with default script commands
 	cmds := scripttest.DefaultCmds()
 
-	// Create a snapshot handler
-	setupSnapshotCommand(cmds, r.opts.SnapshotDir)
-	
+	// Add the snapshot commands
+	snapshotDir, err := filepath.Abs(r.opts.SnapshotDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve snapshot directory: %v", err)
+	}
+	for name, cmd := range snapshot.Commands(snapshot.Config{
+		Dir:     snapshotDir,
+		Update:  r.opts.UpdateSnapshots,
+		Filters: r.opts.SnapshotFilters,
+		Format:  r.opts.SnapshotFormat,
+		Timing:  r.opts.SnapshotTiming,
+	}) {
+		cmds[name] = cmd
+	}
+
 	// Call the setup hook if provided
 	if r.opts.SetupHook != nil {
 		r.opts.SetupHook(cmds)
@@ -190,73 +223,51 @@ func (r *Runner) runTest(t *testing.T, testFile, testDir string) error {
 		defer cancel()
 	}
 
-	// Create testdata directory and copy the test file
-	testdataDir := filepath.Join(testDir, "testdata")
-	if err := os.MkdirAll(testdataDir, 0755); err != nil {
-		return fmt.Errorf("failed to create testdata directory: %v", err)
+	// Run the test
+	if r.opts.UseDocker {
+		// TODO: Implement Docker support by calling the appropriate functions
+		return fmt.Errorf("Docker support not yet implemented in testscript")
 	}
 
-	// Copy the test file to testdata directory
-	testContent, err := os.ReadFile(testFile)
+	// Unpack the test archive into the test directory
+	s, archive, err := snapshot.NewState(ctx, testDir, testFile, env)
 	if err != nil {
-		return fmt.Errorf("failed to read test file: %v", err)
+		return fmt.Errorf("failed to set up test: %v", err)
 	}
 
-	destFile := filepath.Join(testdataDir, filepath.Base(testFile))
-	if err := os.WriteFile(destFile, testContent, 0644); err != nil {
-		return fmt.Errorf("failed to write test file: %v", err)
+	// Start the services the script declares, until it is done
+	svcs, err := services.FromArchive(archive)
+	if err != nil {
+		return err
 	}
-
-	// If using Docker, add the Docker-specific pattern
-	testPattern := "testdata/" + filepath.Base(testFile)
-
-	// Run the test
-	if r.opts.UseDocker {
-		// TODO: Implement Docker support by calling the appropriate functions
-		return fmt.Errorf("Docker support not yet implemented in testscript")
-	} else {
-		// Change to the test directory
-		oldDir, err := os.Getwd()
+	if len(svcs) > 0 {
+		g, err := services.Start(ctx, svcs)
 		if err != nil {
-			return fmt.Errorf("failed to get current directory: %v", err)
+			return err
 		}
-		if err := os.Chdir(testDir); err != nil {
-			return fmt.Errorf("failed to change to test directory: %v", err)
+		defer func() {
+			if err := g.Stop(); err != nil {
+				t.Error(err)
+			}
+		}()
+		for _, kv := range g.Env() {
+			name, value, _ := strings.Cut(kv, "=")
+			if err := s.Setenv(name, value); err != nil {
+				return fmt.Errorf("failed to set %s: %v", name, err)
+			}
 		}
-		defer os.Chdir(oldDir)
-
-		// Run the test
-		scripttest.Test(t, ctx, engine, env, testPattern)
 	}
 
-	return nil
-}
-
-// setupSnapshotCommand adds the snapshot command to the engine.
-func setupSnapshotCommand(cmds map[string]script.Cmd, snapshotDir string) {
-	cmds["snapshot"] = script.Command(
-		script.CmdUsage{
-			Summary: "Record command output",
-			Args:    "[filename]",
-		},
-		func(s *script.State, args ...string) (script.WaitFunc, error) {
-			if len(args) < 1 {
-				return nil, fmt.Errorf("snapshot command requires a filename argument")
-			}
-			
-			// Create the snapshots directory if it doesn't exist
-			if err := os.MkdirAll(filepath.Dir(args[0]), 0755); err != nil {
-				return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
-			}
+	// Remove the containers the script started with the docker commands
+	// before the test ends, rather than in the background
+	defer func() {
+		if err := docker.Remove(s); err != nil {
+			t.Error(err)
+		}
+	}()
 
-			// Handle snapshot command
-			// This is a simplified implementation
-			// You would need to implement proper snapshot handling here
-			return func(s *script.State) (string, string, error) {
-				return "", "", nil
-			}, nil
-		},
-	)
+	scripttest.Run(t, engine, s, testFile, bytes.NewReader(archive.Comment))
+	return nil
 }
 
 // setupPlatformConditions adds platform-specific conditions to the engine.
@@ -3,9 +3,14 @@ package testscript_test
 import (
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"testing"
 
+	"github.com/tmc/scripttestutil/commands"
+	"github.com/tmc/scripttestutil/snapshot"
 	"github.com/tmc/scripttestutil/testscript"
+	"rsc.io/script"
 )
 
 // TestBasicUsage demonstrates the basic usage of the testscript package.
@@ -102,4 +107,88 @@ env | grep TEST_VAR
 stdout 'TEST_VAR=test_value'
 `
 	os.WriteFile(filepath.Join("testdata", "env.txt"), []byte(envTestContent), 0644)
-}
\ No newline at end of file
+}
+
+// TestSnapshotFilters demonstrates redacting volatile output from snapshots.
+func TestSnapshotFilters(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "filter.txt")
+
+	opts := testscript.DefaultOptions()
+	opts.SnapshotDir = filepath.Join(dir, "__snapshots__")
+	opts.SnapshotFilters = []snapshot.Filter{
+		{Pattern: regexp.MustCompile(`request [0-9a-f]+`), Replacement: "request <ID>"},
+	}
+
+	// Record the snapshot, then verify it against different request IDs
+	os.WriteFile(script, []byte("echo handled request 1f3a in $WORK\nsnapshot\n"), 0644)
+	opts.UpdateSnapshots = true
+	testscript.Run(t, script, opts)
+
+	os.WriteFile(script, []byte("echo handled request 9bc4 in $WORK\nsnapshot\n"), 0644)
+	opts.UpdateSnapshots = false
+	testscript.Run(t, script, opts)
+}
+
+// TestServices demonstrates a script declaring the services it depends on,
+// run with a fake docker command that records what it is asked to do.
+func TestServices(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker command is a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "docker.log")
+	docker := "#!/bin/sh\necho \"$@\" >> " + log + "\n" + `case "$1" in
+port) echo "0.0.0.0:49153" ;;
+inspect) echo "running healthy" ;;
+esac
+`
+	os.WriteFile(filepath.Join(dir, "docker"), []byte(docker), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	script := filepath.Join(dir, "services.txt")
+	os.WriteFile(script, []byte(`env SERVICE_DB_ADDR
+stdout '^SERVICE_DB_ADDR=127.0.0.1:49153$'
+
+-- services.yaml --
+services:
+  db:
+    image: postgres:16
+    ports: ["5432"]
+`), 0644)
+	testscript.RunFile(t, script, testscript.DefaultOptions())
+
+	// The service was removed after the script
+	data, _ := os.ReadFile(log)
+	if !regexp.MustCompile(`(?m)^rm -f -v scripttest-.*-db$`).Match(data) {
+		t.Errorf("docker commands:\n%s\nwant removal of the db service", data)
+	}
+}
+
+// TestDockerCommands demonstrates a script starting a container with the
+// docker commands, which is removed as soon as the script is done.
+func TestDockerCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker command is a shell script")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "docker.log")
+	// Removal is slow, so that it is only done in time if it is waited for
+	docker := "#!/bin/sh\nif [ \"$1\" = rm ]; then sleep 0.2; fi\necho \"$@\" >> " + log + "\n"
+	os.WriteFile(filepath.Join(dir, "docker"), []byte(docker), 0755)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	file := filepath.Join(dir, "docker.txt")
+	os.WriteFile(file, []byte("docker:run db postgres:16\n"), 0644)
+	opts := testscript.DefaultOptions()
+	opts.SetupHook = func(cmds map[string]script.Cmd) {
+		commands.RegisterAll(cmds)
+	}
+	testscript.RunFile(t, file, opts)
+
+	// The container was removed without calling docker.Wait
+	data, _ := os.ReadFile(log)
+	if !regexp.MustCompile(`(?m)^rm -f -v scripttest-.*-db$`).Match(data) {
+		t.Errorf("docker commands:\n%s\nwant removal of the db container", data)
+	}
+}
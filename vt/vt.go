@@ -0,0 +1,679 @@
+// Package vt emulates the screen of a VT100-compatible terminal, such as
+// xterm, so that terminal output can be rendered the way it would be
+// displayed.
+//
+// The emulator implements the control functions programs commonly use to
+// draw on a terminal: cursor movement, erasing, insertion and deletion,
+// scrolling regions, the alternate screen, and colors and attributes
+// (SGR), including 256-color and direct RGB colors. Other sequences are
+// parsed and ignored. Every character occupies a single cell.
+package vt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A ColorType says how a Color is specified.
+type ColorType uint8
+
+const (
+	DefaultColor ColorType = iota // the terminal's default color
+	IndexedColor                  // one of the 256 xterm colors
+	RGBColor                      // a direct RGB color
+)
+
+// A Color is the foreground or background color of a cell.
+type Color struct {
+	Type    ColorType
+	Index   uint8 // for IndexedColor
+	R, G, B uint8 // for RGBColor
+}
+
+// Attr is a set of character attributes.
+type Attr uint8
+
+const (
+	Bold Attr = 1 << iota
+	Faint
+	Italic
+	Underline
+	Inverse
+	Strikethrough
+)
+
+// A Cell is a character on the screen with its colors and attributes.
+type Cell struct {
+	Rune   rune // 0 for a blank cell
+	FG, BG Color
+	Attr   Attr
+}
+
+// A Screen is the state of a terminal screen. Output written to it is
+// interpreted as a terminal would.
+type Screen struct {
+	cols, rows int
+	lines      [][]Cell
+	other      [][]Cell // the inactive main or alternate screen
+	altScreen  bool
+
+	x, y        int
+	pen         Cell // colors and attributes of new characters
+	wrapPending bool // the next character wraps to the next line
+	top, bottom int  // scrolling region, inclusive
+	saved       savedCursor
+	hidden      bool // cursor hidden
+	noWrap      bool // autowrap disabled
+	title       string
+
+	// Parser state
+	state  parserState
+	params []byte // parameter bytes of a control sequence
+	inter  []byte // intermediate bytes of a control sequence
+	str    []byte // data of an operating system command
+	utf    []byte // partial UTF-8 sequence
+}
+
+type savedCursor struct {
+	x, y int
+	pen  Cell
+}
+
+type parserState uint8
+
+const (
+	ground parserState = iota
+	escape
+	escapeInter
+	csi
+	osc
+	oscEscape
+	str
+	strEscape
+)
+
+// maxStr limits the length of operating system commands kept by the parser.
+const maxStr = 4096
+
+// NewScreen returns a blank screen of the given size.
+func NewScreen(cols, rows int) *Screen {
+	s := new(Screen)
+	s.Resize(cols, rows)
+	return s
+}
+
+// Size returns the number of columns and rows of the screen.
+func (s *Screen) Size() (cols, rows int) {
+	return s.cols, s.rows
+}
+
+// Cell returns the cell at column x and row y, counted from 0.
+func (s *Screen) Cell(x, y int) Cell {
+	return s.lines[y][x]
+}
+
+// Cursor returns the position of the cursor and whether it is visible.
+func (s *Screen) Cursor() (x, y int, visible bool) {
+	return s.x, s.y, !s.hidden
+}
+
+// Title returns the window title set by the output, if any.
+func (s *Screen) Title() string {
+	return s.title
+}
+
+// Text returns the characters on the screen, one line per row, without
+// trailing spaces or trailing blank lines.
+func (s *Screen) Text() string {
+	var lines []string
+	for _, line := range s.lines {
+		var b strings.Builder
+		for _, c := range line {
+			if c.Rune == 0 {
+				b.WriteByte(' ')
+			} else {
+				b.WriteRune(c.Rune)
+			}
+		}
+		lines = append(lines, strings.TrimRight(b.String(), " "))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Resize changes the size of the screen, keeping the characters that
+// still fit. The scrolling region is reset.
+func (s *Screen) Resize(cols, rows int) {
+	cols, rows = max(cols, 1), max(rows, 1)
+	resize := func(lines [][]Cell) [][]Cell {
+		if lines == nil {
+			return nil
+		}
+		out := make([][]Cell, rows)
+		for y := range out {
+			out[y] = make([]Cell, cols)
+			if y < len(lines) {
+				copy(out[y], lines[y])
+			}
+		}
+		return out
+	}
+	if s.lines == nil {
+		s.lines = make([][]Cell, 0)
+	}
+	s.lines, s.other = resize(s.lines), resize(s.other)
+	s.cols, s.rows = cols, rows
+	s.top, s.bottom = 0, rows-1
+	s.x, s.y = min(s.x, cols-1), min(s.y, rows-1)
+	s.wrapPending = false
+}
+
+// Write interprets p as terminal output. It never fails.
+func (s *Screen) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.writeByte(b)
+	}
+	return len(p), nil
+}
+
+// WriteString is like Write, but writes the contents of the string str.
+func (s *Screen) WriteString(str string) (int, error) {
+	return s.Write([]byte(str))
+}
+
+func (s *Screen) writeByte(b byte) {
+	switch s.state {
+	case ground:
+		switch {
+		case len(s.utf) > 0 || b >= 0x80:
+			s.utf = append(s.utf, b)
+			for len(s.utf) > 0 && (utf8.FullRune(s.utf) || !utf8.RuneStart(s.utf[0])) {
+				r, size := utf8.DecodeRune(s.utf)
+				s.put(r)
+				s.utf = s.utf[size:]
+			}
+		case b == 0x1b:
+			s.state = escape
+		case b < 0x20 || b == 0x7f:
+			s.control(b)
+		default:
+			s.put(rune(b))
+		}
+
+	case escape:
+		s.state = ground
+		switch b {
+		case '[':
+			s.state, s.params, s.inter = csi, s.params[:0], s.inter[:0]
+		case ']':
+			s.state, s.str = osc, s.str[:0]
+		case 'P', 'X', '^', '_':
+			s.state = str
+		case '7':
+			s.saveCursor()
+		case '8':
+			s.restoreCursor()
+		case 'D':
+			s.index()
+		case 'E':
+			s.x = 0
+			s.index()
+		case 'M':
+			s.reverseIndex()
+		case 'c':
+			cols, rows := s.cols, s.rows
+			*s = Screen{}
+			s.Resize(cols, rows)
+		default:
+			if b >= 0x20 && b <= 0x2f {
+				s.state = escapeInter // such as ESC ( B, which selects a character set
+			}
+		}
+
+	case escapeInter:
+		if b < 0x20 || b > 0x2f {
+			s.state = ground
+		}
+
+	case csi:
+		switch {
+		case b >= 0x30 && b <= 0x3f:
+			s.params = append(s.params, b)
+		case b >= 0x20 && b <= 0x2f:
+			s.inter = append(s.inter, b)
+		case b >= 0x40 && b <= 0x7e:
+			s.state = ground
+			s.dispatch(b)
+		case b == 0x1b:
+			s.state = escape
+		case b < 0x20:
+			s.control(b)
+		}
+
+	case osc:
+		switch {
+		case b == '\a':
+			s.state = ground
+			s.command()
+		case b == 0x1b:
+			s.state = oscEscape
+		case len(s.str) < maxStr:
+			s.str = append(s.str, b)
+		}
+
+	case oscEscape:
+		s.state = ground
+		s.command()
+		if b != '\\' {
+			s.writeByte(0x1b)
+			s.writeByte(b)
+		}
+
+	case str:
+		switch b {
+		case '\a':
+			s.state = ground
+		case 0x1b:
+			s.state = strEscape
+		}
+
+	case strEscape:
+		s.state = str
+		if b == '\\' {
+			s.state = ground
+		}
+	}
+}
+
+// control executes a C0 control character.
+func (s *Screen) control(b byte) {
+	switch b {
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapPending = false
+	case '\t':
+		s.x = min((s.x/8+1)*8, s.cols-1)
+		s.wrapPending = false
+	case '\n', '\v', '\f':
+		s.index()
+	case '\r':
+		s.x = 0
+		s.wrapPending = false
+	}
+}
+
+// command executes an operating system command, of which only setting the
+// window title is supported.
+func (s *Screen) command() {
+	code, arg, _ := strings.Cut(string(s.str), ";")
+	if code == "0" || code == "2" {
+		s.title = arg
+	}
+}
+
+// put writes a character at the cursor and advances it.
+func (s *Screen) put(r rune) {
+	if s.wrapPending {
+		s.x = 0
+		s.index()
+	}
+	cell := s.pen
+	cell.Rune = r
+	s.lines[s.y][s.x] = cell
+	if s.x < s.cols-1 {
+		s.x++
+	} else {
+		s.wrapPending = !s.noWrap
+	}
+}
+
+// blank returns an erased cell, which keeps the current background color.
+func (s *Screen) blank() Cell {
+	return Cell{BG: s.pen.BG}
+}
+
+// index moves the cursor down a line, scrolling at the bottom of the
+// scrolling region.
+func (s *Screen) index() {
+	s.wrapPending = false
+	switch {
+	case s.y == s.bottom:
+		s.scrollUp(s.top, s.bottom, 1)
+	case s.y < s.rows-1:
+		s.y++
+	}
+}
+
+// reverseIndex moves the cursor up a line, scrolling at the top of the
+// scrolling region.
+func (s *Screen) reverseIndex() {
+	s.wrapPending = false
+	switch {
+	case s.y == s.top:
+		s.scrollDown(s.top, s.bottom, 1)
+	case s.y > 0:
+		s.y--
+	}
+}
+
+// scrollUp scrolls rows top to bottom up by n lines.
+func (s *Screen) scrollUp(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	copy(s.lines[top:bottom+1], s.lines[top+n:bottom+1])
+	for y := bottom - n + 1; y <= bottom; y++ {
+		s.lines[y] = s.blankLine()
+	}
+}
+
+// scrollDown scrolls rows top to bottom down by n lines.
+func (s *Screen) scrollDown(top, bottom, n int) {
+	n = min(n, bottom-top+1)
+	copy(s.lines[top+n:bottom+1], s.lines[top:bottom+1-n])
+	for y := top; y < top+n; y++ {
+		s.lines[y] = s.blankLine()
+	}
+}
+
+func (s *Screen) blankLine() []Cell {
+	line := make([]Cell, s.cols)
+	for x := range line {
+		line[x] = s.blank()
+	}
+	return line
+}
+
+// erase blanks the cells from column x0 to x1, exclusive, of row y.
+func (s *Screen) erase(y, x0, x1 int) {
+	for x := max(x0, 0); x < min(x1, s.cols); x++ {
+		s.lines[y][x] = s.blank()
+	}
+}
+
+func (s *Screen) saveCursor() {
+	s.saved = savedCursor{s.x, s.y, s.pen}
+}
+
+func (s *Screen) restoreCursor() {
+	s.x, s.y, s.pen = min(s.saved.x, s.cols-1), min(s.saved.y, s.rows-1), s.saved.pen
+	s.wrapPending = false
+}
+
+// moveTo moves the cursor, keeping it on the screen.
+func (s *Screen) moveTo(x, y int) {
+	s.x = min(max(x, 0), s.cols-1)
+	s.y = min(max(y, 0), s.rows-1)
+	s.wrapPending = false
+}
+
+// parseParams parses the parameters of a control sequence. Each parameter
+// may have sub-parameters separated by colons. Omitted values are 0.
+func parseParams(params string) [][]int {
+	var out [][]int
+	if params == "" {
+		return out
+	}
+	for _, param := range strings.Split(params, ";") {
+		var sub []int
+		for _, v := range strings.Split(param, ":") {
+			n, _ := strconv.Atoi(v)
+			sub = append(sub, n)
+		}
+		out = append(out, sub)
+	}
+	return out
+}
+
+// dispatch executes a control sequence with the given final byte.
+func (s *Screen) dispatch(final byte) {
+	params := string(s.params)
+	private := ""
+	if params != "" && strings.ContainsRune("?<=>", rune(params[0])) {
+		private, params = params[:1], params[1:]
+	}
+	if len(s.inter) > 0 {
+		return // no supported sequence has intermediate bytes
+	}
+	ps := parseParams(params)
+	// arg returns parameter i, or def if it is omitted or 0
+	arg := func(i, def int) int {
+		if i < len(ps) && ps[i][0] > 0 {
+			return ps[i][0]
+		}
+		return def
+	}
+
+	if private != "" {
+		if private == "?" && (final == 'h' || final == 'l') {
+			for _, p := range ps {
+				s.setMode(p[0], final == 'h')
+			}
+		}
+		return
+	}
+
+	switch final {
+	case 'A':
+		top := 0
+		if s.y >= s.top {
+			top = s.top
+		}
+		s.moveTo(s.x, max(s.y-arg(0, 1), top))
+	case 'B', 'e':
+		bottom := s.rows - 1
+		if s.y <= s.bottom {
+			bottom = s.bottom
+		}
+		s.moveTo(s.x, min(s.y+arg(0, 1), bottom))
+	case 'C', 'a':
+		s.moveTo(s.x+arg(0, 1), s.y)
+	case 'D':
+		s.moveTo(s.x-arg(0, 1), s.y)
+	case 'E':
+		s.moveTo(0, s.y+arg(0, 1))
+	case 'F':
+		s.moveTo(0, s.y-arg(0, 1))
+	case 'G', '`':
+		s.moveTo(arg(0, 1)-1, s.y)
+	case 'd':
+		s.moveTo(s.x, arg(0, 1)-1)
+	case 'H', 'f':
+		s.moveTo(arg(1, 1)-1, arg(0, 1)-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			s.erase(s.y, s.x, s.cols)
+			for y := s.y + 1; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		case 1:
+			for y := 0; y < s.y; y++ {
+				s.erase(y, 0, s.cols)
+			}
+			s.erase(s.y, 0, s.x+1)
+		case 2, 3:
+			for y := 0; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			s.erase(s.y, s.x, s.cols)
+		case 1:
+			s.erase(s.y, 0, s.x+1)
+		case 2:
+			s.erase(s.y, 0, s.cols)
+		}
+	case 'L':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollDown(s.y, s.bottom, arg(0, 1))
+		}
+	case 'M':
+		if s.y >= s.top && s.y <= s.bottom {
+			s.scrollUp(s.y, s.bottom, arg(0, 1))
+		}
+	case '@':
+		n := min(arg(0, 1), s.cols-s.x)
+		line := s.lines[s.y]
+		copy(line[s.x+n:], line[s.x:])
+		s.erase(s.y, s.x, s.x+n)
+	case 'P':
+		n := min(arg(0, 1), s.cols-s.x)
+		line := s.lines[s.y]
+		copy(line[s.x:], line[s.x+n:])
+		s.erase(s.y, s.cols-n, s.cols)
+	case 'X':
+		s.erase(s.y, s.x, s.x+arg(0, 1))
+	case 'S':
+		s.scrollUp(s.top, s.bottom, arg(0, 1))
+	case 'T':
+		s.scrollDown(s.top, s.bottom, arg(0, 1))
+	case 'm':
+		s.sgr(ps)
+	case 'r':
+		top, bottom := arg(0, 1)-1, arg(1, s.rows)-1
+		if top < bottom && bottom < s.rows {
+			s.top, s.bottom = top, bottom
+			s.moveTo(0, 0)
+		}
+	case 's':
+		s.saveCursor()
+	case 'u':
+		s.restoreCursor()
+	}
+}
+
+// setMode sets or resets a private (DEC) mode.
+func (s *Screen) setMode(mode int, set bool) {
+	switch mode {
+	case 7:
+		s.noWrap = !set
+	case 25:
+		s.hidden = !set
+	case 47, 1047, 1049:
+		if set == s.altScreen {
+			return
+		}
+		if mode == 1049 && set {
+			s.saveCursor()
+		}
+		if s.other == nil {
+			s.other = make([][]Cell, s.rows)
+			for y := range s.other {
+				s.other[y] = make([]Cell, s.cols)
+			}
+		}
+		s.lines, s.other = s.other, s.lines
+		s.altScreen = set
+		if set && mode != 47 {
+			for y := 0; y < s.rows; y++ {
+				s.erase(y, 0, s.cols)
+			}
+		}
+		if mode == 1049 && !set {
+			s.restoreCursor()
+		}
+	}
+}
+
+// sgr sets the colors and attributes of new characters.
+func (s *Screen) sgr(ps [][]int) {
+	if len(ps) == 0 {
+		ps = [][]int{{0}}
+	}
+	for i := 0; i < len(ps); i++ {
+		p := ps[i]
+		switch n := p[0]; {
+		case n == 0:
+			s.pen = Cell{}
+		case n == 1:
+			s.pen.Attr |= Bold
+		case n == 2:
+			s.pen.Attr |= Faint
+		case n == 3:
+			s.pen.Attr |= Italic
+		case n == 4:
+			s.pen.Attr |= Underline
+		case n == 7:
+			s.pen.Attr |= Inverse
+		case n == 9:
+			s.pen.Attr |= Strikethrough
+		case n == 21 || n == 22:
+			s.pen.Attr &^= Bold | Faint
+		case n == 23:
+			s.pen.Attr &^= Italic
+		case n == 24:
+			s.pen.Attr &^= Underline
+		case n == 27:
+			s.pen.Attr &^= Inverse
+		case n == 29:
+			s.pen.Attr &^= Strikethrough
+		case n >= 30 && n <= 37:
+			s.pen.FG = Color{Type: IndexedColor, Index: uint8(n - 30)}
+		case n == 39:
+			s.pen.FG = Color{}
+		case n >= 40 && n <= 47:
+			s.pen.BG = Color{Type: IndexedColor, Index: uint8(n - 40)}
+		case n == 49:
+			s.pen.BG = Color{}
+		case n >= 90 && n <= 97:
+			s.pen.FG = Color{Type: IndexedColor, Index: uint8(n - 90 + 8)}
+		case n >= 100 && n <= 107:
+			s.pen.BG = Color{Type: IndexedColor, Index: uint8(n - 100 + 8)}
+		case n == 38 || n == 48:
+			var c Color
+			var ok bool
+			if len(p) > 1 {
+				// Colon form: 38:5:n or 38:2:[colorspace:]r:g:b
+				c, ok = extendedColor(p[1:], true)
+			} else {
+				// Semicolon form: 38;5;n or 38;2;r;g;b
+				var rest []int
+				for _, q := range ps[i+1:] {
+					rest = append(rest, q[0])
+				}
+				var used int
+				c, ok, used = extendedColorArgs(rest)
+				i += used
+			}
+			if ok && n == 38 {
+				s.pen.FG = c
+			} else if ok {
+				s.pen.BG = c
+			}
+		}
+	}
+}
+
+// extendedColor parses the sub-parameters of a colon-separated extended
+// color. colorspace reports whether 2 (RGB) may include a color space ID.
+func extendedColor(p []int, colorspace bool) (Color, bool) {
+	switch {
+	case len(p) >= 2 && p[0] == 5:
+		return Color{Type: IndexedColor, Index: uint8(p[1])}, true
+	case len(p) >= 5 && p[0] == 2 && colorspace:
+		return Color{Type: RGBColor, R: uint8(p[2]), G: uint8(p[3]), B: uint8(p[4])}, true
+	case len(p) >= 4 && p[0] == 2:
+		return Color{Type: RGBColor, R: uint8(p[1]), G: uint8(p[2]), B: uint8(p[3])}, true
+	}
+	return Color{}, false
+}
+
+// extendedColorArgs parses a semicolon-separated extended color and
+// reports how many parameters it used.
+func extendedColorArgs(p []int) (Color, bool, int) {
+	switch {
+	case len(p) >= 2 && p[0] == 5:
+		c, ok := extendedColor(p[:2], false)
+		return c, ok, 2
+	case len(p) >= 4 && p[0] == 2:
+		c, ok := extendedColor(p[:4], false)
+		return c, ok, 4
+	}
+	return Color{}, false, len(p)
+}
@@ -0,0 +1,97 @@
+package vt
+
+import "testing"
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{"plain", "hello\r\nworld", "hello\nworld"},
+		{"newline keeps column", "ab\ncd", "ab\n  cd"},
+		{"carriage return", "50%\r100%", "100%"},
+		{"backspace", "lx\b \bs", "ls"},
+		{"tab", "a\tb", "a      b"}, // the last tab stop is the right margin
+		{"utf8", "h\xc3\xa9llo ❯", "héllo ❯"},
+		{"colors", "\x1b[1;31merr\x1b[0m: x", "err: x"},
+		{"title", "\x1b]0;user@host\a$ ls", "$ ls"},
+		{"charset", "\x1b(Bx", "x"},
+		{"autowrap", "abcdefghij", "abcdefgh\nij"},
+		{"pending wrap", "abcdefgh\r\nx", "abcdefgh\nx"},
+		{"no autowrap", "\x1b[?7labcdefghij", "abcdefgj"},
+		{"cursor position", "\x1b[2;3Hx\x1b[1;1Hy", "y\n  x"},
+		{"cursor movement", "abc\x1b[2Dx\x1b[Cy\x1b[Bz", "axcy\n    z"},
+		{"column", "abc\x1b[1Gz", "zbc"},
+		{"erase line", "hello\r\x1b[Kbye", "bye"},
+		{"erase line start", "hello\x1b[3D\x1b[1K", "   lo"},
+		{"erase display", "a\r\nb\r\nc\x1b[2;1H\x1b[J", "a"},
+		{"clear screen", "a\r\nb\x1b[2J\x1b[Hc", "c"},
+		{"insert chars", "abc\r\x1b[2@", "  abc"},
+		{"delete chars", "abcdef\r\x1b[2P", "cdef"},
+		{"erase chars", "abcdef\r\x1b[2X", "  cdef"},
+		{"scroll", "1\r\n2\r\n3\r\n4\r\n5", "2\n3\n4\n5"},
+		{"scroll region", "top\x1b[2;3r\x1b[3;1H1\r\n2\r\n3", "top\n2\n3"},
+		{"insert lines", "1\r\n2\r\n3\x1b[2;1H\x1b[L", "1\n\n2\n3"},
+		{"delete lines", "1\r\n2\r\n3\x1b[1;1H\x1b[M", "2\n3"},
+		{"reverse index", "1\x1b[H\x1bMx", "x\n1"},
+		{"save restore", "ab\x1b7\x1b[3;1Hcd\x1b8e", "abe\n\ncd"},
+		{"alternate screen", "main\x1b[?1049hfull screen\x1b[?1049l!", "main!"},
+		{"reset", "abc\x1bcd", "d"},
+		{"unterminated", "a\x1b[", "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScreen(8, 4)
+			s.WriteString(tt.data)
+			if got := s.Text(); got != tt.want {
+				t.Errorf("after %q, Text() = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	s := NewScreen(20, 2)
+	for _, b := range []byte("\x1b[31mé\x1b]0;t\x1b\\x") {
+		s.Write([]byte{b})
+	}
+	if got := s.Text(); got != "éx" {
+		t.Errorf("Text() = %q, want %q", got, "éx")
+	}
+	if got := s.Cell(0, 0).FG; got != (Color{Type: IndexedColor, Index: 1}) {
+		t.Errorf("foreground = %+v, want red", got)
+	}
+	if s.Title() != "t" {
+		t.Errorf("Title() = %q, want %q", s.Title(), "t")
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	s := NewScreen(20, 2)
+	s.WriteString("\x1b[1;4;7;91;48;5;200ma\x1b[22;24;27;38;2;1;2;3;49mb\x1b[38:2::4:5:6;3mc\x1b[0md")
+	tests := []struct {
+		x    int
+		want Cell
+	}{
+		{0, Cell{Rune: 'a', FG: Color{Type: IndexedColor, Index: 9}, BG: Color{Type: IndexedColor, Index: 200}, Attr: Bold | Underline | Inverse}},
+		{1, Cell{Rune: 'b', FG: Color{Type: RGBColor, R: 1, G: 2, B: 3}}},
+		{2, Cell{Rune: 'c', FG: Color{Type: RGBColor, R: 4, G: 5, B: 6}, Attr: Italic}},
+		{3, Cell{Rune: 'd'}},
+	}
+	for _, tt := range tests {
+		if got := s.Cell(tt.x, 0); got != tt.want {
+			t.Errorf("Cell(%d, 0) = %+v, want %+v", tt.x, got, tt.want)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	s := NewScreen(10, 5)
+	s.WriteString("\x1b[3;4H\x1b[?25l")
+	if x, y, visible := s.Cursor(); x != 3 || y != 2 || visible {
+		t.Errorf("Cursor() = %d, %d, %v, want 3, 2, false", x, y, visible)
+	}
+	s.Resize(2, 2)
+	if x, y, _ := s.Cursor(); x != 1 || y != 1 {
+		t.Errorf("after Resize, Cursor() = %d, %d, want 1, 1", x, y)
+	}
+}